@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"github.com/miekg/dns"
@@ -18,7 +18,7 @@ being 3b8982c on Oct 15, 2021.
 //
 // * isn't a request (don't respond in that case)
 //
-// * opcode isn't OpcodeQuery or OpcodeNotify
+// * opcode isn't OpcodeQuery or OpcodeNotify, or OpcodeUpdate if --UPDATE-allow is set
 //
 // * Zero bit isn't zero
 //
@@ -42,8 +42,12 @@ func (t *server) customMsgAcceptFunc(dh dns.Header) dns.MsgAcceptAction {
 		return dns.MsgIgnore
 	}
 
-	// Don't allow dynamic updates, because then the sections can contain a whole bunch of RRs.
+	// Only allow dynamic updates if configured. As their sections can contain a whole bunch
+	// of RRs, they bypass the section count checks and are validated by serveUpdate().
 	opcode := int(dh.Bits>>11) & 0xF
+	if opcode == dns.OpcodeUpdate && t.updates != nil {
+		return dns.MsgAccept
+	}
 	if opcode != dns.OpcodeQuery && opcode != dns.OpcodeNotify {
 		t.addAcceptError()
 		return dns.MsgRejectNotImplemented
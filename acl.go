@@ -0,0 +1,79 @@
+package autoreverse
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnsutil"
+)
+
+// keyedACL is one --AXFR-allow or --UPDATE-allow entry.
+type keyedACL struct {
+	ipNet   *net.IPNet
+	keyName string // Required TSIG key, if set
+}
+
+// parseKeyedACL converts CIDR[=key-name] strings into an ACL. A plain address is
+// accepted as a host CIDR. Any key named must be present in keys and if keyRequired is
+// true, every entry must name a key.
+func parseKeyedACL(option string, specs []string, keys map[string]*dnsutil.TSIGKey, keyRequired bool) ([]*keyedACL, error) {
+	var acls []*keyedACL
+	for _, s := range specs {
+		cidr, keyName, _ := strings.Cut(s, "=")
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("%s %w", option, err)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		acl := &keyedACL{ipNet: ipNet}
+		if len(keyName) > 0 {
+			acl.keyName = dns.CanonicalName(keyName)
+			if _, ok := keys[acl.keyName]; !ok {
+				return nil, fmt.Errorf("%s %s refers to unknown --TSIG-key %s", option, s, keyName)
+			}
+		} else if keyRequired {
+			return nil, fmt.Errorf("%s %s must be of the form CIDR=key-name", option, s)
+		}
+		acls = append(acls, acl)
+	}
+
+	return acls, nil
+}
+
+// aclAllows returns true if the source address is in the ACL and if the matching ACL
+// entry requires a TSIG key, the query was successfully verified with that key.
+func (t *server) aclAllows(wtr dns.ResponseWriter, req *request, acls []*keyedACL) bool {
+	ip := req.srcIP()
+	if ip == nil {
+		return false
+	}
+
+	tsig := req.query.IsTsig()
+	for _, acl := range acls {
+		if !acl.ipNet.Contains(ip) {
+			continue
+		}
+		if len(acl.keyName) == 0 {
+			return true
+		}
+		key := t.cfg.tsigKeys[acl.keyName]
+		if tsig != nil && key != nil && wtr.TsigStatus() == nil &&
+			dns.CanonicalName(tsig.Hdr.Name) == key.Name &&
+			dns.CanonicalName(tsig.Algorithm) == key.Algorithm {
+			return true
+		}
+	}
+
+	return false
+}
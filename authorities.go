@@ -1,6 +1,7 @@
-package main
+package autoreverse
 
 import (
+	"fmt"
 	"net"
 	"sort"
 	"strings"
@@ -14,8 +15,9 @@ import (
 
 type authority struct {
 	delegation.Authority
-	forward bool // Whether a forward or reverse authority
-	cidr    *net.IPNet
+	forward   bool // Whether a forward or reverse authority
+	cidr      *net.IPNet
+	ptrSuffix string // Reverse only: forward domain of synthetic PTRs
 }
 
 func newAuthority(da *delegation.Authority, forward bool) *authority {
@@ -26,6 +28,11 @@ func newAuthority(da *delegation.Authority, forward bool) *authority {
 	return auth
 }
 
+// classless returns true if the authority is an RFC2317 classless reverse zone.
+func (t *authority) classless() bool {
+	return !t.forward && dnsutil.IsClassless(t.cidr)
+}
+
 var soaTime = time.Now() // Set here so tests can over-ride
 
 func (t *authority) synthesizeSOA(mboxDomain string, TTLAsSecs uint32) {
@@ -118,6 +125,10 @@ func (t *authorities) findInDomain(qName string) *authority {
 
 // findIPInDomain finds the matching reverse authority which contains the
 // IP. Return nil if not found.
+//
+// A reverse authority carries the precise CIDR served by its zone, which for ipv6 CIDRs
+// split by dnsutil.NibbleCIDRs() is the nibble-aligned subset rather than the configured
+// CIDR. Either way, an ip outside the configured CIDR never matches.
 func (t *authorities) findIPInDomain(ip net.IP) *authority {
 	for _, auth := range t.slice {
 		if !auth.forward && auth.cidr.Contains(ip) {
@@ -127,3 +138,35 @@ func (t *authorities) findIPInDomain(ip net.IP) *authority {
 
 	return nil
 }
+
+// reverseCIDRs returns the CIDRs of all reverse authorities.
+func (t *authorities) reverseCIDRs() (cidrs []*net.IPNet) {
+	for _, auth := range t.slice {
+		if !auth.forward && auth.cidr != nil && auth.cidr.IP != nil {
+			cidrs = append(cidrs, auth.cidr)
+		}
+	}
+
+	return
+}
+
+// findClassless converts a regular ipv4 reverse qName such as 65.2.0.192.in-addr.arpa into
+// the equivalent qName within a matching RFC2317 classless authority, such as
+// 65.64/27.2.0.192.in-addr.arpa. This mirrors the CNAME served by the parent and is how
+// PTRs with regular owner names find their way into classless zones. Return nil if there
+// is no matching classless authority.
+func (t *authorities) findClassless(qName string) (*authority, string) {
+	if !strings.HasSuffix(qName, dnsutil.V4Suffix) {
+		return nil, ""
+	}
+	ip, truncated, err := dnsutil.InvertPtrToIPv4(strings.TrimSuffix(qName, dnsutil.V4Suffix))
+	if err != nil || truncated {
+		return nil, ""
+	}
+	auth := t.findIPInDomain(ip)
+	if auth == nil || !auth.classless() {
+		return nil, ""
+	}
+
+	return auth, fmt.Sprintf("%d.%s", ip.To4()[3], auth.Domain)
+}
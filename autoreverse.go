@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"crypto/rand"
@@ -21,24 +21,30 @@ import (
 type autoReverse struct {
 	cfg *config
 
-	done        chan struct{} // All collaborative go-routines should monitor - see Done()
-	forceReload chan struct{} // Tell watcher to forcefully reload
+	done        chan struct{}    // All collaborative go-routines should monitor - see Done()
+	forceReload chan struct{}    // Tell watcher to forcefully reload
+	reload      chan string      // Tell watcher to reload with trigger reason, e.g. NOTIFY
+	zoneReload  chan zoneTrigger // Tell watcher to reload one PTRZone, e.g. docker event
 	sig         chan os.Signal
 
-	resolver   resolver.Resolver
-	dbGetter   *database.Getter
-	rrlHandler *rrl.RRL
+	resolver    resolver.Resolver
+	dbGetter    *database.Getter
+	rrlHandler  *rrl.RRL
+	updates     *updateOverlay // Set if --UPDATE-allow is present
+	zonesLoaded bool           // Set once loadAllZones first succeeds
 
 	wg      sync.WaitGroup // For all servers started
 	servers []*server
 
-	startTime        time.Time
-	statsTime        time.Time  // Last time stats were reset
-	forward          string     // Canonical forward domain name
-	forwardAuthority *authority // Could be either delegated or local
+	startTime          time.Time
+	statsTime          time.Time             // Last time stats were reset
+	forward            string                // Canonical default forward domain name
+	forwardAuthority   *authority            // Default forward - either delegated or local
+	forwardAuthorities map[string]*authority // All forwards, including the default
 
 	delegatedReverses []*net.IPNet
 	localReverses     []*net.IPNet
+	reverseForwards   map[string]string // CIDR=forward mappings keyed by CIDR
 
 	authorities // Contains all authorities, including forward
 }
@@ -48,9 +54,14 @@ func newAutoReverse(cfg *config, r resolver.Resolver) *autoReverse {
 		cfg:         cfg,
 		done:        make(chan struct{}),
 		forceReload: make(chan struct{}),
+		reload:      make(chan string, 1), // Buffered so senders never block
+		zoneReload:  make(chan zoneTrigger, zoneReloadQueue),
 		sig:         make(chan os.Signal),
 		resolver:    r,
 		dbGetter:    database.NewGetter(),
+
+		forwardAuthorities: make(map[string]*authority),
+		reverseForwards:    make(map[string]string),
 	}
 	if t.cfg == nil {
 		t.cfg = newConfig()
@@ -73,6 +84,42 @@ func (t *autoReverse) addAuthority(add *authority) bool {
 	return t.authorities.append(add)
 }
 
+// addForwardAuthority adds a forward authority and also makes it the default forward
+// authority if it matches the default forward domain or if there is no default as yet.
+func (t *autoReverse) addForwardAuthority(add *authority) bool {
+	if t.forwardAuthority == nil || add.Domain == t.forward {
+		t.forwardAuthority = add
+	}
+	t.forwardAuthorities[add.Domain] = add
+
+	return t.addAuthority(add)
+}
+
+// reverseForward returns the forward domain mapped to the reverse CIDR with
+// --reverse CIDR=forward or the default forward domain if there is no mapping.
+func (t *autoReverse) reverseForward(ipNet *net.IPNet) string {
+	if forward, ok := t.reverseForwards[ipNet.String()]; ok {
+		return forward
+	}
+
+	return t.forward
+}
+
+// mappedForwards returns the unique forward domains mapped to the reverse CIDRs in the
+// order in which they are first mapped. The default forward is not included.
+func (t *autoReverse) mappedForwards(ipNets []*net.IPNet) (forwards []string) {
+	seen := make(map[string]bool)
+	for _, ipNet := range ipNets {
+		forward, ok := t.reverseForwards[ipNet.String()]
+		if ok && !seen[forward] {
+			seen[forward] = true
+			forwards = append(forwards, forward)
+		}
+	}
+
+	return
+}
+
 // Spin up the rrlHandler and return true if the config has meaningful rate limits set. If
 // the config is effectively a no-op, do not create the rrlHandler.
 func (t *autoReverse) activateRRL() bool {
@@ -106,6 +153,8 @@ func (t *autoReverse) startServers() {
 		for _, addr := range t.cfg.listen {
 			srv := newServer(t.cfg, t.dbGetter, t.resolver, t.rrlHandler, network, addr)
 			srv.cookieSecrets = cookieSecrets // All servers get the same secret
+			srv.reload = t.reload
+			srv.updates = t.updates
 			err := t.startServer(srv)
 			if err != nil {
 				fatal(err)
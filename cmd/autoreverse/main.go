@@ -0,0 +1,12 @@
+// Command autoreverse is an authoritative DNS server for reverse zones. All of the
+// server is in the autoreverse package so that it can be embedded, such as with
+// additional --PTR-deduce sources, and this is its standard command.
+package main
+
+import (
+	"github.com/markdingo/autoreverse"
+)
+
+func main() {
+	autoreverse.Main()
+}
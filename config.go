@@ -1,15 +1,20 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
+	"net"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/markdingo/rrl"
 	"github.com/miekg/dns"
 
+	"github.com/markdingo/autoreverse/database"
+	"github.com/markdingo/autoreverse/dnssec"
 	"github.com/markdingo/autoreverse/dnsutil"
 	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/ptrsource"
 	"github.com/markdingo/autoreverse/resolver"
 )
 
@@ -23,7 +28,9 @@ const (
 	defaultService = "domain"
 	defaultListen  = ":" + defaultService
 
-	reloadInterval        = time.Minute * 10 // How often zone reloads are checked
+	reloadInterval        = time.Minute * 10   // How often zone reloads are checked
+	defaultMaxStale       = time.Hour * 24 * 7 // Last-good limit of PTRZones without an SOA
+	maxStaleParam         = "max-stale"        // URL parameter which overrides staleness limit
 	defaultReportInterval = time.Hour
 )
 
@@ -31,25 +38,20 @@ var (
 	defaultTTL = uint32(time.Hour.Seconds()) // One hour for synthetic PTRs
 )
 
-type loadScheme int
-
-const (
-	fileScheme loadScheme = iota
-	httpScheme
-	axfrScheme
-)
-
-// PTRZone manages the loading and reloading of PTR-deduce URLs.
+// PTRZone manages the loading and reloading of PTR-deduce URLs. All scheme-specific state
+// lives in the source, PTRZone only holds the state common to every scheme.
 type PTRZone struct {
-	resolver                 resolver.Resolver // Convenience copy of system-wide resolver
-	url                      string            // From command line option
-	host, port, path, domain string            // Extracted from url.Parse()
-	scheme                   loadScheme
-
-	soa               dns.SOA   // Results of parsing
-	dtm               time.Time // Last modified or last loaded
-	loadTime          time.Time
+	resolver resolver.Resolver // Convenience copy of system-wide resolver
+	url      string            // From command line option
+	source   ptrsource.Source  // Loads and checks for changes
+
+	soa               dns.SOA // Of the zone, if the first RR loaded is an SOA
 	lines, added, oob int
+
+	maxStale     time.Duration      // Overrides staleLimit() if set
+	lastGood     *database.Database // Data of the last successful load, nil if dropped
+	lastGoodTime time.Time          // Zero if never loaded
+	failing      bool               // Last load failed so lastGood is aging
 }
 
 // rrlConfigStrings separates out the RRL options from all the rest for easy management
@@ -103,6 +105,28 @@ type config struct {
 	localReverse     []string // Local reverses with empty delegation
 
 	PTRDeduceURLs []string // Load zones from these URLs
+	PTRTemplates  []string // Synthetic PTR name templates
+	PTRKeyFile    string   // Key for {v4keyed} and {v6keyed} templates
+	PTREthersFile string   // ethers(5) file naming EUI-64 addresses
+
+	ptrEthers      map[string]string             // Populated from PTREthersFile, keyed by MAC address
+	ptrEthersHosts map[string][]net.HardwareAddr // Inverse of ptrEthers, keyed by hostname
+
+	ptrTemplate4 *dnsutil.PTRTemplate // Populated from PTRTemplates, nil means default
+	ptrTemplate6 *dnsutil.PTRTemplate
+
+	DNSSECKeyFiles []string       // Online signing keys
+	dnssecSigner   *dnssec.Signer // Populated from DNSSECKeyFiles, nil means unsigned
+
+	TSIGKeyFiles []string                    // Files of BIND-style TSIG key statements
+	tsigKeys     map[string]*dnsutil.TSIGKey // Populated from TSIGKeyFiles, keyed by name
+
+	AXFRAllow []string    // Transfer ACL of CIDR[=key-name]
+	axfrACL   []*keyedACL // Populated from AXFRAllow
+
+	UpdateAllow   []string    // UPDATE ACL of CIDR=key-name
+	updateACL     []*keyedACL // Populated from UpdateAllow
+	UpdateJournal string      // Path of UPDATE journal, relative to --chroot
 
 	listen []string // All addresses to listen on
 
@@ -126,6 +150,79 @@ func newConfig() *config {
 	return t
 }
 
+var (
+	defaultPTRTemplate4, _ = dnsutil.NewPTRTemplate(dnsutil.DefaultPTRTemplate4)
+	defaultPTRTemplate6, _ = dnsutil.NewPTRTemplate(dnsutil.DefaultPTRTemplate6)
+)
+
+// ptrTemplate returns the --PTR-template appropriate for the address family or the
+// default template if none was configured.
+func (t *config) ptrTemplate(ipv4 bool) *dnsutil.PTRTemplate {
+	if ipv4 {
+		if t.ptrTemplate4 != nil {
+			return t.ptrTemplate4
+		}
+		return defaultPTRTemplate4
+	}
+	if t.ptrTemplate6 != nil {
+		return t.ptrTemplate6
+	}
+
+	return defaultPTRTemplate6
+}
+
+// ethersName returns the --PTR-ethers name of an EUI-64 address, qualified with the
+// forward zone, or an empty string if the address is not EUI-64 or its MAC is unknown.
+func (t *config) ethersName(ip net.IP, forward string) string {
+	if t.ptrEthers == nil {
+		return ""
+	}
+	mac := dnsutil.EUI64ToMAC(ip)
+	if mac == nil {
+		return ""
+	}
+	name := t.ptrEthers[mac.String()]
+	if len(name) == 0 {
+		return ""
+	}
+
+	return name + "." + forward
+}
+
+// ethersAddresses is the inverse of ethersName. It returns the EUI-64 addresses within
+// the cidrs of the MACs of the --PTR-ethers hostname of qName. Only cidrs of /64 or
+// longer are considered as they are the only ones which determine the prefix.
+func (t *config) ethersAddresses(qName, forward string, cidrs []*net.IPNet) (ips []net.IP) {
+	macs := t.ptrEthersHosts[strings.TrimSuffix(qName, "."+forward)]
+	if len(macs) == 0 {
+		return nil
+	}
+	for _, cidr := range cidrs {
+		ones, bits := cidr.Mask.Size()
+		if bits != 8*net.IPv6len || ones < 64 {
+			continue
+		}
+		for _, mac := range macs {
+			ip := dnsutil.MACToEUI64(cidr.IP, mac)
+			if ip != nil && cidr.Contains(ip) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	return
+}
+
+// tsigSecrets returns the TSIG keys in the form needed by miekg servers and clients.
+func (t *config) tsigSecrets() map[string]string {
+	m := make(map[string]string)
+	for name, key := range t.tsigKeys {
+		m[name] = key.Secret
+	}
+
+	return m
+}
+
 func (t *config) generateNSIDOpt() {
 	// Prepopulate our NSID opt
 	t.nsidOpt.Hdr.Name = "."
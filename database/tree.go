@@ -26,8 +26,9 @@ type labelMap map[string]*node
 // Database is constructed with NewDatabase() - using a default construction will result
 // in a panic due to unconstructed maps.
 type Database struct {
-	cm    classMap
-	count int // RRs added
+	cm     classMap
+	count  int    // RRs added
+	serial uint32 // Generation serial, zero if never set
 }
 
 // NewDatabase *must* be used to construct a new database
@@ -146,6 +147,78 @@ func (t *Database) LookupRR(qClass, qType uint16, qName string) (ans []dns.RR, n
 	return
 }
 
+// Types returns the types of all RRs owned by qName, in no particular order. Nil is
+// returned if there are none, which includes qName being an empty non-terminal.
+func (t *Database) Types(qClass uint16, qName string) (types []uint16) {
+	qName = dnsutil.ChompCanonicalName(qName)
+	labels := strings.Split(qName, ".")
+	parent := t.cm[qClass]
+	if parent == nil {
+		return
+	}
+	for ix := len(labels) - 1; ix >= 0; ix-- {
+		if parent.children == nil {
+			return
+		}
+		parent = parent.children[labels[ix]]
+		if parent == nil {
+			return
+		}
+	}
+
+	for qType := range parent.tm {
+		types = append(types, qType)
+	}
+
+	return
+}
+
+// Walk calls fn with a copy of every RR owned by qName or any name below qName. The order
+// of RRs is arbitrary.
+func (t *Database) Walk(qClass uint16, qName string, fn func(rr dns.RR)) {
+	qName = dnsutil.ChompCanonicalName(qName)
+	labels := strings.Split(qName, ".")
+	parent := t.cm[qClass]
+	if parent == nil {
+		return
+	}
+	if len(qName) > 0 { // Empty means walk from the root
+		for ix := len(labels) - 1; ix >= 0; ix-- {
+			if parent.children == nil {
+				return
+			}
+			parent = parent.children[labels[ix]]
+			if parent == nil {
+				return
+			}
+		}
+	}
+
+	walkChildren(parent, fn)
+}
+
+func walkChildren(parent *node, fn func(rr dns.RR)) {
+	for _, rrset := range parent.tm {
+		for _, rr := range rrset {
+			fn(dns.Copy(rr))
+		}
+	}
+	for _, child := range parent.children {
+		walkChildren(child, fn)
+	}
+}
+
+// SetSerial sets the generation serial of the database. It should only be called prior to
+// the database being handed to a Getter.
+func (t *Database) SetSerial(serial uint32) {
+	t.serial = serial
+}
+
+// Serial returns the generation serial of the database or zero if it was never set.
+func (t *Database) Serial() uint32 {
+	return t.serial
+}
+
 // Count returns the total count of all RRs in the database.
 func (t *Database) Count() int {
 	return t.count
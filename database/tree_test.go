@@ -91,6 +91,83 @@ func TestLookup(t *testing.T) {
 	}
 }
 
+func TestTypes(t *testing.T) {
+	db := NewDatabase()
+	if len(db.Types(dns.ClassINET, "a.b.c.")) != 0 {
+		t.Error("Empty DB should have no types")
+	}
+	db.AddRR(newRR("a.b.c. IN A 1.2.3.4"))
+	db.AddRR(newRR("a.b.c. IN A 1.2.3.5"))
+	db.AddRR(newRR("a.b.c. IN AAAA ::1"))
+	db.AddRR(newRR("x.a.b.c. IN TXT 'x'"))
+
+	testCases := []struct {
+		qName  string
+		expect int
+	}{
+		{"a.b.c.", 2},
+		{"A.B.C", 2},
+		{"x.a.b.c.", 1},
+		{"b.c.", 0}, // Empty non-terminal
+		{"y.a.b.c.", 0},
+		{"d.", 0},
+	}
+	for ix, tc := range testCases {
+		types := db.Types(dns.ClassINET, tc.qName)
+		if len(types) != tc.expect {
+			t.Error(ix, tc.qName, "Expected", tc.expect, "types, not", types)
+		}
+	}
+	if len(db.Types(dns.ClassCHAOS, "a.b.c.")) != 0 {
+		t.Error("Wrong class should have no types")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	db := NewDatabase()
+	db.AddRR(newRR("a.b.c. IN A 1.2.3.4"))
+	db.AddRR(newRR("a.b.c. IN A 1.2.3.5"))
+	db.AddRR(newRR("x.a.b.c. IN TXT 'x'"))
+	db.AddRR(newRR("y.b.c. IN TXT 'y'"))
+	db.AddRR(newRR("d. IN TXT 'd'"))
+	db.AddRR(newRR("bind.version. CH TXT '10.1'"))
+
+	testCases := []struct {
+		qName  string
+		expect int
+	}{
+		{"a.b.c.", 3},
+		{"b.c.", 4},
+		{"B.C", 4},
+		{".", 5},
+		{"y.a.b.c.", 0},
+		{"e.", 0},
+	}
+	for ix, tc := range testCases {
+		var count int
+		db.Walk(dns.ClassINET, tc.qName, func(rr dns.RR) {
+			if !dns.IsSubDomain(dns.CanonicalName(tc.qName), rr.Header().Name) {
+				t.Error(ix, "Walk returned out of domain RR", rr)
+			}
+			count++
+		})
+		if count != tc.expect {
+			t.Error(ix, tc.qName, "Expected", tc.expect, "RRs, not", count)
+		}
+	}
+}
+
+func TestSerial(t *testing.T) {
+	db := NewDatabase()
+	if db.Serial() != 0 {
+		t.Error("New database should have zero serial")
+	}
+	db.SetSerial(1234)
+	if db.Serial() != 1234 {
+		t.Error("Serial not set", db.Serial())
+	}
+}
+
 func TestImmutable(t *testing.T) {
 	db := NewDatabase()
 	rr1 := newRR("a.b.c. IN A 1.2.3.4")
@@ -0,0 +1,114 @@
+package delegation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnsutil"
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+const classlessCNAMETries = 3 // Number of addresses checked for an RFC2317 CNAME
+
+// FindClasslessZone determines the zone name a parent uses for an RFC2317 classless
+// delegation of ipNet. RFC2317 does not mandate a naming scheme so rather than guess, the
+// parent is asked for the CNAMEs of the first few addresses in the CIDR and the zone is
+// derived from the CNAME target, e.g. 65.2.0.192.in-addr.arpa CNAME
+// 65.64-27.2.0.192.in-addr.arpa implies a zone of 64-27.2.0.192.in-addr.arpa.
+//
+// The parent is found by walking up from the /24 reverse zone in the same way as
+// findZoneCut(). An empty string is returned if no usable CNAME is found, in which case
+// the caller will normally fall back to dnsutil.ClasslessReverseZone().
+func (t *Finder) FindClasslessZone(ipNet *net.IPNet) string {
+	if !dnsutil.IsClassless(ipNet) {
+		return ""
+	}
+
+	ip4 := ipNet.IP.To4()
+	labels := strings.Split(fmt.Sprintf("%d.%d.%d%s", ip4[2], ip4[1], ip4[0], dnsutil.V4Suffix), ".")
+	var parent string
+	var nsSet []string
+	for ; len(labels) >= 1+3; labels = labels[1:] { // Never less than a /8
+		parent = strings.Join(labels, ".")
+		var err error
+		nsSet, err = t.resolver.LookupNS(context.Background(), parent)
+		if err == nil {
+			break
+		}
+		nsSet = nil
+	}
+	if len(nsSet) == 0 {
+		log.Minorf("FindClasslessZone:No name servers found for %s", ipNet.String())
+		return ""
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	hosts := 1 << (bits - ones)
+	if hosts > classlessCNAMETries {
+		hosts = classlessCNAMETries
+	}
+	for ix := 0; ix < hosts; ix++ {
+		host := ip4[3] + byte(ix)
+		q := dns.Question{
+			Name:   dnsutil.IPToReverseQName(net.IPv4(ip4[0], ip4[1], ip4[2], host)),
+			Qtype:  dns.TypeCNAME,
+			Qclass: dns.ClassINET}
+		zone := t.queryClasslessCNAME(parent, nsSet, q, fmt.Sprintf("%d", host))
+		if len(zone) > 0 {
+			return zone
+		}
+	}
+
+	return ""
+}
+
+// queryClasslessCNAME asks each parent name server in turn for the CNAME question and
+// returns the zone implied by the first acceptable response. An acceptable CNAME target
+// has a first label which matches the address octet, as otherwise the server cannot
+// invert classless queries back to an address.
+func (t *Finder) queryClasslessCNAME(parent string, nsSet []string, q dns.Question, octet string) string {
+	for _, ns := range nsSet {
+		addrs, err := t.resolver.LookupIPAddr(context.Background(), ns)
+		if err != nil {
+			log.Minorf("Could not resolve parent %s:%s",
+				ns, dnsutil.ShortenLookupError(err).Error())
+			continue
+		}
+		for _, ip := range addrs {
+			r, _, err := t.resolver.FullExchange(context.Background(),
+				resolver.NewExchangeConfig(), q, ip.String(), ns)
+			if err != nil {
+				log.Debugf("Resolver error from parent %s/%s for %s/CNAME:%s",
+					ns, ip.String(), q.Name, err.Error())
+				continue
+			}
+			if r.MsgHdr.Rcode != dns.RcodeSuccess {
+				log.Debugf("%s from parent %s/%s for %s/CNAME",
+					dnsutil.RcodeToString(r.MsgHdr.Rcode), ns, ip.String(), q.Name)
+				continue
+			}
+			for _, rr := range r.Answer {
+				cname, ok := rr.(*dns.CNAME)
+				if !ok || dns.CanonicalName(cname.Hdr.Name) != q.Name {
+					continue
+				}
+				ar := strings.SplitN(dns.CanonicalName(cname.Target), ".", 2)
+				if len(ar) != 2 || ar[0] != octet || len(ar[1]) == 0 {
+					log.Majorf("Alert:Unusable classless CNAME %s from parent %s/%s",
+						dnsutil.PrettyRR(cname, true), ns, ip.String())
+					continue
+				}
+				log.Minorf("FindClasslessZone:%s implies zone %s in %s",
+					dnsutil.PrettyRR(cname, true), ar[1], parent)
+				return ar[1]
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,43 @@
+package delegation
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/mock/resolver"
+)
+
+func TestFindClasslessZone(t *testing.T) {
+	testCases := []struct {
+		cidr     string
+		expect   string
+		contains string
+	}{
+		{"192.0.2.64/27", "64-27.2.0.192.in-addr.arpa.", "implies zone"},
+		{"192.0.2.32/28", "", "Unusable classless CNAME"},
+		{"192.0.2.128/25", "", ""}, // No CNAMEs at all
+		{"192.0.2.0/24", "", ""},   // Not classless
+		{"198.51.100.0/26", "", "No name servers"},
+	}
+
+	for ix, tc := range testCases {
+		out := &mock.IOWriter{}
+		log.SetOut(out)
+		log.SetLevel(log.DebugLevel)
+		finder := NewFinder(resolver.NewResolver("./testdata/find"))
+		_, ipNet, err := net.ParseCIDR(tc.cidr)
+		if err != nil {
+			t.Fatal("Setup", err)
+		}
+		got := finder.FindClasslessZone(ipNet)
+		if got != tc.expect {
+			t.Error(ix, tc.cidr, "Want", tc.expect, "got", got)
+		}
+		if len(tc.contains) > 0 && !strings.Contains(out.String(), tc.contains) {
+			t.Error(ix, tc.cidr, "Log missing", tc.contains, out.String())
+		}
+	}
+}
@@ -62,6 +62,8 @@ func TestFindAndProbe(t *testing.T) {
 
 		{"192.0.2.0/24", "2.0.192.in-addr.arpa.", "192.in-addr.arpa.", "",
 			true, true},
+		{"192.0.2.64/27", "64/27.2.0.192.in-addr.arpa.", "192.in-addr.arpa.", "",
+			true, true},
 		{"2001:db8::/64", "0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
 			"8.b.d.0.1.0.0.2.ip6.arpa.", "", true, true},
 	}
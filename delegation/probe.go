@@ -170,7 +170,14 @@ func NewForwardProbe(target string) *forwardProbe {
 //
 // The supplied ptrText is used to formulate the response PTR text and normally it will be
 // the forward domain name.
+//
+// Classless ipv4 CIDRs (/25 to /31) are probed with the RFC2317 style zone name returned
+// by dnsutil.ClasslessReverseZone(). Use NewClasslessReverseProbe() if the parent uses
+// some other zone name.
 func NewReverseProbe(ptrText string, ipNet *net.IPNet) *reverseProbe {
+	if dnsutil.IsClassless(ipNet) {
+		return NewClasslessReverseProbe(ptrText, ipNet, dnsutil.ClasslessReverseZone(ipNet))
+	}
 	ip := ipNet.IP
 	t := &reverseProbe{ptr: ptrText, ipNet: *ipNet}
 	t.target = dnsutil.IPToReverseQName(ip)
@@ -234,6 +241,53 @@ func NewReverseProbe(ptrText string, ipNet *net.IPNet) *reverseProbe {
 	return t
 }
 
+// NewClasslessReverseProbe creates a reverse probe for an RFC2317 classless delegation
+// of the ipv4 CIDR. Such delegations are of an arbitrarily named zone (normally a child
+// of the /24 reverse zone) and the parent CNAMEs each address in the CIDR to a name
+// within that zone. The zone is supplied by the caller as it cannot be derived from the
+// CIDR alone.
+//
+// The probe question is a random address within the CIDR, prepended to the zone.
+func NewClasslessReverseProbe(ptrText string, ipNet *net.IPNet, zone string) *reverseProbe {
+	t := &reverseProbe{ptr: ptrText, ipNet: *ipNet}
+	t.target = dns.CanonicalName(zone)
+	t.zoneLabels = strings.Split(t.target, ".")
+
+	// Generate the probe
+	ones, bits := ipNet.Mask.Size()
+	host := ipNet.IP.To4()[3] + byte(rand.Int31n(1<<(bits-ones)))
+	qName := fmt.Sprintf("%d.%s", host, t.target)
+
+	t.question.Name = qName
+	t.question.Qclass = dns.ClassINET
+	t.question.Qtype = dns.TypePTR
+
+	ptr := &dns.PTR{Ptr: dns.CanonicalName(randomAlphas(5) + "." + ptrText)}
+	ptr.Hdr.Name = qName
+	ptr.Hdr.Rrtype = t.question.Qtype
+	ptr.Hdr.Class = t.question.Qclass
+	ptr.Hdr.Ttl = niceShortTTL
+
+	t.answer = ptr
+
+	// The zone is usually in in-addr.arpa, but RFC2317 allows it to be anywhere.
+	if strings.HasSuffix(t.target, dnsutil.V4Suffix) {
+		t.minimumLabels = 1 + 3 // Never less than a /8
+	} else {
+		t.minimumLabels = 2 // Stop at TLD as with forward probes
+	}
+
+	if len(t.zoneLabels) > 1 {
+		t.zoneLabels = t.zoneLabels[1:] // Trim from target to get parent
+	}
+
+	if len(t.zoneLabels) >= t.minimumLabels { // End is last + 1
+		t.end.ix = len(t.zoneLabels) - t.minimumLabels + 1
+	}
+
+	return t
+}
+
 // QuestionMatches returns true if the question matches the probe. This is normally asked
 // by the server-side to determine whether to send the answer as a response.
 func (t *commonProbe) QuestionMatches(match dns.Question) bool {
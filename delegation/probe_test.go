@@ -156,6 +156,59 @@ func TestGenerateReverseV6(t *testing.T) {
 	}
 }
 
+func TestGenerateReverseClassless(t *testing.T) {
+	_, ipNet, err := net.ParseCIDR("192.0.2.64/27")
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	pr := NewReverseProbe("example.org", ipNet) // Should default to RFC2317 name
+	if pr.Target() != "64/27.2.0.192.in-addr.arpa." {
+		t.Error("Wrong default classless target", pr.Target())
+	}
+
+	pr = NewClasslessReverseProbe("example.org", ipNet, "64-27.2.0.192.IN-ADDR.ARPA")
+	if pr.Target() != "64-27.2.0.192.in-addr.arpa." {
+		t.Error("Wrong supplied classless target", pr.Target())
+	}
+	for ix := 0; ix < 20; ix++ { // Random host must always be within the CIDR
+		pr = NewClasslessReverseProbe("example.org", ipNet, "64-27.2.0.192.in-addr.arpa.")
+		q := pr.Question()
+		ar := strings.SplitN(q.Name, ".", 2)
+		if ar[1] != pr.Target() {
+			t.Fatal("Probe question not in target", q.Name)
+		}
+		ip := net.ParseIP("192.0.2." + ar[0])
+		if !ipNet.Contains(ip) {
+			t.Error("Probe question outside CIDR", q.Name)
+		}
+		if pr.answer.Header().Name != q.Name {
+			t.Error("Answer does not match question", pr.answer)
+		}
+	}
+
+	iter := pr.Begin()
+	if z := pr.Zone(iter); z != "2.0.192.in-addr.arpa." {
+		t.Error("Initial classless zone wrong", z)
+	}
+	lastZone := ""
+	for ; iter != pr.End(); iter = pr.Next(iter) {
+		lastZone = pr.Zone(iter)
+	}
+	if lastZone != "192.in-addr.arpa." {
+		t.Error("Typical 'for loop' usaged failed", lastZone)
+	}
+
+	// Zones outside in-addr.arpa stop at the TLD
+	pr = NewClasslessReverseProbe("example.org", ipNet, "rev.example.net.")
+	lastZone = ""
+	for iter = pr.Begin(); iter != pr.End(); iter = pr.Next(iter) {
+		lastZone = pr.Zone(iter)
+	}
+	if lastZone != "net." {
+		t.Error("Classless out-of-arpa 'for loop' failed", lastZone)
+	}
+}
+
 func TestQuestionMatches(t *testing.T) {
 	pr, q := newReverse("192.0.2.21/24")
 	if !pr.QuestionMatches(q) {
@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
@@ -20,6 +20,18 @@ func (t *autoReverse) discover() error {
 		}
 	}
 
+	// Forwards mapped with --reverse CIDR=forward are discovered separately, but only
+	// once regardless of how many CIDRs map to them.
+	for _, forward := range t.mappedForwards(t.delegatedReverses) {
+		if _, ok := t.forwardAuthorities[forward]; ok {
+			continue
+		}
+		err := t.discoverForward(finder, forward)
+		if err != nil {
+			return err
+		}
+	}
+
 	err := t.discoverAllReverses(finder)
 	if err != nil {
 		return err
@@ -67,8 +79,7 @@ func (t *autoReverse) discoverForward(finder *delegation.Finder, domain string)
 	auth.synthesizeSOA(fr.Parent.Domain, t.cfg.TTLAsSecs)
 	logAuth(auth, "Forward")
 
-	t.forwardAuthority = auth
-	t.addAuthority(auth)
+	t.addForwardAuthority(auth)
 
 	return nil
 }
@@ -82,17 +93,23 @@ func (t *autoReverse) discoverAllReverses(finder *delegation.Finder) error {
 	// are never added to mutables while in discovery mode - they are all added by
 	// Run() post-discovery.
 	var fwdOnly authorities
-	if t.forwardAuthority != nil { // This must always be true I think
-		fwdOnly.append(t.forwardAuthority)
+	for _, auth := range t.forwardAuthorities { // Must always have at least one I think
+		fwdOnly.append(auth)
 	}
+	fwdOnly.sort()
 	for _, srv := range t.servers {
 		srv.setMutables(t.forward, nil, fwdOnly)
 	}
 
+	// ipv6 CIDRs which are not nibble-aligned are served as multiple zones, each of
+	// which has to be separately delegated by the parent.
 	for _, ipNet := range t.delegatedReverses {
-		err := t.discoverReverse(finder, t.forward, ipNet)
-		if err != nil {
-			return err
+		forward := t.reverseForward(ipNet)
+		for _, subNet := range dnsutil.NibbleCIDRs(ipNet) {
+			err := t.discoverReverse(finder, forward, subNet)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -100,8 +117,23 @@ func (t *autoReverse) discoverAllReverses(finder *delegation.Finder) error {
 }
 
 // Discover one reverse zone by walking then probing.
+//
+// Classless ipv4 CIDRs are delegated via RFC2317 CNAMEs so the parent is first asked
+// which zone name it uses. If the parent has no CNAMEs yet, the RFC2317 suggested name is
+// probed instead.
 func (t *autoReverse) discoverReverse(finder *delegation.Finder, forward string, ipNet *net.IPNet) error {
-	pr := delegation.NewReverseProbe(forward, ipNet) // Create the Probe
+	var pr delegation.Probe
+	if dnsutil.IsClassless(ipNet) {
+		zone := finder.FindClasslessZone(ipNet)
+		if len(zone) == 0 {
+			zone = dnsutil.ClasslessReverseZone(ipNet)
+			log.Minor("Reverse: No classless CNAMEs found for ", ipNet.String(),
+				" trying ", zone)
+		}
+		pr = delegation.NewClasslessReverseProbe(forward, ipNet, zone)
+	} else {
+		pr = delegation.NewReverseProbe(forward, ipNet) // Create the Probe
+	}
 	for _, srv := range t.servers {
 		mutables := srv.getMutables() // Replace or set probe in server mutables
 		srv.setMutables(mutables.ptrSuffix, pr, mutables.authorities)
@@ -132,6 +164,7 @@ func (t *autoReverse) discoverReverse(finder *delegation.Finder, forward string,
 
 	auth := newAuthority(fr.Target, false)
 	auth.cidr = ipNet
+	auth.ptrSuffix = forward
 	auth.Source = ipNet.String()
 	auth.synthesizeSOA(forward, t.cfg.TTLAsSecs)
 
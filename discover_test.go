@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"math/rand"
@@ -223,3 +223,45 @@ func TestDiscoverReverseDupes(t *testing.T) {
 		t.Log(out.String())
 	}
 }
+
+// Each forward mapped with --reverse CIDR=forward is discovered separately and the reverse
+// is associated with that forward.
+func TestDiscoverMappedForward(t *testing.T) {
+	rand.Seed(0) // Make PRNG predictable for probe generation
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.DebugLevel)
+	res := resolver.NewResolver("./testdata/discover")
+	ar := newAutoReverse(&config{TTLAsSecs: 61}, res) // Needed by zone parser
+
+	ar.cfg.delegatedForward = "autoreverse.example.net."
+	ar.forward = ar.cfg.delegatedForward
+
+	_, v4Net, _ := net.ParseCIDR("192.0.2.0/24")
+	_, v6Net, _ := net.ParseCIDR("2001:db8::/64")
+	ar.delegatedReverses = append(ar.delegatedReverses, v4Net, v6Net)
+	ar.reverseForwards[v6Net.String()] = "cust1.example.net."
+	err := ar.discover()
+	if err != nil {
+		t.Fatal(err, out.String())
+	}
+
+	if ar.authorities.len() != 4 { // Two forwards and two reverses
+		t.Error("Authority count wrong. Want 4, got", ar.authorities.len())
+	}
+	if ar.forwardAuthority == nil || ar.forwardAuthority.Domain != ar.forward {
+		t.Error("Default forward authority lost", ar.forwardAuthority)
+	}
+	if ar.forwardAuthorities["cust1.example.net."] == nil {
+		t.Error("Mapped forward was not discovered")
+	}
+
+	auth := ar.authorities.findIPInDomain(v4Net.IP)
+	if auth == nil || auth.ptrSuffix != "autoreverse.example.net." {
+		t.Error("v4 reverse should use default forward", auth)
+	}
+	auth = ar.authorities.findIPInDomain(v6Net.IP)
+	if auth == nil || auth.ptrSuffix != "cust1.example.net." {
+		t.Error("v6 reverse should use mapped forward", auth)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
@@ -63,6 +63,7 @@ func (t *server) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
 	}
 
 	req.opt = req.query.IsEdns0() // Extract Opt values nice and early
+	req.dnssecOK = t.cfg.dnssecSigner != nil && req.opt != nil && req.opt.Do()
 
 	if (len(t.cfg.nsid) > 0) && (req.findNSID() != nil) {
 		req.nsidOut = t.cfg.nsidAsHex
@@ -100,14 +101,16 @@ func (t *server) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
 	}
 
 	// Subsequent to the weird non-request cookie-request, we only accept "normal"
-	// queries. Pretty much all of the following tests are performed by miekg prior to
-	// calling ServeDNS(), but precisely what validation will be performed, is
-	// undocumented and perhaps may vary over time thus the "belts and braces"
-	// approach.
+	// queries, NOTIFYs and UPDATEs. Pretty much all of the following tests are
+	// performed by miekg prior to calling ServeDNS(), but precisely what validation will
+	// be performed, is undocumented and perhaps may vary over time thus the "belts and
+	// braces" approach.
+	isNotify := req.query.Opcode == dns.OpcodeNotify
+	isUpdate := req.query.Opcode == dns.OpcodeUpdate // Sections validated by serveUpdate()
 	if len(req.query.Question) != 1 ||
-		len(req.query.Answer) != 0 ||
-		len(req.query.Ns) != 0 ||
-		req.query.Opcode != dns.OpcodeQuery {
+		(len(req.query.Answer) != 0 && !(isNotify && len(req.query.Answer) == 1) && !isUpdate) ||
+		(len(req.query.Ns) != 0 && !(req.question.Qtype == dns.TypeIXFR && len(req.query.Ns) == 1) && !isUpdate) ||
+		(req.query.Opcode != dns.OpcodeQuery && !isNotify && !isUpdate) {
 		t.serveFormErr(wtr, req)
 		req.addNote("Malformed Query")
 		req.stats.gen.badRequest++
@@ -127,6 +130,17 @@ func (t *server) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
 
 	req.db = t.dbGetter.Current()  // Final setup for request prior to dispatching
 	req.mutables = t.getMutables() // Get current mutables from server instance
+	req.updates = t.updates
+
+	// NOTIFYs and UPDATEs are not queries so they bypass query dispatch entirely.
+	if isNotify {
+		t.serveNotify(wtr, req)
+		return
+	}
+	if isUpdate {
+		t.serveUpdate(wtr, req)
+		return
+	}
 
 	// Pre-processing complete. Dispatch order:
 	//
@@ -134,7 +148,7 @@ func (t *server) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
 	// 2. Chaos via database
 	// 3. In-domain or Passthru
 	// 4. Not ClassINET
-	// 5. Special Authority Queries (SOA, NS, ANY)
+	// 5. Special Authority Queries (AXFR, IXFR, SOA, NS, ANY, DNSKEY)
 	// 6. Database
 	// 7. Synthesis
 	// 8. Pending serveResult
@@ -205,18 +219,22 @@ func (t *server) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
 	// Handle queries which require special treatment such as populating Extra or
 	// Authority RRs or oddball qTypes. Otherwise fall thru to try serveDatabase which
 	// can server all regular RRs for the Authority Zone.
+	if req.question.Qtype == dns.TypeAXFR || req.question.Qtype == dns.TypeIXFR {
+		t.serveTransfer(wtr, req)
+		return
+	}
 	if req.qName == req.auth.Domain {
 		switch req.question.Qtype {
 		case dns.TypeANY:
 			req.response.SetRcode(req.query, dns.RcodeSuccess)
-			req.response.Answer = append(req.response.Answer, &req.auth.SOA)
+			req.response.Answer = append(req.response.Answer, req.soa())
 			req.stats.gen.authZoneANY++
 			t.writeMsg(wtr, req)
 			return
 
 		case dns.TypeSOA:
 			req.response.SetRcode(req.query, dns.RcodeSuccess)
-			req.response.Answer = append(req.response.Answer, &req.auth.SOA)
+			req.response.Answer = append(req.response.Answer, req.soa())
 			req.response.Ns = append(req.response.Ns, req.auth.NS...)
 			req.response.Extra = append(req.response.Extra, req.auth.A...)
 			req.response.Extra = append(req.response.Extra, req.auth.AAAA...)
@@ -232,6 +250,16 @@ func (t *server) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
 			req.stats.gen.authZoneNS++
 			t.writeMsg(wtr, req)
 			return
+
+		case dns.TypeDNSKEY: // Only synthesized if signing, otherwise it's a regular query
+			if t.cfg.dnssecSigner != nil {
+				req.response.SetRcode(req.query, dns.RcodeSuccess)
+				req.response.Answer = append(req.response.Answer,
+					t.cfg.dnssecSigner.DNSKEYs(req.auth.Domain, req.auth.SOA.Hdr.Ttl)...)
+				req.addNote("DNSKEY")
+				t.writeMsg(wtr, req)
+				return
+			}
 		}
 	}
 
@@ -306,7 +334,7 @@ func (t *server) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
 
 func (t *server) serveNoError(wtr dns.ResponseWriter, req *request) {
 	req.response.SetRcode(req.query, dns.RcodeSuccess)
-	req.response.Ns = append(req.response.Ns, &req.auth.SOA)
+	req.response.Ns = append(req.response.Ns, req.soa())
 	t.writeMsg(wtr, req)
 }
 
@@ -320,7 +348,7 @@ func (t *server) serveFormErr(wtr dns.ResponseWriter, req *request) {
 
 func (t *server) serveNXDomain(wtr dns.ResponseWriter, req *request) {
 	req.response.SetRcode(req.query, dns.RcodeNameError)
-	req.response.Ns = append(req.response.Ns, &req.auth.SOA)
+	req.response.Ns = append(req.response.Ns, req.soa())
 	t.writeMsg(wtr, req)
 }
 
@@ -330,7 +358,7 @@ func (t *server) serveRefused(wtr dns.ResponseWriter, req *request) {
 }
 
 func (t *server) serveDatabase(wtr dns.ResponseWriter, req *request) serveResult {
-	ar, nx := req.db.LookupRR(req.question.Qclass, req.question.Qtype, req.qName)
+	ar, nx := req.lookupRR(req.question.Qclass, req.question.Qtype, req.qName)
 	if len(ar) == 0 {
 		if nx {
 			return NXDomain
@@ -366,68 +394,46 @@ func (t *server) serveDatabase(wtr dns.ResponseWriter, req *request) serveResult
 // dig -t $qType 192-168-0-123.$forward // 192.168.0.0/24
 // dig -t $qType 192-168--1.$forward // 192:168::/64
 //
-// The IP address needs to be extract from the qName and checked against our reverse
-// authorities to ensure it's an IP address that we could have concievably generated a
-// PTR. Note that because this is all algorithmic, all legitimate IP addresses can be
-// queried against the forward authorities at any time.
+// or whatever form the --PTR-template options dictate. The IP address needs to be
+// extracted from the qName and checked against our reverse authorities to ensure it's an
+// IP address that we could have concievably generated a PTR. With multiple forward zones
+// the reverse authority must also be mapped to this forward zone, otherwise one forward
+// could answer for addresses belonging to another. Note that because this is all
+// algorithmic, all legitimate IP addresses can be queried against the forward
+// authorities at any time.
 //
 // To extract the IP address we need to first know whether it's an ipv4 or ipv6 address.
-// It's subtle but ipv4 qNames have a unique pattern because they don't compress zero
-// octets, unlike ipv6. Specifically, well formed ipv4 forwards are always four non-zero
-// length decimals separated by '-'. Anything else has to either be an ipv6 address or
-// invalid. We take advantage of this distinction to determine how to dispatch to the
-// appropriate handler.
+// With the default templates ipv4 qNames have a unique pattern because they don't
+// compress zero octets, unlike ipv6. Specifically, well formed ipv4 forwards are always
+// four non-zero length decimals separated by '-'. More generally, if the ipv4 template
+// parses the qName it's treated as an ipv4 address otherwise it has to either be an ipv6
+// address or invalid. We take advantage of this distinction to determine how to dispatch
+// to the appropriate handler.
 //
 // This convolution is necessary because we need to distinguish between NoError and
 // NXDomain. The naive (and wrong) approach is to use the $qType to decide how to decode
 // the qName prefix.
 func (t *server) serveForward(wtr dns.ResponseWriter, req *request) serveResult {
-	ipStr := strings.TrimSuffix(req.qName, "."+req.auth.Domain)
-	ar := strings.SplitN(ipStr, "-", 4)
-	is4 := true
-	if len(ar) != 4 || strings.Contains(ar[3], "-") { // A legit ipv4?
-		is4 = false
-	} else {
-		for _, v := range ar {
-			if len(v) == 0 {
-				is4 = false
-				break
-			}
-		}
-	}
-
-	if is4 {
-		return t.serveA(wtr, req)
+	ip := t.cfg.ptrTemplate(true).Parse(req.qName, req.auth.Domain)
+	if ip != nil {
+		return t.serveA(wtr, req, ip)
 	}
 
 	return t.serveAAAA(wtr, req)
 }
 
-// Expecting 192-0-2-1.$forward. Unlike ipv6, there is no compression of the IP address to
-// cover multiple zero octets, which makes parsing simpler. IOWs, 192.0.0.1 does not get
-// converted into 192--1.
-func (t *server) serveA(wtr dns.ResponseWriter, req *request) serveResult {
+// Expecting 192-0-2-1.$forward which serveForward() has already converted back into an
+// ipv4 address.
+func (t *server) serveA(wtr dns.ResponseWriter, req *request, ip net.IP) serveResult {
 	req.stats.AForward.queries++
 
-	ipStr := strings.TrimSuffix(req.qName, "."+req.auth.Domain)
-	if strings.Index(ipStr, ".") >= 0 { // Don't allow 192.0.2.0.domain - should be 192-0-2-0.domain
-		return NXDomain
-	}
-	ipStr = strings.ReplaceAll(ipStr, "-", ".")
-	ip := net.ParseIP(ipStr)
-	if ip == nil { // Couldn't convert back into an ip address
-		return NXDomain
-	}
-	ip = ip.To4()
-	if ip == nil || len(ip) != net.IPv4len { // serveForward() checking should catch this
-		return NXDomain
-	}
-
-	// If ip is not in-bailwick of our reverse zones then NXDomain
-	if req.authorities.findIPInDomain(ip) == nil {
+	// If ip is not in-bailwick of the reverse zones mapped to this forward then NXDomain
+	rev := req.authorities.findIPInDomain(ip)
+	if rev == nil || req.ptrSuffix(rev) != req.auth.Domain {
 		return NXDomain
 	}
 
+	req.existTypes = []uint16{dns.TypeA}
 	if req.question.Qtype != dns.TypeA { // If wrong type, NoError
 		return NoError
 	}
@@ -448,41 +454,48 @@ func (t *server) serveA(wtr dns.ResponseWriter, req *request) serveResult {
 }
 
 // Expecting 2001-db83--1.domain. Convert the synthetic name back into an IP address and
-// reply with an AAAA.
+// reply with an AAAA. Otherwise the name may be a --PTR-ethers hostname, in which case
+// reply with its EUI-64 addresses in the reverse zones mapped to this forward.
 func (t *server) serveAAAA(wtr dns.ResponseWriter, req *request) serveResult {
 	req.stats.AAAAForward.queries++
 
-	ipStr := strings.TrimSuffix(req.qName, "."+req.auth.Domain)
-	if strings.Index(ipStr, ":") >= 0 { // Don't allow fd00::1.domain - should be fd00--1.domain
-		return NXDomain
-	}
-
-	ipStr = strings.ReplaceAll(ipStr, "-", ":")
-	ip := net.ParseIP(ipStr)
-	if ip == nil { // Couldn't convert back into an ip address
-		return NXDomain
-	}
-	if len(ip) != net.IPv6len {
-		return NXDomain
+	var ips []net.IP
+	ip := t.cfg.ptrTemplate(false).Parse(req.qName, req.auth.Domain)
+	if ip != nil {
+		// If ip is not in-bailwick of the reverse zones mapped to this forward then NXDomain
+		rev := req.authorities.findIPInDomain(ip)
+		if rev == nil || req.ptrSuffix(rev) != req.auth.Domain {
+			return NXDomain
+		}
+		ips = append(ips, ip)
+	} else {
+		var cidrs []*net.IPNet
+		for _, rev := range req.authorities.slice {
+			if !rev.forward && rev.cidr != nil && req.ptrSuffix(rev) == req.auth.Domain {
+				cidrs = append(cidrs, rev.cidr)
+			}
+		}
+		ips = t.cfg.ethersAddresses(req.qName, req.auth.Domain, cidrs)
 	}
-
-	// If ip is not in-bailwick of our reverse zones then NXDomain
-	if req.authorities.findIPInDomain(ip) == nil {
+	if len(ips) == 0 { // Couldn't convert back into an ip address
 		return NXDomain
 	}
 
+	req.existTypes = []uint16{dns.TypeAAAA}
 	if req.question.Qtype != dns.TypeAAAA { // If wrong type, NoError
 		return NoError
 	}
 
 	req.response.SetReply(req.query)
-	rr := new(dns.AAAA)
-	rr.Hdr.Name = req.question.Name
-	rr.Hdr.Class = req.question.Qclass
-	rr.Hdr.Rrtype = req.question.Qtype
-	rr.Hdr.Ttl = t.cfg.TTLAsSecs
-	rr.AAAA = ip
-	req.response.Answer = append(req.response.Answer, rr)
+	for _, ip := range ips {
+		rr := new(dns.AAAA)
+		rr.Hdr.Name = req.question.Name
+		rr.Hdr.Class = req.question.Qclass
+		rr.Hdr.Rrtype = req.question.Qtype
+		rr.Hdr.Ttl = t.cfg.TTLAsSecs
+		rr.AAAA = ip
+		req.response.Answer = append(req.response.Answer, rr)
+	}
 	t.writeMsg(wtr, req)
 	req.stats.AAAAForward.good++
 	req.stats.AAAAForward.answers += len(req.response.Answer)
@@ -495,6 +508,7 @@ func (t *server) serveAAAA(wtr dns.ResponseWriter, req *request) serveResult {
 //
 // dig -t $qType 168.192.in-addr.arpa.
 // dig -t $qType 1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.
+// dig -t $qType 65.64/27.2.0.192.in-addr.arpa. // RFC2317 classless via parent's CNAME
 //
 // An invertible, but truncated IP is of the form:
 //
@@ -522,6 +536,12 @@ func (t *server) serveReverse(wtr dns.ResponseWriter, req *request) serveResult
 	)
 
 	switch {
+	case req.auth.classless(): // Must precede V4Suffix as it's usually in-addr.arpa
+		statsp = &req.stats.APtr
+		statsp.queries++
+		reverseIPStr = strings.TrimSuffix(req.qName, "."+req.auth.Domain)
+		ip, err = dnsutil.InvertClasslessPtrToIPv4(reverseIPStr, req.auth.cidr)
+
 	case strings.HasSuffix(req.qName, dnsutil.V6Suffix):
 		statsp = &req.stats.AAAAPtr
 		statsp.queries++
@@ -557,13 +577,22 @@ func (t *server) serveReverse(wtr dns.ResponseWriter, req *request) serveResult
 		return NoError
 	}
 
+	req.existTypes = []uint16{dns.TypePTR}
 	if req.question.Qtype != dns.TypePTR { // Case 3: Invertible, but not a PTR
 		req.addNote("Not PTR")
 		return NoError
 	}
 
-	req.addNote("Synth") // Case 4: Synthesize
-	ptr := dnsutil.SynthesizePTR(req.qName, req.mutables.ptrSuffix, ip)
+	var ptr *dns.PTR // Case 4: Synthesize
+	suffix := req.ptrSuffix(req.auth)
+	if name := t.cfg.ethersName(ip, suffix); len(name) > 0 {
+		req.addNote("Synth-EUI64")
+		ptr = &dns.PTR{Hdr: dns.RR_Header{Name: req.qName, Rrtype: dns.TypePTR, Class: dns.ClassINET},
+			Ptr: name}
+	} else {
+		req.addNote("Synth")
+		ptr = t.cfg.ptrTemplate(ip.To4() != nil).SynthesizePTR(req.qName, suffix, ip)
+	}
 	req.response.SetReply(req.query)
 	ptr.Hdr.Ttl = t.cfg.TTLAsSecs
 	req.response.Answer = append(req.response.Answer, ptr)
@@ -583,6 +612,16 @@ func (t *server) writeMsg(wtr dns.ResponseWriter, req *request) {
 		req.response.Extra = append(req.response.Extra, opt)
 	}
 
+	if req.dnssecOK && req.auth != nil {
+		t.signResponse(req)
+	}
+
+	// Responses to verified TSIG queries are signed by miekg when written. The TSIG RR
+	// must be the last RR in the message so it's added after the OPT.
+	if tsig := req.query.IsTsig(); tsig != nil && len(t.cfg.tsigKeys) > 0 && wtr.TsigStatus() == nil {
+		req.response.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+
 	req.response.Authoritative = true
 
 	req.msgSize = req.response.Len() // Transfer to Stats for reporting purposes
@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"math/rand"
@@ -41,7 +41,7 @@ func TestDNSFormErr(t *testing.T) {
 	t.Run("Non-empty NS", func(t *testing.T) { testInvalid(t, server, m) })
 
 	m = setQuestion(dns.ClassINET, dns.TypeSOA, "example.net.")
-	m.Opcode = dns.OpcodeNotify
+	m.Opcode = dns.OpcodeStatus
 	t.Run("Wrong op-code", func(t *testing.T) { testInvalid(t, server, m) })
 
 	// Check the logging output while we're at it
@@ -808,3 +808,316 @@ func setQuestion(c, t uint16, z string) *dns.Msg {
 
 	return q
 }
+
+// Check that --PTR-template names are synthesized and parsed back by the forward code.
+func TestDNSPTRTemplate(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	wtr := &mock.ResponseWriter{}
+	res := resolver.NewResolver()
+	cfg := &config{synthesizeFlag: true, TTLAsSecs: 3600}
+	cfg.ptrTemplate4, _ = dnsutil.NewPTRTemplate("ip-{v4dash}.{forward}")
+	cfg.ptrTemplate6, _ = dnsutil.NewPTRTemplate("{v6iid}-{v6prefixhash}.{forward}")
+	ar := newAutoReverse(cfg, res)
+	a1 := &authority{forward: true}
+	a1.Domain = "a.zig."
+	a2 := &authority{}
+	a2.Domain = "f.f.f.f.d.2.d.f.ip6.arpa."
+	_, a2.cidr, _ = net.ParseCIDR("fd2d:ffff::/64")
+	a3 := &authority{}
+	a3.Domain = "2.0.192.in-addr.arpa."
+	_, a3.cidr, _ = net.ParseCIDR("192.0.2.0/24")
+	cfg.ptrTemplate6.AddPrefix(a2.cidr)
+	for _, a := range []*authority{a1, a2, a3} {
+		a.synthesizeSOA("a.zig.", 60)
+		ar.authorities.append(a)
+	}
+	server := newServer(cfg, ar.dbGetter, res, nil, "", "")
+	server.setMutables("a.zig.", nil, ar.authorities)
+
+	var testCases = []struct {
+		qType  uint16
+		qName  string
+		rCode  int
+		expect dns.RR
+	}{
+		{dns.TypePTR, "1.2.0.192.in-addr.arpa.", dns.RcodeSuccess,
+			newRR("1.2.0.192.in-addr.arpa. IN PTR ip-192-0-2-1.a.zig.")},
+		{dns.TypeA, "ip-192-0-2-1.a.zig.", dns.RcodeSuccess,
+			newRR("ip-192-0-2-1.a.zig. IN A 192.0.2.1")},
+		{dns.TypePTR, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.f.f.f.f.d.2.d.f.ip6.arpa.", dns.RcodeSuccess,
+			newRR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.f.f.f.f.d.2.d.f.ip6.arpa. IN PTR 0000000000000001-249afc1d.a.zig.")},
+		{dns.TypeAAAA, "0000000000000001-249afc1d.a.zig.", dns.RcodeSuccess,
+			newRR("0000000000000001-249afc1d.a.zig. IN AAAA fd2d:ffff::1")},
+		{dns.TypeA, "192-0-2-1.a.zig.", dns.RcodeNameError, nil},                    // Default form no longer valid
+		{dns.TypeA, "ip-192-0-3-1.a.zig.", dns.RcodeNameError, nil},                 // Not in a reverse CIDR
+		{dns.TypeAAAA, "ip-192-0-2-1.a.zig.", dns.RcodeSuccess, nil},                // Wrong type is NoError
+		{dns.TypeAAAA, "0000000000000001-00000000.a.zig.", dns.RcodeNameError, nil}, // Unknown prefix
+	}
+
+	for ix, tc := range testCases {
+		query := setQuestion(dns.ClassINET, tc.qType, tc.qName)
+		server.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		if resp.Rcode != tc.rCode {
+			t.Error(ix, "Expected", dnsutil.RcodeToString(tc.rCode), "not", dnsutil.RcodeToString(resp.Rcode))
+			continue
+		}
+		if tc.expect == nil {
+			if len(resp.Answer) != 0 {
+				t.Error(ix, "Did not expect an answer", resp.Answer)
+			}
+			continue
+		}
+		if len(resp.Answer) != 1 || !dnsutil.RRIsEqual(resp.Answer[0], tc.expect) {
+			t.Error(ix, "Wrong answer. \nExp:", tc.expect, "\nGot:", resp.Answer)
+		}
+	}
+}
+
+func TestDNSPTREthers(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	wtr := &mock.ResponseWriter{}
+	res := resolver.NewResolver()
+	cfg := &config{synthesizeFlag: true, TTLAsSecs: 3600}
+	cfg.ptrEthers = map[string]string{
+		"52:54:00:12:34:56": "laptop",
+		"08:00:20:01:02:03": "printer.lab",
+		"08:00:20:01:02:04": "printer.lab",
+	}
+	cfg.ptrEthersHosts = map[string][]net.HardwareAddr{
+		"laptop":      {net.HardwareAddr{0x52, 0x54, 0, 0x12, 0x34, 0x56}},
+		"printer.lab": {net.HardwareAddr{8, 0, 0x20, 1, 2, 3}, net.HardwareAddr{8, 0, 0x20, 1, 2, 4}},
+	}
+	ar := newAutoReverse(cfg, res)
+	a1 := &authority{forward: true}
+	a1.Domain = "a.zig."
+	a2 := &authority{}
+	a2.Domain = "f.f.f.f.d.2.d.f.ip6.arpa."
+	_, a2.cidr, _ = net.ParseCIDR("fd2d:ffff::/64")
+	a3 := &authority{} // Too short to determine the prefix of forward answers
+	a3.Domain = "e.e.e.e.d.2.d.f.ip6.arpa."
+	_, a3.cidr, _ = net.ParseCIDR("fd2d:eeee::/32")
+	for _, a := range []*authority{a1, a2, a3} {
+		a.synthesizeSOA("a.zig.", 60)
+		ar.authorities.append(a)
+	}
+	server := newServer(cfg, ar.dbGetter, res, nil, "", "")
+	server.setMutables("a.zig.", nil, ar.authorities)
+
+	var testCases = []struct {
+		ip     string
+		expect string
+	}{
+		{"fd2d:ffff::5054:ff:fe12:3456", "laptop.a.zig."},
+		{"fd2d:ffff::a00:20ff:fe01:203", "printer.lab.a.zig."},
+		{"fd2d:eeee::5054:ff:fe12:3456", "laptop.a.zig."},
+		{"fd2d:ffff::5254:ff:fe99:9999", "fd2d-ffff--5254-ff-fe99-9999.a.zig."},     // Unknown MAC
+		{"fd2d:ffff::9c3e:61d2:7ab0:11f4", "fd2d-ffff--9c3e-61d2-7ab0-11f4.a.zig."}, // Not EUI-64
+	}
+
+	for ix, tc := range testCases {
+		qName := dnsutil.IPToReverseQName(net.ParseIP(tc.ip))
+		query := setQuestion(dns.ClassINET, dns.TypePTR, qName)
+		server.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		expect := newRR(qName + " 3600 IN PTR " + tc.expect)
+		if len(resp.Answer) != 1 || !dnsutil.RRIsEqual(resp.Answer[0], expect) {
+			t.Error(ix, "Wrong answer. \nExp:", expect, "\nGot:", resp.Answer)
+		}
+	}
+
+	// Forward queries of ethers names return the addresses in the /64 reverse zone so
+	// that forward-confirmed reverse DNS succeeds.
+	var forwardCases = []struct {
+		qName  string
+		rcode  int
+		expect []string
+	}{
+		{"laptop.a.zig.", dns.RcodeSuccess, []string{"fd2d:ffff::5054:ff:fe12:3456"}},
+		{"Printer.Lab.a.zig.", dns.RcodeSuccess,
+			[]string{"fd2d:ffff::a00:20ff:fe01:203", "fd2d:ffff::a00:20ff:fe01:204"}},
+		{"printer.a.zig.", dns.RcodeNameError, nil},
+		{"desktop.a.zig.", dns.RcodeNameError, nil},
+	}
+
+	for ix, tc := range forwardCases {
+		query := setQuestion(dns.ClassINET, dns.TypeAAAA, tc.qName)
+		server.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		if resp.Rcode != tc.rcode || len(resp.Answer) != len(tc.expect) {
+			t.Error(ix, "Wrong response", resp.Rcode, resp.Answer)
+			continue
+		}
+		for ax, ans := range resp.Answer {
+			expect := newRR(tc.qName + " 3600 IN AAAA " + tc.expect[ax])
+			if !dnsutil.RRIsEqual(ans, expect) {
+				t.Error(ix, "Wrong answer. \nExp:", expect, "\nGot:", ans)
+			}
+		}
+	}
+}
+
+func TestDNSClassless(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	wtr := &mock.ResponseWriter{}
+	res := resolver.NewResolver()
+	cfg := &config{synthesizeFlag: true, TTLAsSecs: 3600}
+	ar := newAutoReverse(cfg, res)
+	a1 := &authority{forward: true}
+	a1.Domain = "a.zig."
+	a2 := &authority{}
+	a2.Domain = "64-27.2.0.192.in-addr.arpa."
+	_, a2.cidr, _ = net.ParseCIDR("192.0.2.64/27")
+	for _, a := range []*authority{a1, a2} {
+		a.synthesizeSOA("a.zig.", 60)
+		ar.authorities.append(a)
+	}
+	ar.authorities.sort()
+
+	// A PTR with a regular owner name is moved into the classless zone
+	pz := &PTRZone{}
+	ptr := newRR("66.2.0.192.in-addr.arpa. IN PTR db.a.zig.").(*dns.PTR)
+	db := database.NewDatabase()
+	pz.addPTR(db, ar.authorities, ptr)
+	pz.addPTR(db, ar.authorities, newRR("99.2.0.192.in-addr.arpa. IN PTR oob.a.zig.").(*dns.PTR))
+	if pz.added != 1 || pz.oob != 1 {
+		t.Error("Classless PTR not added as expected", pz.added, pz.oob)
+	}
+	ar.dbGetter.Replace(db)
+
+	server := newServer(cfg, ar.dbGetter, res, nil, "", "")
+	server.setMutables("a.zig.", nil, ar.authorities)
+
+	var testCases = []struct {
+		qType  uint16
+		qName  string
+		rCode  int
+		expect dns.RR
+	}{
+		{dns.TypePTR, "65.64-27.2.0.192.in-addr.arpa.", dns.RcodeSuccess,
+			newRR("65.64-27.2.0.192.in-addr.arpa. IN PTR 192-0-2-65.a.zig.")},
+		{dns.TypePTR, "66.64-27.2.0.192.in-addr.arpa.", dns.RcodeSuccess,
+			newRR("66.64-27.2.0.192.in-addr.arpa. IN PTR db.a.zig.")},
+		{dns.TypeA, "192-0-2-95.a.zig.", dns.RcodeSuccess,
+			newRR("192-0-2-95.a.zig. IN A 192.0.2.95")},
+		{dns.TypeA, "192-0-2-96.a.zig.", dns.RcodeNameError, nil},                // Outside CIDR
+		{dns.TypePTR, "96.64-27.2.0.192.in-addr.arpa.", dns.RcodeNameError, nil}, // Outside CIDR
+		{dns.TypePTR, "x.64-27.2.0.192.in-addr.arpa.", dns.RcodeNameError, nil},  // Not an octet
+		{dns.TypePTR, "1.65.64-27.2.0.192.in-addr.arpa.", dns.RcodeNameError, nil},
+		{dns.TypeTXT, "65.64-27.2.0.192.in-addr.arpa.", dns.RcodeSuccess, nil}, // Not PTR
+		{dns.TypePTR, "65.2.0.192.in-addr.arpa.", dns.RcodeRefused, nil},       // Parent's name
+	}
+
+	for ix, tc := range testCases {
+		query := setQuestion(dns.ClassINET, tc.qType, tc.qName)
+		server.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		if resp.Rcode != tc.rCode {
+			t.Error(ix, "Expected", dnsutil.RcodeToString(tc.rCode), "not", dnsutil.RcodeToString(resp.Rcode))
+			continue
+		}
+		if tc.expect == nil {
+			if len(resp.Answer) != 0 {
+				t.Error(ix, "Did not expect an answer", resp.Answer)
+			}
+			continue
+		}
+		if len(resp.Answer) != 1 || !dnsutil.RRIsEqual(resp.Answer[0], tc.expect) {
+			t.Error(ix, "Wrong answer. \nExp:", tc.expect, "\nGot:", resp.Answer)
+		}
+	}
+}
+
+func TestDNSMappedForwards(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	wtr := &mock.ResponseWriter{}
+	res := resolver.NewResolver()
+	cfg := &config{synthesizeFlag: true, TTLAsSecs: 3600}
+	ar := newAutoReverse(cfg, res)
+	ar.forward = "cust1.example.net."
+	ar.generateLocalForward("cust1.example.net.")
+	ar.generateLocalForward("cust2.example.net.")
+	for _, s := range []string{"2001:db8:1::/48", "2001:db8:2::/48=cust2.example.net", "192.0.2.0/24"} {
+		ipNets, err := convertReverseCIDRs("--local-reverse", []string{s}, ar.reverseForwards)
+		if err != nil {
+			t.Fatal("Setup", err)
+		}
+		ar.localReverses = append(ar.localReverses, ipNets...)
+	}
+	err := ar.generateLocalReverses()
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	ar.authorities.sort()
+	server := newServer(cfg, ar.dbGetter, res, nil, "", "")
+	server.setMutables(ar.forward, nil, ar.authorities)
+
+	var testCases = []struct {
+		qType  uint16
+		qName  string
+		rCode  int
+		expect dns.RR
+	}{
+		{dns.TypePTR, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", dns.RcodeSuccess,
+			newRR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. IN PTR 2001-db8-1--1.cust1.example.net.")},
+		{dns.TypePTR, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.2.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", dns.RcodeSuccess,
+			newRR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.2.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. IN PTR 2001-db8-2--1.cust2.example.net.")},
+		{dns.TypePTR, "1.2.0.192.in-addr.arpa.", dns.RcodeSuccess,
+			newRR("1.2.0.192.in-addr.arpa. IN PTR 192-0-2-1.cust1.example.net.")},
+		{dns.TypeAAAA, "2001-db8-1--1.cust1.example.net.", dns.RcodeSuccess,
+			newRR("2001-db8-1--1.cust1.example.net. IN AAAA 2001:db8:1::1")},
+		{dns.TypeAAAA, "2001-db8-2--1.cust2.example.net.", dns.RcodeSuccess,
+			newRR("2001-db8-2--1.cust2.example.net. IN AAAA 2001:db8:2::1")},
+		{dns.TypeA, "192-0-2-1.cust1.example.net.", dns.RcodeSuccess,
+			newRR("192-0-2-1.cust1.example.net. IN A 192.0.2.1")},
+		{dns.TypeAAAA, "2001-db8-2--1.cust1.example.net.", dns.RcodeNameError, nil}, // cust2 address
+		{dns.TypeAAAA, "2001-db8-1--1.cust2.example.net.", dns.RcodeNameError, nil}, // cust1 address
+		{dns.TypeA, "192-0-2-1.cust2.example.net.", dns.RcodeNameError, nil},        // Default is cust1
+	}
+
+	for ix, tc := range testCases {
+		query := setQuestion(dns.ClassINET, tc.qType, tc.qName)
+		server.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		if resp.Rcode != tc.rCode {
+			t.Error(ix, "Expected", dnsutil.RcodeToString(tc.rCode), "not", dnsutil.RcodeToString(resp.Rcode))
+			continue
+		}
+		if tc.expect == nil {
+			if len(resp.Answer) != 0 {
+				t.Error(ix, "Did not expect an answer", resp.Answer)
+			}
+			continue
+		}
+		if len(resp.Answer) != 1 || !dnsutil.RRIsEqual(resp.Answer[0], tc.expect) {
+			t.Error(ix, "Wrong answer. \nExp:", tc.expect, "\nGot:", resp.Answer)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package autoreverse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// typeNXNAME is the RFC9824 meta-type which distinguishes a Compact Denial of Existence
+// NXDOMAIN from NODATA. miekg does not yet define it.
+const typeNXNAME = 128
+
+// signResponse adds DNSSEC material to a response from one of our authorities. It's only
+// called when signing is configured and the query has the DO bit set.
+//
+// Negative responses use "black lies" - better known as Compact Denial of Existence
+// (RFC9824) - where a minimally covering NSEC is generated for the qName. This avoids
+// needing to know the previous and next names in the zone, which is impossible for
+// synthetic zones anyway. The downside is that an NXDOMAIN becomes a NOERROR, with the
+// NSEC type bitmap conveying the non-existence via NXNAME.
+//
+// All RRsets in the Answer and Authority sections are then signed. Additional section
+// RRs are left unsigned as validators don't need them for a positive or negative
+// proof. If any RRset cannot be signed the response is converted to a SERVFAIL, as a
+// partially signed response, or an unsigned black lie, is worse than no answer.
+func (t *server) signResponse(req *request) {
+	switch req.response.Rcode {
+	case dns.RcodeNameError:
+		req.response.Rcode = dns.RcodeSuccess
+		req.response.Ns = append(req.response.Ns,
+			t.compactNSEC(req, []uint16{dns.TypeRRSIG, dns.TypeNSEC, typeNXNAME}))
+		req.addNote("Black lie")
+
+	case dns.RcodeSuccess:
+		if len(req.response.Answer) == 0 && len(req.response.Ns) > 0 { // NODATA
+			req.response.Ns = append(req.response.Ns, t.compactNSEC(req, req.nsecTypes()))
+		}
+
+	default:
+		return // Nothing to prove for Refused, FormErr and friends
+	}
+
+	err := t.signSections(req)
+	if err != nil {
+		req.logError = fmt.Errorf("DNSSEC signing failed:%w", err)
+		req.addNote("Sign failed")
+		req.response.Rcode = dns.RcodeServerFailure
+		req.response.Answer = nil
+		req.response.Ns = nil
+		var extra []dns.RR
+		for _, rr := range req.response.Extra {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		req.response.Extra = extra
+	}
+
+	if req.maxSize > 0 { // Signatures may push the response over the limit
+		req.response.Truncate(int(req.maxSize))
+	}
+}
+
+// signSections adds the RRSIGs of every RRset in the Answer and Authority sections. On
+// error the sections may be partially signed.
+func (t *server) signSections(req *request) error {
+	now := time.Now()
+	signer := t.cfg.dnssecSigner
+	for _, section := range []*[]dns.RR{&req.response.Answer, &req.response.Ns} {
+		for _, rrset := range splitRRsets(*section) {
+			sigs, err := signer.Sign(req.auth.Domain, rrset, now)
+			if err != nil {
+				return err
+			}
+			*section = append(*section, sigs...)
+		}
+	}
+
+	return nil
+}
+
+// compactNSEC returns an NSEC for the qName which covers nothing but the qName itself as
+// the next name is the lowest possible child of qName. The TTL follows RFC9077. miekg
+// insists on a sorted type bitmap when packing.
+func (t *server) compactNSEC(req *request, types []uint16) dns.RR {
+	soa := &req.auth.SOA
+	rr := new(dns.NSEC)
+	rr.Hdr = dns.RR_Header{Name: req.qName, Rrtype: dns.TypeNSEC, Class: dns.ClassINET,
+		Ttl: soa.Minttl}
+	if soa.Hdr.Ttl < rr.Hdr.Ttl {
+		rr.Hdr.Ttl = soa.Hdr.Ttl
+	}
+	rr.NextDomain = `\000.` + req.qName
+	rr.TypeBitMap = types
+	sort.Slice(rr.TypeBitMap, func(i, j int) bool { return rr.TypeBitMap[i] < rr.TypeBitMap[j] })
+
+	return rr
+}
+
+// nsecTypes returns the NSEC type bitmap for a NODATA response. It includes all types
+// which exist at the qName whether they're from the apex, the database or synthesis, but
+// never the qType, otherwise the NSEC would contradict the response.
+func (req *request) nsecTypes() []uint16 {
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	if req.qName == req.auth.Domain {
+		types = append(types, dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY)
+	}
+	if req.db != nil {
+		types = append(types, req.db.Types(req.question.Qclass, req.qName)...)
+	}
+	types = append(types, req.existTypes...)
+
+	seen := make(map[uint16]bool)
+	ret := make([]uint16, 0, len(types))
+	for _, qType := range types {
+		if qType != req.question.Qtype && !seen[qType] {
+			seen[qType] = true
+			ret = append(ret, qType)
+		}
+	}
+
+	return ret
+}
+
+// splitRRsets groups RRs into RRsets in order of first appearance. Existing RRSIGs are
+// excluded as they are never themselves signed.
+func splitRRsets(rrs []dns.RR) [][]dns.RR {
+	var ret [][]dns.RR
+	index := make(map[string]int)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeRRSIG || hdr.Rrtype == dns.TypeOPT {
+			continue
+		}
+		key := strings.ToLower(hdr.Name) + "/" + dns.Type(hdr.Rrtype).String() + "/" +
+			dns.Class(hdr.Class).String()
+		ix, ok := index[key]
+		if !ok {
+			ix = len(ret)
+			index[key] = ix
+			ret = append(ret, nil)
+		}
+		ret[ix] = append(ret[ix], rr)
+	}
+
+	return ret
+}
@@ -0,0 +1,22 @@
+/*
+Package dnssec provides online signing of DNS responses. Keys are loaded from BIND-style
+key file pairs with LoadKey() and handed to a Signer which generates DNSKEY RRsets and
+RRSIGs for any zone on demand.
+
+Expected usage is:
+
+	key, err := dnssec.LoadKey("Kexample.net.+013+12345")
+	signer := dnssec.NewSigner([]*dnssec.Key{key})
+	for {
+	    sigs, err := signer.Sign(zone, rrset, time.Now())
+	}
+
+Keys are not bound to a particular zone. The owner name in the key file is ignored and the
+same keys sign every zone presented to the Signer. Since the key tag does not depend on
+the owner name, each zone only differs in the DS RRs published by the parent which are
+available via Signer.DS().
+
+Signatures are cached as synthetic answers are highly repetitive and signing is relatively
+expensive. Cached signatures are replaced well before they expire.
+*/
+package dnssec
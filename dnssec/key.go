@@ -0,0 +1,115 @@
+package dnssec
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Key is a DNSKEY and its private key as loaded from a key file pair.
+type Key struct {
+	DNSKEY *dns.DNSKEY
+	signer crypto.Signer
+	path   string // Base path for reporting purposes
+}
+
+// LoadKey reads a BIND-style key file pair as generated by dnssec-keygen or
+// ldns-keygen. The path can name either file of the pair or their common base, e.g.
+// Kexample.net.+013+12345, Kexample.net.+013+12345.key or
+// Kexample.net.+013+12345.private all load the same key.
+//
+// Only ECDSAP256SHA256 and ED25519 keys are accepted as they are compact and fast enough
+// for online signing. The private key is checked against the public key by way of a
+// trial signature.
+func LoadKey(path string) (*Key, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".key"), ".private")
+	pubFile := base + ".key"
+	privFile := base + ".private"
+
+	f, err := os.Open(pubFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, pubFile)
+	if err != nil {
+		return nil, err
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain a DNSKEY", pubFile)
+	}
+	switch dnskey.Algorithm {
+	case dns.ECDSAP256SHA256, dns.ED25519:
+	default:
+		return nil, fmt.Errorf("%s algorithm %s not supported. Must be ECDSAP256SHA256 or ED25519",
+			pubFile, dns.AlgorithmToString[dnskey.Algorithm])
+	}
+	if dnskey.Flags&dns.ZONE == 0 {
+		return nil, fmt.Errorf("%s is not a zone key", pubFile)
+	}
+
+	pf, err := os.Open(privFile)
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	pk, err := dnskey.ReadPrivateKey(pf, privFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := pk.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s private key cannot sign", privFile)
+	}
+
+	k := &Key{DNSKEY: dnskey, signer: signer, path: base}
+	err = k.selfTest()
+	if err != nil {
+		return nil, fmt.Errorf("%s %w", base, err)
+	}
+
+	return k, nil
+}
+
+// selfTest confirms that the private key matches the public key by signing and verifying
+// a trivial RRset.
+func (t *Key) selfTest() error {
+	rr := new(dns.TXT)
+	rr.Hdr = dns.RR_Header{Name: t.DNSKEY.Hdr.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60}
+	rr.Txt = []string{"self test"}
+	now := time.Now()
+	sig := t.newRRSIG(t.DNSKEY.Hdr.Name, now, now.Add(time.Hour))
+	err := sig.Sign(t.signer, []dns.RR{rr})
+	if err != nil {
+		return err
+	}
+	err = sig.Verify(t.DNSKEY, []dns.RR{rr})
+	if err != nil {
+		return fmt.Errorf("private key does not match public key:%w", err)
+	}
+
+	return nil
+}
+
+// newRRSIG returns an unsigned RRSIG populated with the details of this key.
+func (t *Key) newRRSIG(zone string, inception, expiration time.Time) *dns.RRSIG {
+	sig := new(dns.RRSIG)
+	sig.Hdr.Rrtype = dns.TypeRRSIG
+	sig.Algorithm = t.DNSKEY.Algorithm
+	sig.KeyTag = t.DNSKEY.KeyTag()
+	sig.SignerName = zone
+	sig.Inception = uint32(inception.Unix())
+	sig.Expiration = uint32(expiration.Unix())
+
+	return sig
+}
+
+// String returns a short description of the key suitable for logging.
+func (t *Key) String() string {
+	return fmt.Sprintf("%s %s/%d", t.path, dns.AlgorithmToString[t.DNSKEY.Algorithm], t.DNSKEY.KeyTag())
+}
@@ -0,0 +1,82 @@
+package dnssec
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// writeKey generates a key pair in dir and returns the base path.
+func writeKey(t *testing.T, dir string, alg uint8, flags uint16) string {
+	t.Helper()
+	k := new(dns.DNSKEY)
+	k.Hdr = dns.RR_Header{Name: "example.net.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600}
+	k.Flags = flags
+	k.Protocol = 3
+	k.Algorithm = alg
+	bits := 256
+	switch alg {
+	case dns.RSASHA256:
+		bits = 1024
+	}
+	priv, err := k.Generate(bits)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	base := filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", k.Hdr.Name, alg, k.KeyTag()))
+	err = os.WriteFile(base+".key", []byte(k.String()+"\n"), 0600)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	err = os.WriteFile(base+".private", []byte(k.PrivateKeyString(priv.(crypto.PrivateKey))), 0600)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+
+	return base
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	ecdsa := writeKey(t, dir, dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)
+	ed := writeKey(t, dir, dns.ED25519, dns.ZONE)
+	rsa := writeKey(t, dir, dns.RSASHA256, dns.ZONE)
+
+	for _, path := range []string{ecdsa, ecdsa + ".key", ecdsa + ".private", ed} {
+		k, err := LoadKey(path)
+		if err != nil {
+			t.Error("Unexpected error", path, err)
+			continue
+		}
+		if !strings.Contains(k.String(), "/") {
+			t.Error("String() missing keytag", k.String())
+		}
+	}
+
+	_, err := LoadKey(rsa)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Error("Expected RSA to be rejected, not", err)
+	}
+
+	_, err = LoadKey(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("Expected error with missing key files")
+	}
+
+	// Mismatched pair must fail the self test
+	mixed := filepath.Join(dir, "mixed")
+	b, _ := os.ReadFile(ecdsa + ".key")
+	os.WriteFile(mixed+".key", b, 0600)
+	other := writeKey(t, t.TempDir(), dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)
+	b, _ = os.ReadFile(other + ".private")
+	os.WriteFile(mixed+".private", b, 0600)
+	_, err = LoadKey(mixed)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Error("Expected mismatched key pair error, not", err)
+	}
+}
@@ -0,0 +1,164 @@
+package dnssec
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultValidity   = time.Hour * 24 * 7 // Lifetime of a new signature
+	defaultRefresh    = time.Hour * 24 * 2 // Re-sign when remaining lifetime is less than this
+	defaultInception  = time.Hour          // Back-date inception to allow for clock skew
+	defaultMaxEntries = 100000             // Limit cache size as synthetic names are endless
+)
+
+// Signer generates DNSKEY RRsets and RRSIGs for any zone using the same set of keys. A
+// Signer is safe for concurrent use.
+type Signer struct {
+	keys       []*Key
+	validity   time.Duration
+	refresh    time.Duration
+	maxEntries int
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	sigs      []*dns.RRSIG
+	refreshAt time.Time
+}
+
+// NewSigner returns a Signer which signs with all the supplied keys.
+func NewSigner(keys []*Key) *Signer {
+	return &Signer{keys: keys, validity: defaultValidity, refresh: defaultRefresh,
+		maxEntries: defaultMaxEntries, cache: make(map[string]*cacheEntry)}
+}
+
+// Keys returns the keys used by the Signer.
+func (t *Signer) Keys() []*Key {
+	return t.keys
+}
+
+// DNSKEYs returns the DNSKEY RRset for the zone.
+func (t *Signer) DNSKEYs(zone string, ttl uint32) []dns.RR {
+	var ret []dns.RR
+	for _, k := range t.keys {
+		rr := dns.Copy(k.DNSKEY).(*dns.DNSKEY)
+		rr.Hdr.Name = zone
+		rr.Hdr.Ttl = ttl
+		ret = append(ret, rr)
+	}
+
+	return ret
+}
+
+// DS returns the DS RRs the parent of zone needs to publish to create a secure
+// delegation. Only KSKs are returned, unless there are none, in which case all keys are
+// returned on the assumption that they are Combined Signing Keys.
+func (t *Signer) DS(zone string) []*dns.DS {
+	var ksks, all []*dns.DS
+	for _, rr := range t.DNSKEYs(zone, 0) {
+		k := rr.(*dns.DNSKEY)
+		ds := k.ToDS(dns.SHA256)
+		if ds == nil {
+			continue
+		}
+		all = append(all, ds)
+		if k.Flags&dns.SEP != 0 {
+			ksks = append(ksks, ds)
+		}
+	}
+	if len(ksks) > 0 {
+		return ksks
+	}
+
+	return all
+}
+
+// Sign returns RRSIGs from each key covering the RRset which must be a proper RFC2181
+// RRset with the same name, class, type and TTL. Signatures are returned from the cache
+// if one is present and is not due for refresh, otherwise new signatures are generated
+// and cached.
+func (t *Signer) Sign(zone string, rrset []dns.RR, now time.Time) ([]dns.RR, error) {
+	if len(rrset) == 0 {
+		return nil, nil
+	}
+	hdr := rrset[0].Header()
+	key := cacheKey(zone, rrset)
+
+	t.mu.Lock()
+	ce := t.cache[key]
+	t.mu.Unlock()
+
+	if ce == nil || now.After(ce.refreshAt) {
+		inception := now.Add(-defaultInception)
+		expiration := now.Add(t.validity)
+		ce = &cacheEntry{refreshAt: expiration.Add(-t.refresh)}
+		for _, k := range t.keys {
+			sig := k.newRRSIG(dns.CanonicalName(zone), inception, expiration)
+			sig.Hdr.Ttl = hdr.Ttl
+			err := sig.Sign(k.signer, rrset)
+			if err != nil {
+				return nil, err
+			}
+			ce.sigs = append(ce.sigs, sig)
+		}
+		t.store(key, ce)
+	}
+
+	// Return copies which carry the owner name exactly as presented, which may
+	// differ in case from the cached version due to things like 0x20 randomization.
+
+	ret := make([]dns.RR, 0, len(ce.sigs))
+	for _, sig := range ce.sigs {
+		rr := dns.Copy(sig)
+		rr.Header().Name = hdr.Name
+		ret = append(ret, rr)
+	}
+
+	return ret, nil
+}
+
+// store adds the entry to the cache. If the cache is full, arbitrary entries are removed
+// to make room, which relies on go's randomized map iteration. Not LRU, but cheap and good
+// enough to stop endless synthetic queries from exhausting memory.
+func (t *Signer) store(key string, ce *cacheEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k := range t.cache {
+		if len(t.cache) < t.maxEntries {
+			break
+		}
+		delete(t.cache, k)
+	}
+	t.cache[key] = ce
+}
+
+// cacheLen is a test helper.
+func (t *Signer) cacheLen() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.cache)
+}
+
+// cacheKey generates a key which uniquely identifies the RRset and signing zone. Owner
+// names are case-insensitive so they are normalized, but rdata is left untouched as it
+// may be case-sensitive.
+func cacheKey(zone string, rrset []dns.RR) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(zone))
+	for _, rr := range rrset {
+		cp := dns.Copy(rr)
+		cp.Header().Name = strings.ToLower(cp.Header().Name)
+		sb.WriteByte('\n')
+		sb.WriteString(cp.String())
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,126 @@
+package dnssec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	t.Helper()
+	dir := t.TempDir()
+	var keys []*Key
+	for _, alg := range []uint8{dns.ECDSAP256SHA256, dns.ED25519} {
+		k, err := LoadKey(writeKey(t, dir, alg, dns.ZONE|dns.SEP))
+		if err != nil {
+			t.Fatal("Setup", err)
+		}
+		keys = append(keys, k)
+	}
+
+	return NewSigner(keys)
+}
+
+func TestSign(t *testing.T) {
+	s := newTestSigner(t)
+	zone := "8.b.d.0.1.0.0.2.ip6.arpa."
+	rr, _ := dns.NewRR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.B.D.0.1.0.0.2.ip6.arpa. 60 IN PTR host.example.net.")
+	rrset := []dns.RR{rr}
+	now := time.Now()
+	sigs, err := s.Sign(zone, rrset, now)
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	if len(sigs) != 2 {
+		t.Fatal("Expected one RRSIG per key, not", len(sigs))
+	}
+
+	dnskeys := s.DNSKEYs(zone, 3600)
+	for ix, rr := range sigs {
+		sig := rr.(*dns.RRSIG)
+		if sig.Hdr.Name != rrset[0].Header().Name {
+			t.Error(ix, "RRSIG owner should match RRset owner", sig.Hdr.Name)
+		}
+		if sig.SignerName != zone || sig.Hdr.Ttl != 60 {
+			t.Error(ix, "Wrong signer or TTL", sig)
+		}
+		err = sig.Verify(dnskeys[ix].(*dns.DNSKEY), rrset)
+		if err != nil {
+			t.Error(ix, "Verify failed", err)
+		}
+		if !sig.ValidityPeriod(now) {
+			t.Error(ix, "Signature not currently valid", sig)
+		}
+	}
+
+	// A different case owner should hit the cache yet still carry the new case
+	rr2 := dns.Copy(rr)
+	rr2.Header().Name = "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.IP6.ARPA."
+	sigs2, err := s.Sign(zone, []dns.RR{rr2}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	if s.cacheLen() != 1 {
+		t.Error("Expected cache hit, cache length is", s.cacheLen())
+	}
+	if sigs2[0].(*dns.RRSIG).Inception != sigs[0].(*dns.RRSIG).Inception {
+		t.Error("Expected cached signature to be returned")
+	}
+	if sigs2[0].Header().Name != rr2.Header().Name {
+		t.Error("Cached RRSIG should carry presented owner", sigs2[0].Header().Name)
+	}
+
+	// Approaching expiry should trigger a re-sign
+	later := now.Add(s.validity - s.refresh + time.Minute)
+	sigs3, err := s.Sign(zone, rrset, later)
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	if sigs3[0].(*dns.RRSIG).Inception == sigs[0].(*dns.RRSIG).Inception {
+		t.Error("Expected re-signing ahead of expiry")
+	}
+
+	// Different rdata must not share a cache entry
+	rr4, _ := dns.NewRR("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 60 IN PTR other.example.net.")
+	_, err = s.Sign(zone, []dns.RR{rr4}, now)
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	if s.cacheLen() != 2 {
+		t.Error("Expected separate cache entry, cache length is", s.cacheLen())
+	}
+}
+
+func TestSignCacheLimit(t *testing.T) {
+	s := newTestSigner(t)
+	s.maxEntries = 3
+	now := time.Now()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		rr, _ := dns.NewRR(name + ".example.net. 60 IN A 192.0.2.1")
+		_, err := s.Sign("example.net.", []dns.RR{rr}, now)
+		if err != nil {
+			t.Fatal("Unexpected", err)
+		}
+	}
+	if s.cacheLen() != 3 {
+		t.Error("Cache should be limited to 3, not", s.cacheLen())
+	}
+}
+
+func TestDS(t *testing.T) {
+	s := newTestSigner(t)
+	ds := s.DS("example.org.")
+	if len(ds) != 2 {
+		t.Fatal("Expected two DS RRs, not", len(ds))
+	}
+	for ix, d := range ds {
+		if d.Hdr.Name != "example.org." || d.DigestType != dns.SHA256 {
+			t.Error(ix, "Wrong DS", d)
+		}
+	}
+	s.keys[1].DNSKEY.Flags = dns.ZONE // No longer a KSK so only one DS expected
+	if len(s.DS("example.org.")) != 1 {
+		t.Error("Expected only KSK DS")
+	}
+}
@@ -0,0 +1,248 @@
+package autoreverse
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/database"
+	"github.com/markdingo/autoreverse/dnssec"
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+// writeDNSSECKey generates a key file pair in dir and returns the base path.
+func writeDNSSECKey(t *testing.T, dir string, alg uint8) string {
+	t.Helper()
+	k := new(dns.DNSKEY)
+	k.Hdr = dns.RR_Header{Name: "example.net.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600}
+	k.Flags = dns.ZONE | dns.SEP
+	k.Protocol = 3
+	k.Algorithm = alg
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	base := filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", k.Hdr.Name, alg, k.KeyTag()))
+	err = os.WriteFile(base+".key", []byte(k.String()+"\n"), 0600)
+	if err == nil {
+		err = os.WriteFile(base+".private", []byte(k.PrivateKeyString(priv)), 0600)
+	}
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+
+	return base
+}
+
+func TestDNSSECValidate(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	ar := newAutoReverse(nil, nil)
+	ar.cfg.TTL = time.Second * 2
+	ar.cfg.reportInterval = time.Second * 2
+	ar.cfg.localForward = "example.net"
+	ar.cfg.localReverse = []string{"2001:db8::/64"}
+	ar.cfg.DNSSECKeyFiles = []string{writeDNSSECKey(t, t.TempDir(), dns.ED25519)}
+	err := ar.ValidateCommandLineOptions()
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	if ar.cfg.dnssecSigner == nil {
+		t.Error("Signer not created from --DNSSEC-key")
+	}
+
+	ar.cfg.AXFRAllow = []string{"127.0.0.0/8"}
+	err = ar.ValidateCommandLineOptions()
+	if err == nil || !strings.Contains(err.Error(), "signed zones are not transferable") {
+		t.Error("Expected --AXFR-allow with --DNSSEC-key error, not", err)
+	}
+	ar.cfg.AXFRAllow = nil
+
+	ar.cfg.DNSSECKeyFiles = []string{filepath.Join(t.TempDir(), "Kmissing")}
+	err = ar.ValidateCommandLineOptions()
+	if err == nil || !strings.Contains(err.Error(), "--DNSSEC-key") {
+		t.Error("Expected --DNSSEC-key error, not", err)
+	}
+}
+
+func TestDNSSECSigning(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	key, err := dnssec.LoadKey(writeDNSSECKey(t, t.TempDir(), dns.ECDSAP256SHA256))
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	wtr := &mock.ResponseWriter{}
+	res := resolver.NewResolver()
+	cfg := &config{synthesizeFlag: true, TTLAsSecs: 3600, dnssecSigner: dnssec.NewSigner([]*dnssec.Key{key})}
+	ar := newAutoReverse(cfg, res)
+	ar.forward = "example.net."
+	ar.generateLocalForward(ar.forward)
+	_, ipNet, _ := net.ParseCIDR("2001:db8::/64")
+	ar.localReverses = append(ar.localReverses, ipNet)
+	err = ar.generateLocalReverses()
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	ar.authorities.sort()
+
+	db := database.NewDatabase()
+	db.AddRR(newRR("www.example.net. IN TXT 'hello'"))
+	db.AddRR(newRR("example.net. IN TXT 'apex'"))
+	ar.dbGetter.Replace(db)
+
+	server := newServer(cfg, ar.dbGetter, res, nil, "", "")
+	server.setMutables(ar.forward, nil, ar.authorities)
+	dnskey := cfg.dnssecSigner.DNSKEYs("example.net.", 60)[0].(*dns.DNSKEY)
+	revKey := cfg.dnssecSigner.DNSKEYs("0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", 60)[0].(*dns.DNSKEY)
+
+	const ptrName = "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."
+
+	testCases := []struct {
+		qType     uint16
+		qName     string
+		do        bool
+		rCode     int
+		answers   int      // Including RRSIGs
+		nsec      []uint16 // Expected NSEC bitmap, if any
+		signerKey *dns.DNSKEY
+	}{
+		{dns.TypePTR, ptrName, true, dns.RcodeSuccess, 2, nil, revKey},
+		{dns.TypePTR, ptrName, false, dns.RcodeSuccess, 1, nil, nil},
+		{dns.TypeDNSKEY, "example.net.", true, dns.RcodeSuccess, 2, nil, dnskey},
+		{dns.TypeAAAA, "2001-db8--1.example.net.", true, dns.RcodeSuccess, 2, nil, dnskey},
+		{dns.TypeTXT, "www.example.net.", true, dns.RcodeSuccess, 2, nil, dnskey},
+		{dns.TypeAAAA, "nosuch.example.net.", true, dns.RcodeSuccess, 0,
+			[]uint16{dns.TypeRRSIG, dns.TypeNSEC, typeNXNAME}, dnskey}, // Black lie
+		{dns.TypeAAAA, "nosuch.example.net.", false, dns.RcodeNameError, 0, nil, nil},
+		{dns.TypeTXT, ptrName, true, dns.RcodeSuccess, 0,
+			[]uint16{dns.TypePTR, dns.TypeRRSIG, dns.TypeNSEC}, revKey}, // NODATA
+		{dns.TypeMX, "example.net.", true, dns.RcodeSuccess, 0,
+			[]uint16{dns.TypeNS, dns.TypeSOA, dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}, dnskey},
+	}
+
+	for ix, tc := range testCases {
+		query := setQuestion(dns.ClassINET, tc.qType, tc.qName)
+		if tc.do {
+			query.SetEdns0(1232, true)
+		}
+		server.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		if resp.Rcode != tc.rCode {
+			t.Error(ix, "Wrong rcode", dns.RcodeToString[resp.Rcode], resp)
+			continue
+		}
+		if len(resp.Answer) != tc.answers {
+			t.Error(ix, "Wrong answer count", len(resp.Answer), resp.Answer)
+			continue
+		}
+		opt := resp.IsEdns0()
+		if tc.do != (opt != nil && opt.Do()) {
+			t.Error(ix, "DO bit not reflected in response", opt)
+		}
+		if !tc.do {
+			for _, rr := range append(resp.Answer, resp.Ns...) {
+				if rr.Header().Rrtype == dns.TypeRRSIG || rr.Header().Rrtype == dns.TypeNSEC {
+					t.Error(ix, "DNSSEC RR without DO", rr)
+				}
+			}
+			continue
+		}
+
+		// Every RRset in Answer and Authority must verify
+		for _, section := range [][]dns.RR{resp.Answer, resp.Ns} {
+			var sigs []*dns.RRSIG
+			for _, rr := range section {
+				if sig, ok := rr.(*dns.RRSIG); ok {
+					sigs = append(sigs, sig)
+				}
+			}
+			for _, rrset := range splitRRsets(section) {
+				var verified bool
+				for _, sig := range sigs {
+					if sig.TypeCovered == rrset[0].Header().Rrtype &&
+						sig.Verify(tc.signerKey, rrset) == nil {
+						verified = true
+					}
+				}
+				if !verified {
+					t.Error(ix, "RRset not signed", rrset)
+				}
+			}
+		}
+
+		var nsec *dns.NSEC
+		for _, rr := range resp.Ns {
+			if n, ok := rr.(*dns.NSEC); ok {
+				nsec = n
+			}
+		}
+		if (nsec != nil) != (tc.nsec != nil) {
+			t.Error(ix, "NSEC presence mismatch", nsec)
+			continue
+		}
+		if nsec == nil {
+			continue
+		}
+		if nsec.Hdr.Name != tc.qName || nsec.NextDomain != `\000.`+tc.qName {
+			t.Error(ix, "NSEC does not cover qName", nsec)
+		}
+		if fmt.Sprint(nsec.TypeBitMap) != fmt.Sprint(tc.nsec) {
+			t.Error(ix, "Wrong NSEC bitmap. Exp", tc.nsec, "Got", nsec.TypeBitMap)
+		}
+	}
+}
+
+// A signing failure must not result in a partially signed or oversized response.
+func TestDNSSECSigningFailure(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	key, err := dnssec.LoadKey(writeDNSSECKey(t, t.TempDir(), dns.ED25519))
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	key.DNSKEY.Algorithm = dns.ECDSAP256SHA256 // Ed25519 refuses to sign a SHA256 digest
+	res := resolver.NewResolver()
+	cfg := &config{synthesizeFlag: true, TTLAsSecs: 3600, dnssecSigner: dnssec.NewSigner([]*dnssec.Key{key})}
+	ar := newAutoReverse(cfg, res)
+	ar.forward = "example.net."
+	ar.generateLocalForward(ar.forward)
+	ar.authorities.sort()
+	ar.dbGetter.Replace(database.NewDatabase())
+	server := newServer(cfg, ar.dbGetter, res, nil, "", "")
+	server.setMutables(ar.forward, nil, ar.authorities)
+
+	wtr := &mock.ResponseWriter{}
+	for ix, qName := range []string{"2001-db8--1.example.net.", "nosuch.example.net."} {
+		query := setQuestion(dns.ClassINET, dns.TypeAAAA, qName)
+		query.SetEdns0(1232, true)
+		server.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		if resp.Rcode != dns.RcodeServerFailure || len(resp.Answer) != 0 || len(resp.Ns) != 0 {
+			t.Error(ix, "Expected empty SERVFAIL, not", resp)
+		}
+		if resp.IsEdns0() == nil {
+			t.Error(ix, "OPT removed from SERVFAIL", resp.Extra)
+		}
+	}
+}
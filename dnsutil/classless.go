@@ -0,0 +1,63 @@
+package dnsutil
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IsClassless returns true if the CIDR is an ipv4 prefix longer than a /24 and shorter
+// than a /32, which is the range for which RFC2317 classless in-addr.arpa delegation
+// applies.
+func IsClassless(ipNet *net.IPNet) bool {
+	if ipNet == nil || ipNet.IP.To4() == nil {
+		return false
+	}
+	ones, bits := ipNet.Mask.Size()
+
+	return bits == 32 && ones > 24 && ones < 32
+}
+
+// ClasslessReverseZone returns the RFC2317 style zone name for a classless CIDR, e.g.
+// 192.0.2.64/27 returns 64/27.2.0.192.in-addr.arpa. This is the suggested form in RFC2317
+// but it is by no means universal, so callers should prefer the zone name referred to by
+// the parent's CNAMEs, if known. An empty string is returned if the CIDR is not
+// classless.
+func ClasslessReverseZone(ipNet *net.IPNet) string {
+	if !IsClassless(ipNet) {
+		return ""
+	}
+	ip4 := ipNet.IP.To4()
+	ones, _ := ipNet.Mask.Size()
+
+	return fmt.Sprintf("%d/%d.%d.%d.%d%s", ip4[3], ones, ip4[2], ip4[1], ip4[0], V4Suffix)
+}
+
+// InvertClasslessPtrToIPv4 takes the first label of a reverse qName within a classless
+// zone and converts it back into an ipv4 address within the classless CIDR. As a
+// reminder, a parent delegating 192.0.2.64/27 via RFC2317 CNAMEs 65.2.0.192.in-addr.arpa
+// to something like 65.64/27.2.0.192.in-addr.arpa thus the caller removes the classless
+// zone name leaving just "65".
+//
+// Unlike InvertPtrToIPv4 there is no truncated form as the classless zone is always the
+// immediate parent of the address labels. An error is returned if the label is not a
+// decimal octet or if the resulting address is outside the CIDR.
+func InvertClasslessPtrToIPv4(label string, ipNet *net.IPNet) (net.IP, error) {
+	if !IsClassless(ipNet) {
+		return nil, fmt.Errorf("%s is not a classless CIDR", ipNet.String())
+	}
+	if strings.Contains(label, ".") {
+		return nil, fmt.Errorf("Classless reverse '%s' must be a single label", label)
+	}
+	v := convertDecimalOctet(label)
+	if v == -1 {
+		return nil, fmt.Errorf("Malformed classless reverse ipv4 octet '%s'", label)
+	}
+	ip4 := ipNet.IP.To4()
+	ip := net.IPv4(ip4[0], ip4[1], ip4[2], byte(v))
+	if !ipNet.Contains(ip) {
+		return nil, fmt.Errorf("Classless reverse %s is not within %s", ip.String(), ipNet.String())
+	}
+
+	return ip, nil
+}
@@ -0,0 +1,75 @@
+package dnsutil
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClasslessReverseZone(t *testing.T) {
+	testCases := []struct {
+		cidr   string
+		expect string
+	}{
+		{"192.0.2.64/27", "64/27.2.0.192.in-addr.arpa."},
+		{"192.0.2.128/25", "128/25.2.0.192.in-addr.arpa."},
+		{"198.51.100.6/31", "6/31.100.51.198.in-addr.arpa."},
+		{"192.0.2.0/24", ""},
+		{"192.0.2.1/32", ""},
+		{"2001:db8::/120", ""},
+	}
+
+	for ix, tc := range testCases {
+		_, ipNet, err := net.ParseCIDR(tc.cidr)
+		if err != nil {
+			t.Fatal("Setup", err)
+		}
+		got := ClasslessReverseZone(ipNet)
+		if got != tc.expect {
+			t.Error(ix, tc.cidr, "Want", tc.expect, "got", got)
+		}
+		if IsClassless(ipNet) != (len(tc.expect) > 0) {
+			t.Error(ix, tc.cidr, "IsClassless mismatch")
+		}
+	}
+}
+
+func TestInvertClasslessPtrToIPv4(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("192.0.2.64/27")
+	testCases := []struct {
+		label, expect string
+	}{
+		{"64", "192.0.2.64"},
+		{"65", "192.0.2.65"},
+		{"95", "192.0.2.95"},
+		{"63", ""},
+		{"96", ""},
+		{"065", ""},
+		{"256", ""},
+		{"a", ""},
+		{"", ""},
+		{"1.65", ""},
+	}
+
+	for ix, tc := range testCases {
+		ip, err := InvertClasslessPtrToIPv4(tc.label, ipNet)
+		if err != nil {
+			if len(tc.expect) > 0 {
+				t.Error(ix, "Unexpected error with", tc.label, err)
+			}
+			continue
+		}
+		if len(tc.expect) == 0 {
+			t.Error(ix, "Expected error, got none with", tc.label, "and", ip)
+			continue
+		}
+		if ip.String() != tc.expect {
+			t.Error(ix, "Mismatch. Expected:", tc.expect, "got", ip)
+		}
+	}
+
+	_, ipNet, _ = net.ParseCIDR("192.0.2.0/24")
+	_, err := InvertClasslessPtrToIPv4("1", ipNet)
+	if err == nil {
+		t.Error("Expected error with non-classless CIDR")
+	}
+}
@@ -34,3 +34,65 @@ func IPToReverseQName(ip net.IP) string {
 
 	return strings.Join(joiner, ".") + V6Suffix
 }
+
+// NibbleCIDRs returns the set of nibble-aligned CIDRs which exactly cover the supplied
+// ipv6 CIDR. The ip6.arpa zone can only be delegated on nibble (4 bit) boundaries so a
+// prefix such as a /46 has to be served as four /48 zones. The supplied CIDR is returned
+// as the sole member if it is already nibble-aligned or if it is ipv4.
+func NibbleCIDRs(ipNet *net.IPNet) []*net.IPNet {
+	ones, bits := ipNet.Mask.Size()
+	if bits != 8*net.IPv6len || ones%4 == 0 {
+		return []*net.IPNet{ipNet}
+	}
+
+	nibbleOnes := (ones + 3) / 4 * 4
+	count := 1 << (nibbleOnes - ones)
+	ipNets := make([]*net.IPNet, 0, count)
+	for ix := 0; ix < count; ix++ {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, ipNet.IP.To16())
+		// The extra bits all fall within the same nibble which never spans a byte
+		// boundary, so it's a simple matter of or'ing them into the right byte.
+		shift := uint(8-nibbleOnes%8) % 8 // Nibble is in the top or bottom of its byte
+		ip[(nibbleOnes-1)/8] |= byte(ix) << shift
+		ipNets = append(ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(nibbleOnes, bits)})
+	}
+
+	return ipNets
+}
+
+// EUI64ToMAC returns the MAC address embedded in the interface identifier of an ipv6
+// address formed with modified EUI-64 (RFC4291 Appendix A), such as by SLAAC. The
+// identifier is the MAC with ff:fe inserted in the middle and the universal/local bit
+// inverted. Nil is returned for ipv4 addresses and identifiers without the ff:fe marker,
+// such as privacy addresses.
+func EUI64ToMAC(ip net.IP) net.HardwareAddr {
+	if ip.To4() != nil {
+		return nil
+	}
+	ip6 := ip.To16()
+	if ip6 == nil || ip6[11] != 0xff || ip6[12] != 0xfe {
+		return nil
+	}
+
+	return net.HardwareAddr{ip6[8] ^ 0x02, ip6[9], ip6[10], ip6[13], ip6[14], ip6[15]}
+}
+
+// MACToEUI64 is the inverse of EUI64ToMAC. It returns the ipv6 address formed by the /64
+// prefix of the ip and the modified EUI-64 interface identifier of the 48-bit MAC. Nil is
+// returned for ipv4 addresses and MACs which are not 48-bit.
+func MACToEUI64(prefix net.IP, mac net.HardwareAddr) net.IP {
+	if prefix.To4() != nil || len(mac) != 6 {
+		return nil
+	}
+	ip6 := prefix.To16()
+	if ip6 == nil {
+		return nil
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, ip6[:8])
+	copy(ip[8:], []byte{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]})
+
+	return ip
+}
@@ -39,3 +39,99 @@ func TestIPToReverseBogus(t *testing.T) {
 		t.Error("Expected '' from bogus IP, not", got)
 	}
 }
+
+func TestNibbleCIDRs(t *testing.T) {
+	testCases := []struct {
+		cidr   string
+		expect []string
+	}{
+		{"2001:db8::/48", []string{"2001:db8::/48"}},
+		{"192.0.2.0/24", []string{"192.0.2.0/24"}},
+		{"2001:db8::/46", []string{"2001:db8::/48", "2001:db8:1::/48", "2001:db8:2::/48", "2001:db8:3::/48"}},
+		{"2001:db8:0:4::/62", []string{"2001:db8:0:4::/64", "2001:db8:0:5::/64",
+			"2001:db8:0:6::/64", "2001:db8:0:7::/64"}},
+		{"2001:db8:ff00::/41", []string{"2001:db8:ff00::/44", "2001:db8:ff10::/44",
+			"2001:db8:ff20::/44", "2001:db8:ff30::/44", "2001:db8:ff40::/44",
+			"2001:db8:ff50::/44", "2001:db8:ff60::/44", "2001:db8:ff70::/44"}},
+		{"2001:db8:0:80::/59", []string{"2001:db8:0:80::/60", "2001:db8:0:90::/60"}},
+	}
+
+	for ix, tc := range testCases {
+		_, ipNet, err := net.ParseCIDR(tc.cidr)
+		if err != nil {
+			t.Fatal("Setup", err)
+		}
+		got := dnsutil.NibbleCIDRs(ipNet)
+		if len(got) != len(tc.expect) {
+			t.Error(ix, tc.cidr, "Wrong count. Want", len(tc.expect), "got", got)
+			continue
+		}
+		for jx, n := range got {
+			if n.String() != tc.expect[jx] {
+				t.Error(ix, jx, tc.cidr, "Want", tc.expect[jx], "got", n.String())
+			}
+			if !ipNet.Contains(n.IP) {
+				t.Error(ix, jx, tc.cidr, "Sub-CIDR outside original", n)
+			}
+		}
+	}
+}
+
+func TestEUI64ToMAC(t *testing.T) {
+	testCases := []struct{ ipStr, expect string }{
+		{"2001:db8::5054:ff:fe12:3456", "52:54:00:12:34:56"},
+		{"fe80::a00:20ff:fe01:203", "08:00:20:01:02:03"},
+		{"2001:db8::200:ff:fe00:0", "00:00:00:00:00:00"}, // U/L bit inverted
+		{"2001:db8::9c3e:61d2:7ab0:11f4", ""},            // Privacy address
+		{"2001:db8::1", ""},
+		{"192.0.2.1", ""},
+		{"::ffff:192.0.2.1", ""}, // ipv4-mapped
+	}
+
+	for ix, tc := range testCases {
+		got := dnsutil.EUI64ToMAC(net.ParseIP(tc.ipStr))
+		if tc.expect == "" {
+			if got != nil {
+				t.Error(ix, "Input:", tc.ipStr, "Expected nil, not", got)
+			}
+			continue
+		}
+		if got.String() != tc.expect {
+			t.Error(ix, "Input:", tc.ipStr, "Got", got, "Expected", tc.expect)
+		}
+	}
+	if dnsutil.EUI64ToMAC(nil) != nil {
+		t.Error("Expected nil from nil IP")
+	}
+}
+
+func TestMACToEUI64(t *testing.T) {
+	testCases := []struct{ prefix, mac, expect string }{
+		{"2001:db8::", "52:54:00:12:34:56", "2001:db8::5054:ff:fe12:3456"},
+		{"2001:db8:0:1:ffff::1", "08:00:20:01:02:03", "2001:db8:0:1:a00:20ff:fe01:203"},
+		{"2001:db8::", "00:00:00:00:00:00", "2001:db8::200:ff:fe00:0"},
+		{"192.0.2.1", "52:54:00:12:34:56", ""},
+		{"2001:db8::", "00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01", ""},
+	}
+
+	for ix, tc := range testCases {
+		mac, err := net.ParseMAC(tc.mac)
+		if err != nil {
+			t.Fatal(ix, "Setup", err)
+		}
+		got := dnsutil.MACToEUI64(net.ParseIP(tc.prefix), mac)
+		if tc.expect == "" {
+			if got != nil {
+				t.Error(ix, "Input:", tc.prefix, tc.mac, "Expected nil, not", got)
+			}
+			continue
+		}
+		if !got.Equal(net.ParseIP(tc.expect)) {
+			t.Error(ix, "Input:", tc.prefix, tc.mac, "Got", got, "Expected", tc.expect)
+			continue
+		}
+		if dnsutil.EUI64ToMAC(got).String() != tc.mac {
+			t.Error(ix, "Round trip failed", got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package dnsutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// keyedEncoding renders encrypted addresses as DNS-safe lower-case labels.
+var keyedEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+const (
+	keyedFeistelRounds = 8
+	v4KeyedWidth       = 7  // base32 of 4 bytes
+	v6KeyedWidth       = 26 // base32 of 16 bytes
+)
+
+// keyedCipher provides reversible, keyed encryption of ip addresses so that synthetic
+// names do not reveal the address to anyone lacking the key. An ipv6 address is exactly
+// one AES block so it's encrypted directly. An ipv4 address is too small for AES so a
+// Feistel network with AES as the round function is used instead, which retains the
+// 32-bit size.
+type keyedCipher struct {
+	block cipher.Block
+}
+
+// ParsePTRKey converts the hex text of a key file into a key suitable for
+// PTRTemplate.SetKey(). Blank lines and lines starting with '#' are ignored. The key must
+// be 16, 24 or 32 bytes, e.g. as generated by "openssl rand -hex 16".
+func ParsePTRKey(text string) ([]byte, error) {
+	var hexKey string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		hexKey += line
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid hex:%w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("key length %d must be 16, 24 or 32 bytes", len(key))
+	}
+
+	return key, nil
+}
+
+func newKeyedCipher(key []byte) (*keyedCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyedCipher{block: block}, nil
+}
+
+func (t *keyedCipher) encrypt6(ip net.IP) string {
+	b := make([]byte, net.IPv6len)
+	t.block.Encrypt(b, ip.To16())
+
+	return keyedEncoding.EncodeToString(b)
+}
+
+func (t *keyedCipher) decrypt6(s string) net.IP {
+	b, err := keyedEncoding.DecodeString(s)
+	if err != nil || len(b) != net.IPv6len || keyedEncoding.EncodeToString(b) != s {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	t.block.Decrypt(ip, b)
+	if ip.To4() != nil { // Must not masquerade as an ipv4 address
+		return nil
+	}
+
+	return ip
+}
+
+func (t *keyedCipher) encrypt4(ip net.IP) string {
+	ip4 := ip.To4()
+	v := uint32(ip4[0])<<24 | uint32(ip4[1])<<16 | uint32(ip4[2])<<8 | uint32(ip4[3])
+	l, r := uint16(v>>16), uint16(v)
+	for round := 0; round < keyedFeistelRounds; round++ {
+		l, r = r, l^t.roundFunction(round, r)
+	}
+	v = uint32(l)<<16 | uint32(r)
+
+	return keyedEncoding.EncodeToString([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+func (t *keyedCipher) decrypt4(s string) net.IP {
+	b, err := keyedEncoding.DecodeString(s)
+	if err != nil || len(b) != net.IPv4len || keyedEncoding.EncodeToString(b) != s {
+		return nil
+	}
+	l, r := uint16(b[0])<<8|uint16(b[1]), uint16(b[2])<<8|uint16(b[3])
+	for round := keyedFeistelRounds - 1; round >= 0; round-- {
+		l, r = r^t.roundFunction(round, l), l
+	}
+
+	return net.IPv4(byte(l>>8), byte(l), byte(r>>8), byte(r)).To4()
+}
+
+func (t *keyedCipher) roundFunction(round int, half uint16) uint16 {
+	var in, out [aes.BlockSize]byte
+	in[0] = byte(round)
+	in[1] = byte(half >> 8)
+	in[2] = byte(half)
+	t.block.Encrypt(out[:], in[:])
+
+	return uint16(out[0])<<8 | uint16(out[1])
+}
@@ -0,0 +1,102 @@
+package dnsutil
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParsePTRKey(t *testing.T) {
+	testCases := []struct {
+		text     string
+		length   int
+		contains string
+	}{
+		{"000102030405060708090a0b0c0d0e0f\n", 16, ""},
+		{"# Comment\n\n  000102030405060708090a0b0c0d0e0f  \n0001020304050607\n", 24, ""},
+		{"000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f", 32, ""},
+		{"0001020304050607", 0, "length 8"},
+		{"", 0, "length 0"},
+		{"xyzzy", 0, "not valid hex"},
+	}
+
+	for ix, tc := range testCases {
+		key, err := ParsePTRKey(tc.text)
+		if err != nil {
+			if len(tc.contains) == 0 {
+				t.Error(ix, "Unexpected error", err)
+			} else if !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		if len(key) != tc.length {
+			t.Error(ix, "Wrong key length. Want", tc.length, "got", len(key))
+		}
+	}
+}
+
+func TestPTRTemplateKeyed(t *testing.T) {
+	key1, _ := ParsePTRKey("000102030405060708090a0b0c0d0e0f")
+	key2, _ := ParsePTRKey("0f0e0d0c0b0a09080706050403020100")
+
+	tmpl4, err := NewPTRTemplate("ip-{v4keyed}.{forward}")
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	tmpl6, err := NewPTRTemplate("{v6keyed}.{forward}")
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	if !tmpl4.NeedsKey() || !tmpl6.NeedsKey() || !tmpl4.IsIPv4() || tmpl6.IsIPv4() {
+		t.Fatal("Keyed templates wrongly classified")
+	}
+	tmplD, _ := NewPTRTemplate(DefaultPTRTemplate6)
+	if tmplD.NeedsKey() {
+		t.Error("Default template should not need a key")
+	}
+
+	for _, tmpl := range []*PTRTemplate{tmpl4, tmpl6} {
+		for _, ipStr := range []string{"192.0.2.1", "192.0.2.2", "0.0.0.0", "255.255.255.255",
+			"2001:db8::1", "2001:db8::2", "fd2d:ffff::ffff:1"} {
+			ip := net.ParseIP(ipStr)
+			if tmpl.IsIPv4() != (ip.To4() != nil) {
+				continue
+			}
+			tmpl.SetKey(key1)
+			name1 := tmpl.Synthesize(ip, "example.net.")
+			if strings.Contains(name1, strings.ReplaceAll(strings.ReplaceAll(ipStr, ".", "-"), ":", "-")) {
+				t.Error("Keyed name reveals address", ipStr, name1)
+			}
+			back := tmpl.Parse(name1, "example.net.")
+			if !ip.Equal(back) {
+				t.Error("Keyed round-trip failed", ipStr, name1, back)
+			}
+
+			tmpl.SetKey(key2) // Different key should produce a different name
+			name2 := tmpl.Synthesize(ip, "example.net.")
+			if name1 == name2 {
+				t.Error("Different keys produced the same name", ipStr, name1)
+			}
+			back = tmpl.Parse(name1, "example.net.")
+			if ip.Equal(back) {
+				t.Error("Wrong key decrypted", ipStr, name1)
+			}
+		}
+	}
+
+	// Malformed encodings must not parse
+	tmpl6.SetKey(key1)
+	for _, name := range []string{"aaaaaaaaaaaaaaaaaaaaaaaaa.example.net.", // Too short
+		"aaaaaaaaaaaaaaaaaaaaaaaaa1.example.net.", // Not in alphabet
+		"aaaaaaaaaaaaaaaaaaaaaaaaab.example.net.", // Non-zero trailing bits
+	} {
+		if ip := tmpl6.Parse(name, "example.net."); ip != nil {
+			t.Error("Expected nil parse of", name, "got", ip)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package dnsutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// templateToken identifies each of the {tokens} which can appear in a PTRTemplate.
+type templateToken int
+
+const (
+	literalToken      templateToken = iota // Not a token, just text
+	v4DashToken                            // {v4dash} 192-0-2-1
+	v6DashToken                            // {v6dash} 2001-db8--1
+	v6Hex32Token                           // {v6hex32} 20010db8000000000000000000000001
+	v6IIDToken                             // {v6iid} 0000000000000001 (lower 64 bits)
+	v6PrefixHashToken                      // {v6prefixhash} 8 hex digit hash of upper 64 bits
+	v4KeyedToken                           // {v4keyed} encrypted ipv4 address
+	v6KeyedToken                           // {v6keyed} encrypted ipv6 address
+)
+
+const forwardToken = "{forward}"
+
+var tokenNames = map[string]templateToken{
+	"{v4dash}":       v4DashToken,
+	"{v6dash}":       v6DashToken,
+	"{v6hex32}":      v6Hex32Token,
+	"{v6iid}":        v6IIDToken,
+	"{v6prefixhash}": v6PrefixHashToken,
+	"{v4keyed}":      v4KeyedToken,
+	"{v6keyed}":      v6KeyedToken,
+}
+
+// width returns the fixed rendered width of a token or zero if it is variable width.
+func (t templateToken) width() int {
+	switch t {
+	case v6Hex32Token:
+		return 32
+	case v6IIDToken:
+		return 16
+	case v6PrefixHashToken:
+		return 8
+	case v4KeyedToken:
+		return v4KeyedWidth
+	case v6KeyedToken:
+		return v6KeyedWidth
+	}
+
+	return 0
+}
+
+type templateElement struct {
+	literal string
+	token   templateToken
+}
+
+// PTRTemplate describes how a synthetic PTR name is constructed from an IP address and,
+// importantly, how that name is parsed back into the same IP address so that forward
+// queries of synthesized names can be answered. This symmetry is what keeps
+// forward-confirmed reverse DNS working regardless of the naming convention chosen.
+//
+// A template is text containing exactly one set of address tokens and ending with
+// ".{forward}", e.g. "ip-{v4dash}.{forward}" or "{v6iid}-{v6prefixhash}.{forward}". The
+// address tokens determine whether the template applies to ipv4 or ipv6 addresses.
+//
+// The {v6prefixhash} token is a one-way hash so it can only be parsed if the prefix has
+// been registered with AddPrefix(). The {v4keyed} and {v6keyed} tokens encrypt the
+// address so they require a key to be set with SetKey() prior to use. Once a template is
+// shared between go-routines neither AddPrefix() nor SetKey() can be called.
+type PTRTemplate struct {
+	text     string
+	ipv4     bool
+	elements []templateElement // Excluding the trailing ".{forward}"
+	prefixes map[uint32]net.IP // Populated by AddPrefix() for {v6prefixhash}
+	cipher   *keyedCipher      // Populated by SetKey() for {v4keyed} and {v6keyed}
+}
+
+// DefaultPTRTemplate4 and DefaultPTRTemplate6 replicate the original hard-coded synthesis
+// format, i.e. 192-0-2-1.$forward and 2001-db8--1.$forward.
+const (
+	DefaultPTRTemplate4 = "{v4dash}." + forwardToken
+	DefaultPTRTemplate6 = "{v6dash}." + forwardToken
+)
+
+// NewPTRTemplate parses and validates the template text. Validation includes a round-trip
+// of sample addresses to ensure that every synthesized name parses back to the original
+// address.
+func NewPTRTemplate(text string) (*PTRTemplate, error) {
+	t := &PTRTemplate{text: text, prefixes: make(map[uint32]net.IP)}
+	s := strings.ToLower(text)
+	if !strings.HasSuffix(s, "."+forwardToken) {
+		return nil, fmt.Errorf("PTR template '%s' must end with '.%s'", text, forwardToken)
+	}
+	s = strings.TrimSuffix(s, "."+forwardToken)
+
+	seen := make(map[templateToken]bool)
+	for len(s) > 0 {
+		open := strings.IndexByte(s, '{')
+		if open == -1 {
+			t.elements = append(t.elements, templateElement{literal: s})
+			break
+		}
+		if open > 0 {
+			t.elements = append(t.elements, templateElement{literal: s[:open]})
+			s = s[open:]
+		}
+		end := strings.IndexByte(s, '}')
+		if end == -1 {
+			return nil, fmt.Errorf("PTR template '%s' has an unterminated '{'", text)
+		}
+		name := s[:end+1]
+		token, ok := tokenNames[name]
+		if !ok {
+			return nil, fmt.Errorf("PTR template '%s' contains unknown token %s", text, name)
+		}
+		if seen[token] {
+			return nil, fmt.Errorf("PTR template '%s' repeats token %s", text, name)
+		}
+		seen[token] = true
+		t.elements = append(t.elements, templateElement{token: token})
+		s = s[end+1:]
+	}
+
+	for _, e := range t.elements {
+		if e.token != literalToken {
+			continue
+		}
+		for _, c := range e.literal {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '.' {
+				return nil, fmt.Errorf("PTR template '%s' contains invalid character '%c'",
+					text, c)
+			}
+		}
+	}
+
+	switch {
+	case len(seen) == 1 && (seen[v4DashToken] || seen[v4KeyedToken]):
+		t.ipv4 = true
+	case len(seen) == 1 && (seen[v6DashToken] || seen[v6Hex32Token] || seen[v6KeyedToken]):
+	case len(seen) == 2 && seen[v6IIDToken] && seen[v6PrefixHashToken]:
+	default:
+		return nil, fmt.Errorf("PTR template '%s' must contain one of {v4dash}, {v4keyed}, {v6dash}, {v6hex32}, {v6keyed} or {v6iid} with {v6prefixhash}", text)
+	}
+
+	err := t.selfTest()
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// selfTest confirms that sample addresses synthesize to legal domain names which then
+// parse back to the same address.
+func (t *PTRTemplate) selfTest() error {
+	samples := []string{"2001:db8::1", "fd2d:ffff:1234:fe::a:b", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"}
+	if t.ipv4 {
+		samples = []string{"192.0.2.1", "0.0.0.0", "255.255.255.255"}
+	}
+	saved := t.prefixes
+	t.prefixes = make(map[uint32]net.IP)
+	defer func() { t.prefixes = saved }()
+	if t.NeedsKey() {
+		savedCipher := t.cipher
+		t.SetKey(make([]byte, 16)) // All zeroes is fine for testing
+		defer func() { t.cipher = savedCipher }()
+	}
+
+	for _, sample := range samples {
+		ip := net.ParseIP(sample)
+		if t.usesToken(v6PrefixHashToken) {
+			_, ipNet, _ := net.ParseCIDR(sample + "/64")
+			t.AddPrefix(ipNet)
+		}
+		name := t.Synthesize(ip, "example.net.")
+		if _, ok := dns.IsDomainName(name); !ok {
+			return fmt.Errorf("PTR template '%s' generates invalid domain name '%s'",
+				t.text, name)
+		}
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return fmt.Errorf("PTR template '%s' generates invalid label in '%s'",
+					t.text, name)
+			}
+		}
+		back := t.Parse(name, "example.net.")
+		if !ip.Equal(back) {
+			return fmt.Errorf("PTR template '%s' does not round-trip '%s' via '%s'",
+				t.text, sample, name)
+		}
+	}
+
+	return nil
+}
+
+// String returns the original template text.
+func (t *PTRTemplate) String() string {
+	return t.text
+}
+
+// IsIPv4 returns true if the template applies to ipv4 addresses, otherwise it applies to
+// ipv6 addresses.
+func (t *PTRTemplate) IsIPv4() bool {
+	return t.ipv4
+}
+
+// NeedsKey returns true if the template contains a token which encrypts the address and
+// thus requires SetKey() to be called.
+func (t *PTRTemplate) NeedsKey() bool {
+	return t.usesToken(v4KeyedToken) || t.usesToken(v6KeyedToken)
+}
+
+// SetKey sets the key used by {v4keyed} and {v6keyed}. The key is normally the result of
+// ParsePTRKey(). Templates which do not need a key ignore it.
+func (t *PTRTemplate) SetKey(key []byte) error {
+	if !t.NeedsKey() {
+		return nil
+	}
+	c, err := newKeyedCipher(key)
+	if err != nil {
+		return err
+	}
+	t.cipher = c
+
+	return nil
+}
+
+func (t *PTRTemplate) usesToken(token templateToken) bool {
+	for _, e := range t.elements {
+		if e.token == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddPrefix registers the /64 prefix of the ipv6 CIDR so that {v6prefixhash} can be
+// parsed back into an address. It is a no-op for templates which do not use
+// {v6prefixhash}. An error is returned if the CIDR is shorter than a /64 - as the hash
+// cannot cover multiple /64s - or if the hash collides with a different prefix.
+func (t *PTRTemplate) AddPrefix(ipNet *net.IPNet) error {
+	if !t.usesToken(v6PrefixHashToken) {
+		return nil
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits != 128 {
+		return nil
+	}
+	if ones < 64 { // Too many /64s to register without their hashes colliding
+		return fmt.Errorf("PTR template '%s' cannot use {v6prefixhash} with %s as the hash "+
+			"can only be parsed for a /64 or longer. Use {v6dash}, {v6hex32} or {v6keyed} instead",
+			t.text, ipNet.String())
+	}
+	prefix := make(net.IP, net.IPv6len)
+	copy(prefix, ipNet.IP.To16()[:8])
+	h := prefixHash(prefix)
+	if existing, ok := t.prefixes[h]; ok && !existing.Equal(prefix) {
+		return fmt.Errorf("PTR template '%s' prefix hash of %s collides with %s",
+			t.text, ipNet.String(), existing.String())
+	}
+	t.prefixes[h] = prefix
+
+	return nil
+}
+
+func prefixHash(ip net.IP) uint32 {
+	h := fnv.New32a()
+	h.Write(ip.To16()[:8])
+
+	return h.Sum32()
+}
+
+// Synthesize generates the name for the IP address. The forward parameter is assumed to
+// be canonical and is appended if it is not empty. It is the caller's responsibility to
+// supply an address of the same family as the template.
+func (t *PTRTemplate) Synthesize(ip net.IP, forward string) string {
+	var sb strings.Builder
+	for _, e := range t.elements {
+		switch e.token {
+		case literalToken:
+			sb.WriteString(e.literal)
+		case v4DashToken:
+			ip4 := ip.To4()
+			fmt.Fprintf(&sb, "%d-%d-%d-%d", ip4[0], ip4[1], ip4[2], ip4[3])
+		case v6DashToken:
+			sb.WriteString(strings.ReplaceAll(ip.String(), ":", "-"))
+		case v6Hex32Token:
+			sb.WriteString(hex.EncodeToString(ip.To16()))
+		case v6IIDToken:
+			sb.WriteString(hex.EncodeToString(ip.To16()[8:]))
+		case v6PrefixHashToken:
+			fmt.Fprintf(&sb, "%08x", prefixHash(ip))
+		case v4KeyedToken:
+			sb.WriteString(t.cipher.encrypt4(ip))
+		case v6KeyedToken:
+			sb.WriteString(t.cipher.encrypt6(ip))
+		}
+	}
+
+	if len(forward) > 0 {
+		sb.WriteString(".")
+		sb.WriteString(forward)
+	}
+
+	return sb.String()
+}
+
+// SynthesizePTR is the template equivalent of the SynthesizePTR function.
+func (t *PTRTemplate) SynthesizePTR(qname, suffix string, ip net.IP) *dns.PTR {
+	ptr := new(dns.PTR)
+	ptr.Hdr.Name = qname
+	ptr.Hdr.Class = dns.ClassINET
+	ptr.Hdr.Rrtype = dns.TypePTR
+	ptr.Ptr = t.Synthesize(ip, suffix)
+
+	return ptr
+}
+
+// Parse is the inverse of Synthesize. It extracts the IP address from a name generated
+// by this template. The name is expected to be lower-case and in-domain of
+// forward. Return nil if the name does not match the template or contains an invalid
+// address.
+func (t *PTRTemplate) Parse(name, forward string) net.IP {
+	s := name
+	if len(forward) > 0 {
+		if !strings.HasSuffix(s, "."+forward) {
+			return nil
+		}
+		s = strings.TrimSuffix(s, "."+forward)
+	}
+
+	values := make(map[templateToken]string)
+	for ix, e := range t.elements {
+		if e.token == literalToken {
+			if !strings.HasPrefix(s, e.literal) {
+				return nil
+			}
+			s = s[len(e.literal):]
+			continue
+		}
+
+		w := e.token.width()
+		if w == 0 { // Variable width tokens are the only token so only literals follow
+			var tail string
+			for _, f := range t.elements[ix+1:] {
+				tail += f.literal
+			}
+			if len(s) <= len(tail) || !strings.HasSuffix(s, tail) {
+				return nil
+			}
+			values[e.token] = s[:len(s)-len(tail)]
+			s = tail
+			continue
+		}
+
+		if len(s) < w {
+			return nil
+		}
+		values[e.token] = s[:w]
+		s = s[w:]
+	}
+	if len(s) > 0 {
+		return nil
+	}
+
+	if v, ok := values[v4KeyedToken]; ok {
+		return t.cipher.decrypt4(v)
+	}
+	if v, ok := values[v6KeyedToken]; ok {
+		return t.cipher.decrypt6(v)
+	}
+	if t.ipv4 {
+		return parseV4Dash(values[v4DashToken])
+	}
+	if v, ok := values[v6DashToken]; ok {
+		return parseV6Dash(v)
+	}
+	if v, ok := values[v6Hex32Token]; ok {
+		return parseHex(v)
+	}
+
+	iid := parseHex(values[v6IIDToken])
+	hash := parseHex(values[v6PrefixHashToken])
+	if iid == nil || hash == nil {
+		return nil
+	}
+	prefix, ok := t.prefixes[uint32(hash[0])<<24|uint32(hash[1])<<16|uint32(hash[2])<<8|uint32(hash[3])]
+	if !ok {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix[:8])
+	copy(ip[8:], iid)
+
+	return ip
+}
+
+// parseV4Dash converts 192-0-2-1 back into an ipv4 address. Unlike ipv6, there is no
+// compression of zero octets which makes parsing simpler.
+func parseV4Dash(s string) net.IP {
+	ar := strings.Split(s, "-")
+	if len(ar) != 4 {
+		return nil
+	}
+	var octets [4]byte
+	for ix, v := range ar {
+		o := convertDecimalOctet(v)
+		if o == -1 {
+			return nil
+		}
+		octets[ix] = byte(o)
+	}
+
+	return net.IPv4(octets[0], octets[1], octets[2], octets[3]).To4()
+}
+
+// parseV6Dash converts 2001-db8--1 back into an ipv6 address.
+func parseV6Dash(s string) net.IP {
+	if strings.ContainsAny(s, ":.") { // Don't allow fd00::1 - should be fd00--1
+		return nil
+	}
+	ip := net.ParseIP(strings.ReplaceAll(s, "-", ":"))
+	if ip == nil || ip.To4() != nil {
+		return nil
+	}
+
+	return ip
+}
+
+// parseHex decodes a fixed-width hex string. A 16 byte result is returned as an ipv6
+// address.
+func parseHex(s string) net.IP {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+
+	return net.IP(b)
+}
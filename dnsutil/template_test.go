@@ -0,0 +1,135 @@
+package dnsutil
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPTRTemplateBad(t *testing.T) {
+	testCases := []struct{ text, contains string }{
+		{"{v4dash}", "must end with"},
+		{"{v4dash}.{forward}.", "must end with"},
+		{"ip-{v4dash.{forward}", "unterminated"},
+		{"ip-{v4dash}-{v4dash}.{forward}", "repeats token"},
+		{"{ipv4}.{forward}", "unknown token"},
+		{"ip_{v4dash}.{forward}", "invalid character"},
+		{"host.{forward}", "must contain one of"},
+		{"{v4dash}-{v6dash}.{forward}", "must contain one of"},
+		{"{v6iid}.{forward}", "must contain one of"},
+		{"{v6prefixhash}.{forward}", "must contain one of"},
+		{".{v4dash}.{forward}", "invalid"},
+		{"{v4dash}..{forward}", "invalid"},
+		{"{v6hex32}{v6hex32x}.{forward}", "unknown token"},
+		{"{v6hex32}-abcdefghijabcdefghijabcdefghijabc.{forward}", "invalid"},
+	}
+
+	for ix, tc := range testCases {
+		_, err := NewPTRTemplate(tc.text)
+		if err == nil {
+			t.Error(ix, tc.text, "Expected error containing", tc.contains)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.contains) {
+			t.Error(ix, tc.text, "Wrong error. Want", tc.contains, "got", err.Error())
+		}
+	}
+}
+
+func TestPTRTemplateRoundTrip(t *testing.T) {
+	testCases := []struct {
+		text   string
+		ipStr  string
+		expect string
+	}{
+		{DefaultPTRTemplate4, "192.0.2.1", "192-0-2-1.example.net."},
+		{DefaultPTRTemplate6, "2001:db8::1", "2001-db8--1.example.net."},
+		{"ip-{v4dash}.{forward}", "192.0.2.254", "ip-192-0-2-254.example.net."},
+		{"IP-{v4dash}.dyn.{forward}", "10.0.0.0", "ip-10-0-0-0.dyn.example.net."},
+		{"{v6hex32}.hosts.{forward}", "2001:db8::27",
+			"20010db8000000000000000000000027.hosts.example.net."},
+		{"{v6iid}-{v6prefixhash}.{forward}", "2001:db8:0:1::a",
+			"000000000000000a-bfeef714.example.net."},
+		{"h{v6prefixhash}{v6iid}.{forward}", "2001:db8:0:1::a",
+			"hbfeef714000000000000000a.example.net."},
+	}
+
+	_, ipNet, _ := net.ParseCIDR("2001:db8:0:1::/64")
+	for ix, tc := range testCases {
+		tmpl, err := NewPTRTemplate(tc.text)
+		if err != nil {
+			t.Fatal(ix, "Unexpected error", err)
+		}
+		err = tmpl.AddPrefix(ipNet)
+		if err != nil {
+			t.Fatal(ix, "Unexpected AddPrefix error", err)
+		}
+		ip := net.ParseIP(tc.ipStr)
+		got := tmpl.Synthesize(ip, "example.net.")
+		if got != tc.expect {
+			t.Error(ix, "Synthesize mismatch. Want", tc.expect, "got", got)
+			continue
+		}
+		back := tmpl.Parse(got, "example.net.")
+		if !ip.Equal(back) {
+			t.Error(ix, "Parse did not round-trip", tc.ipStr, got, back)
+		}
+		if tmpl.IsIPv4() != (ip.To4() != nil) {
+			t.Error(ix, "Wrong address family", tc.text)
+		}
+	}
+}
+
+func TestPTRTemplateParseBad(t *testing.T) {
+	tmpl4, _ := NewPTRTemplate("ip-{v4dash}.{forward}")
+	tmpl6, _ := NewPTRTemplate(DefaultPTRTemplate6)
+	tmplH, _ := NewPTRTemplate("{v6iid}-{v6prefixhash}.{forward}")
+	_, ipNet, _ := net.ParseCIDR("2001:db8:0:1::/64")
+	tmplH.AddPrefix(ipNet)
+
+	testCases := []struct {
+		tmpl *PTRTemplate
+		name string
+	}{
+		{tmpl4, "ip-192-0-2-1.example.org."},              // Wrong forward
+		{tmpl4, "192-0-2-1.example.net."},                 // Missing literal
+		{tmpl4, "ip-192-0-2.example.net."},                // Too few octets
+		{tmpl4, "ip-192-0-2-01.example.net."},             // Leading zero
+		{tmpl4, "ip-192-0-2-256.example.net."},            // Out of range
+		{tmpl4, "ip-192.0.2.1.example.net."},              // Wrong separator
+		{tmpl6, "192-0-2-1.example.net."},                 // ipv4 is not ipv6
+		{tmpl6, "fd00::1.example.net."},                   // Colons not allowed
+		{tmpl6, "2001-db8--g.example.net."},               // Bad hex
+		{tmplH, "000000000000000a-00000000.example.net."}, // Unknown hash
+		{tmplH, "00000000000000a-bfeef714.example.net."},  // Short iid
+		{tmplH, "000000000000000x-bfeef714.example.net."}, // Bad hex
+	}
+
+	for ix, tc := range testCases {
+		ip := tc.tmpl.Parse(tc.name, "example.net.")
+		if ip != nil {
+			t.Error(ix, "Expected nil parse of", tc.name, "got", ip)
+		}
+	}
+}
+
+func TestPTRTemplateAddPrefix(t *testing.T) {
+	tmpl, _ := NewPTRTemplate("{v6iid}-{v6prefixhash}.{forward}")
+	_, ipNet, _ := net.ParseCIDR("2001:db8::/48")
+	err := tmpl.AddPrefix(ipNet)
+	if err == nil || !strings.Contains(err.Error(), "/64 or longer") {
+		t.Error("Expected /64 complaint, not", err)
+	}
+	_, ipNet, _ = net.ParseCIDR("2001:db8::/96")
+	err = tmpl.AddPrefix(ipNet)
+	if err != nil {
+		t.Error("Unexpected", err)
+	}
+
+	tmpl, _ = NewPTRTemplate(DefaultPTRTemplate6) // No-op for non-hash templates
+	_, ipNet, _ = net.ParseCIDR("2001:db8::/48")
+	err = tmpl.AddPrefix(ipNet)
+	if err != nil {
+		t.Error("Unexpected", err)
+	}
+}
@@ -0,0 +1,261 @@
+package dnsutil
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"unicode"
+
+	"github.com/miekg/dns"
+)
+
+// TSIGKey is a named TSIG secret as used by miekg, thus Name is canonical, Algorithm is
+// a miekg algorithm name such as dns.HmacSHA256 and Secret is base64 encoded.
+type TSIGKey struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":    dns.HmacMD5,
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// TSIGAlgorithm converts a BIND-style algorithm name such as "hmac-sha256" into the miekg
+// equivalent. Miekg names are also accepted. An empty string is returned if the algorithm
+// is not known.
+func TSIGAlgorithm(name string) string {
+	name = strings.ToLower(name)
+	if alg, ok := tsigAlgorithms[name]; ok {
+		return alg
+	}
+	for _, alg := range tsigAlgorithms {
+		if dns.CanonicalName(name) == alg {
+			return alg
+		}
+	}
+
+	return ""
+}
+
+// NewTSIGKey validates and canonicalizes the key components.
+func NewTSIGKey(name, algorithm, secret string) (*TSIGKey, error) {
+	if _, ok := dns.IsDomainName(name); !ok || len(name) == 0 {
+		return nil, fmt.Errorf("Invalid TSIG key name '%s'", name)
+	}
+	alg := TSIGAlgorithm(algorithm)
+	if len(alg) == 0 {
+		return nil, fmt.Errorf("TSIG key %s has unknown algorithm '%s'", name, algorithm)
+	}
+	b, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("TSIG key %s secret is not valid base64", name)
+	}
+
+	return &TSIGKey{Name: dns.CanonicalName(name), Algorithm: alg, Secret: secret}, nil
+}
+
+// ParseTSIGSpec parses a key in the dig -y form of [algorithm:]name:secret. The algorithm
+// defaults to hmac-sha256.
+func ParseTSIGSpec(spec string) (*TSIGKey, error) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 2:
+		return NewTSIGKey(parts[0], "hmac-sha256", parts[1])
+	case 3:
+		return NewTSIGKey(parts[1], parts[0], parts[2])
+	}
+
+	return nil, fmt.Errorf("TSIG key '%s' is not of the form [algorithm:]name:secret", spec)
+}
+
+// TSIGProvider is a dns.TsigProvider for a single key which counts the messages it has
+// verified. Miekg transfers silently accept unsigned messages, so the count is the only
+// way for a client to tell whether a response was signed at all.
+type TSIGProvider struct {
+	key      *TSIGKey
+	verified int
+}
+
+// Provider returns a new TSIGProvider for the key with a zero verified count.
+func (t *TSIGKey) Provider() *TSIGProvider {
+	return &TSIGProvider{key: t}
+}
+
+// Generate meets the dns.TsigProvider interface.
+func (t *TSIGProvider) Generate(msg []byte, tsig *dns.TSIG) ([]byte, error) {
+	if dns.CanonicalName(tsig.Hdr.Name) != t.key.Name {
+		return nil, dns.ErrSecret
+	}
+	if dns.CanonicalName(tsig.Algorithm) != t.key.Algorithm {
+		return nil, dns.ErrKeyAlg
+	}
+	secret, err := base64.StdEncoding.DecodeString(t.key.Secret)
+	if err != nil {
+		return nil, err
+	}
+	var h hash.Hash
+	switch t.key.Algorithm {
+	case dns.HmacMD5:
+		h = hmac.New(md5.New, secret)
+	case dns.HmacSHA1:
+		h = hmac.New(sha1.New, secret)
+	case dns.HmacSHA224:
+		h = hmac.New(sha256.New224, secret)
+	case dns.HmacSHA256:
+		h = hmac.New(sha256.New, secret)
+	case dns.HmacSHA384:
+		h = hmac.New(sha512.New384, secret)
+	case dns.HmacSHA512:
+		h = hmac.New(sha512.New, secret)
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	h.Write(msg)
+
+	return h.Sum(nil), nil
+}
+
+// Verify meets the dns.TsigProvider interface. Successful verifications are counted.
+func (t *TSIGProvider) Verify(msg []byte, tsig *dns.TSIG) error {
+	b, err := t.Generate(msg, tsig)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(tsig.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(b, mac) {
+		return dns.ErrSig
+	}
+	t.verified++
+
+	return nil
+}
+
+// Verified returns the number of messages successfully verified.
+func (t *TSIGProvider) Verified() int {
+	return t.verified
+}
+
+// ParseTSIGKeys parses the BIND key statements generated by tsig-keygen and
+// ddns-confgen, e.g.:
+//
+//	key "xfr.example.net" {
+//		algorithm hmac-sha256;
+//		secret "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE=";
+//	};
+//
+// '#' and '//' comments are ignored. Any other statement is an error.
+func ParseTSIGKeys(text string) ([]*TSIGKey, error) {
+	tokens := tokenizeKeyStatements(text)
+	var keys []*TSIGKey
+	next := func() string {
+		if len(tokens) == 0 {
+			return ""
+		}
+		tok := tokens[0]
+		tokens = tokens[1:]
+		return tok
+	}
+	expect := func(want string) error {
+		if got := next(); got != want {
+			return fmt.Errorf("TSIG key file expected '%s', got '%s'", want, got)
+		}
+		return nil
+	}
+
+	for len(tokens) > 0 {
+		if err := expect("key"); err != nil {
+			return nil, err
+		}
+		name := next()
+		if err := expect("{"); err != nil {
+			return nil, err
+		}
+		var alg, secret string
+		for tok := next(); tok != "}"; tok = next() {
+			switch tok {
+			case "algorithm":
+				alg = next()
+			case "secret":
+				secret = next()
+			case "":
+				return nil, fmt.Errorf("TSIG key %s is missing a closing '}'", name)
+			default:
+				return nil, fmt.Errorf("TSIG key %s has unexpected clause '%s'", name, tok)
+			}
+			if err := expect(";"); err != nil {
+				return nil, err
+			}
+		}
+		if err := expect(";"); err != nil {
+			return nil, err
+		}
+		key, err := NewTSIGKey(name, alg, secret)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// tokenizeKeyStatements splits text into words, quoted strings (less the quotes) and the
+// punctuation characters '{', '}' and ';'.
+func tokenizeKeyStatements(text string) (tokens []string) {
+	for _, line := range strings.Split(text, "\n") {
+		if ix := strings.Index(line, "#"); ix >= 0 {
+			line = line[:ix]
+		}
+		if ix := strings.Index(line, "//"); ix >= 0 {
+			line = line[:ix]
+		}
+		var word strings.Builder
+		flush := func() {
+			if word.Len() > 0 {
+				tokens = append(tokens, word.String())
+				word.Reset()
+			}
+		}
+		inQuote := false
+		for _, r := range line {
+			switch {
+			case r == '"':
+				if inQuote {
+					tokens = append(tokens, word.String())
+					word.Reset()
+				} else {
+					flush()
+				}
+				inQuote = !inQuote
+			case inQuote:
+				word.WriteRune(r)
+			case r == '{' || r == '}' || r == ';':
+				flush()
+				tokens = append(tokens, string(r))
+			case unicode.IsSpace(r):
+				flush()
+			default:
+				word.WriteRune(r)
+			}
+		}
+		flush()
+	}
+
+	return
+}
@@ -0,0 +1,142 @@
+package dnsutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseTSIGKeys(t *testing.T) {
+	const secret = "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE="
+	testCases := []struct {
+		text     string
+		count    int
+		contains string
+	}{
+		{`key "xfr.example.net" {
+	algorithm hmac-sha256;
+	secret "` + secret + `";
+};
+`, 1, ""},
+		{`# Two keys
+key "a" { algorithm hmac-sha512; secret "` + secret + `"; }; // Trailing
+key b { algorithm hmac-sha1; secret "` + secret + `"; };`, 2, ""},
+		{"", 0, ""},
+		{`key "a" { algorithm hmac-sha999; secret "` + secret + `"; };`, 0, "unknown algorithm"},
+		{`key "a" { algorithm hmac-sha256; secret "!!"; };`, 0, "not valid base64"},
+		{`key "a" { algorithm hmac-sha256; secret "` + secret + `"; }`, 0, "expected ';'"},
+		{`key "a" { algorithm hmac-sha256; secret "` + secret + `";`, 0, "missing a closing"},
+		{`key "a" { owner x; };`, 0, "unexpected clause"},
+		{`server 192.0.2.1 { keys { a; }; };`, 0, "expected 'key'"},
+	}
+
+	for ix, tc := range testCases {
+		keys, err := ParseTSIGKeys(tc.text)
+		if err != nil {
+			if len(tc.contains) == 0 || !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		if len(keys) != tc.count {
+			t.Error(ix, "Wrong key count", len(keys))
+		}
+	}
+
+	keys, _ := ParseTSIGKeys(testCases[0].text)
+	k := keys[0]
+	if k.Name != "xfr.example.net." || k.Algorithm != dns.HmacSHA256 || k.Secret != secret {
+		t.Error("Key not canonicalized", k)
+	}
+}
+
+func TestTSIGAlgorithm(t *testing.T) {
+	for in, out := range map[string]string{
+		"hmac-sha256":  dns.HmacSHA256,
+		"HMAC-SHA1":    dns.HmacSHA1,
+		"hmac-sha256.": dns.HmacSHA256,
+		dns.HmacMD5:    dns.HmacMD5,
+		"sha256":       "",
+	} {
+		if got := TSIGAlgorithm(in); got != out {
+			t.Error(in, "expected", out, "got", got)
+		}
+	}
+}
+
+func TestParseTSIGSpec(t *testing.T) {
+	const secret = "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE="
+	testCases := []struct {
+		spec, name, alg, contains string
+	}{
+		{"xfr.example.net:" + secret, "xfr.example.net.", dns.HmacSHA256, ""},
+		{"hmac-sha512:xfr:" + secret, "xfr.", dns.HmacSHA512, ""},
+		{"xfr", "", "", "not of the form"},
+		{"a:b:c:d", "", "", "not of the form"},
+		{"hmac-sha999:xfr:" + secret, "", "", "unknown algorithm"},
+		{"xfr:not*base64", "", "", "not valid base64"},
+	}
+
+	for ix, tc := range testCases {
+		key, err := ParseTSIGSpec(tc.spec)
+		if err != nil {
+			if len(tc.contains) == 0 || !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Unexpected error", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		if key.Name != tc.name || key.Algorithm != tc.alg || key.Secret != secret {
+			t.Error(ix, "Wrong key", key)
+		}
+	}
+}
+
+func TestTSIGProvider(t *testing.T) {
+	key, _ := NewTSIGKey("xfr.example.net", "hmac-sha256", "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE=")
+	other, _ := NewTSIGKey("xfr.example.net", "hmac-sha256", "YmFkc2VjcmV0YmFkc2VjcmV0")
+	wrongName, _ := NewTSIGKey("other.example.net", "hmac-sha256", key.Secret)
+
+	for _, alg := range []string{dns.HmacMD5, dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256,
+		dns.HmacSHA384, dns.HmacSHA512} {
+		k := *key
+		k.Algorithm = alg
+		m := new(dns.Msg)
+		m.SetQuestion("example.net.", dns.TypeSOA)
+		m.SetTsig(k.Name, k.Algorithm, 300, 0)
+		buf, _, err := dns.TsigGenerateWithProvider(m, k.Provider(), "", false)
+		if err != nil {
+			t.Fatal(alg, "Generate failed", err)
+		}
+		p := k.Provider()
+		err = dns.TsigVerifyWithProvider(buf, p, "", false)
+		if err != nil || p.Verified() != 1 {
+			t.Error(alg, "Verify failed", err, p.Verified())
+		}
+	}
+
+	signed := func() []byte { // Verify modifies the message so each needs a fresh copy
+		m := new(dns.Msg)
+		m.SetQuestion("example.net.", dns.TypeSOA)
+		m.SetTsig(key.Name, key.Algorithm, 300, 0)
+		buf, _, _ := dns.TsigGenerateWithProvider(m, key.Provider(), "", false)
+		return buf
+	}
+
+	p := other.Provider()
+	if err := dns.TsigVerifyWithProvider(signed(), p, "", false); err != dns.ErrSig || p.Verified() != 0 {
+		t.Error("Expected ErrSig with wrong secret, got", err, p.Verified())
+	}
+	p = wrongName.Provider()
+	if err := dns.TsigVerifyWithProvider(signed(), p, "", false); err != dns.ErrSecret {
+		t.Error("Expected ErrSecret with wrong key name, got", err)
+	}
+}
@@ -0,0 +1,16 @@
+/*
+Package autoreverse is the autoreverse authoritative DNS server for reverse zones, along
+with their synthetic forward zones.
+
+The server is a package rather than a main program so that it can be embedded with
+additional --PTR-deduce sources without forking. The embedder registers its schemes with
+ptrsource.Register before calling Main, as does the standard command in cmd/autoreverse:
+
+	func main() {
+	    ptrsource.Register("cmdb", newCMDBSource)
+	    autoreverse.Main()
+	}
+
+Main parses os.Args and provides exactly the same options as the standard command.
+*/
+package autoreverse
@@ -0,0 +1,276 @@
+package autoreverse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+const (
+	dockerHost           = "http://docker" // Host is ignored by the unix socket dialer
+	dockerRetryInterval  = time.Second * 5 // Delay between event stream reconnects
+	dockerRequestTimeout = time.Second * 30
+	dockerSettleDelay    = time.Second * 2 // Events within this delay are coalesced
+)
+
+// dockerEventsFilter limits the event stream to those which change container addresses.
+var dockerEventsFilter = `{"type":["container","network"],` +
+	`"event":["start","die","rename","destroy","connect","disconnect"]}`
+
+// dockerSource is the docker:// source which creates PTRs for the addresses of running
+// containers by way of the Docker Engine API on the unix socket named by the URL path,
+// e.g. docker:///var/run/docker.sock?domain=example.net. Container names are qualified
+// with the domain parameter. The events stream is watched so that containers which start
+// or stop trigger a prompt reload, but only if container addresses differ from those of
+// the last load, as a reload affects every --PTR-deduce source.
+type dockerSource struct {
+	socket string
+	domain string
+	client *http.Client
+	settle time.Duration
+
+	mu     sync.Mutex
+	loaded string // fingerprint of the last load
+}
+
+// dockerAddress is a container address along with its qualified name.
+type dockerAddress struct {
+	ip   net.IP
+	name string
+}
+
+type dockerContainer struct {
+	Names           []string
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string
+			GlobalIPv6Address string
+		}
+	}
+}
+
+type dockerEvent struct {
+	Type   string
+	Action string
+	Actor  struct {
+		Attributes map[string]string
+	}
+}
+
+func newDockerSource(u *url.URL) (ptrsource.Source, error) {
+	if len(u.Host) > 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL cannot contain a host or port")
+	}
+	if len(u.Path) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL must contain the Docker socket path")
+	}
+	t := &dockerSource{socket: u.Path, domain: u.Query().Get("domain"), settle: dockerSettleDelay}
+	if len(t.domain) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL must contain a domain parameter")
+	}
+	t.domain = dns.CanonicalName(t.domain)
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", t.socket)
+		},
+	}
+	t.client = &http.Client{Transport: transport}
+
+	return t, nil
+}
+
+// Load lists the running containers and adds a PTR for each of their addresses.
+func (t *dockerSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	addrs, err := t.list()
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		sink.AddAddress(a.ip, a.name, defaultTTL)
+	}
+
+	t.mu.Lock()
+	t.loaded = fingerprintDocker(addrs)
+	t.mu.Unlock()
+
+	return nil
+}
+
+// list returns the sorted addresses of all named running containers.
+func (t *dockerSource) list() ([]dockerAddress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dockerHost+"/containers/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Docker container list failed:%s", resp.Status)
+	}
+
+	var containers []dockerContainer
+	err = json.NewDecoder(resp.Body).Decode(&containers)
+	if err != nil {
+		return nil, fmt.Errorf("Docker container list:%w", err)
+	}
+	var addrs []dockerAddress
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		name := qualifyName(strings.TrimPrefix(c.Names[0], "/"), t.domain)
+		if len(name) == 0 {
+			continue
+		}
+		for _, n := range c.NetworkSettings.Networks {
+			for _, addr := range []string{n.IPAddress, n.GlobalIPv6Address} {
+				if ip := net.ParseIP(addr); ip != nil {
+					addrs = append(addrs, dockerAddress{ip: ip, name: name})
+				}
+			}
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].ip.String() < addrs[j].ip.String() })
+
+	return addrs, nil
+}
+
+func fingerprintDocker(addrs []dockerAddress) string {
+	var sb strings.Builder
+	for _, a := range addrs {
+		sb.WriteString(a.ip.String() + " " + a.name + "\n")
+	}
+
+	return sb.String()
+}
+
+// NeedsReload never requests a reload as changes are learnt from the events stream.
+func (t *dockerSource) NeedsReload(now time.Time) string {
+	return ""
+}
+
+func (t *dockerSource) Describe() string {
+	return "docker " + t.socket
+}
+
+// Watch follows the events stream and passes each container event to settle, which
+// decides whether a reload is needed. If the stream fails it is reconnected after
+// dockerRetryInterval, with a check to catch up on any events missed in the meantime.
+func (t *dockerSource) Watch(done <-chan struct{}, reload func(reason string)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan string, 1)
+	go func() {
+		<-done
+		cancel()
+	}()
+	go t.settleEvents(ctx, events, reload)
+
+	for connects := 0; ; connects++ {
+		if connects > 0 {
+			notifyEvent(events, "docker events reconnect")
+		}
+		err := t.followEvents(ctx, events)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Minorf("Docker events stream %s failed:%s", t.socket, err)
+		select {
+		case <-done:
+			return
+		case <-time.After(dockerRetryInterval):
+		}
+	}
+}
+
+// notifyEvent queues the event reason unless an event is already queued, in which case
+// they are coalesced into the earlier event.
+func notifyEvent(events chan<- string, reason string) {
+	select {
+	case events <- reason:
+	default:
+	}
+}
+
+// settleEvents waits for the events which follow the first event of a burst, such as a
+// container restart, to settle and then lists the containers. A reload is only triggered
+// if the addresses differ from those of the last load, thus events which leave the
+// addresses unchanged do not cause every --PTR-deduce source to be reloaded.
+func (t *dockerSource) settleEvents(ctx context.Context, events <-chan string, reload func(reason string)) {
+	for {
+		var reason string
+		select {
+		case <-ctx.Done():
+			return
+		case reason = <-events:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t.settle):
+		}
+		select {
+		case <-events: // Coalesced into this check
+		default:
+		}
+
+		addrs, err := t.list()
+		if err != nil {
+			log.Minorf("Docker container list %s failed:%s", t.socket, err)
+			continue
+		}
+		t.mu.Lock()
+		changed := fingerprintDocker(addrs) != t.loaded
+		t.mu.Unlock()
+		if changed {
+			reload(reason)
+		}
+	}
+}
+
+// followEvents returns when the events stream ends or fails.
+func (t *dockerSource) followEvents(ctx context.Context, events chan<- string) error {
+	q := url.Values{}
+	q.Set("filters", dockerEventsFilter)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dockerHost+"/events?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev dockerEvent
+		err = dec.Decode(&ev)
+		if err != nil {
+			return err
+		}
+		notifyEvent(events, "docker "+ev.Type+" "+ev.Action+" "+ev.Actor.Attributes["name"])
+	}
+}
@@ -0,0 +1,220 @@
+package autoreverse
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+)
+
+const dockerTestContainers = `[
+ {"Names":["/web"],"NetworkSettings":{"Networks":{
+  "bridge":{"IPAddress":"192.0.2.80","GlobalIPv6Address":"2001:db8::80"},
+  "backend":{"IPAddress":"192.0.2.81","GlobalIPv6Address":""}}}},
+ {"Names":["/db.site-a.example.org"],"NetworkSettings":{"Networks":{
+  "bridge":{"IPAddress":"192.0.2.82"}}}},
+ {"Names":[],"NetworkSettings":{"Networks":{"bridge":{"IPAddress":"192.0.2.83"}}}},
+ {"Names":["/host-net"],"NetworkSettings":{"Networks":{"host":{"IPAddress":""}}}}
+]`
+
+// dockerEngine is a minimal Docker Engine API which serves the container list and sends
+// events on request.
+type dockerEngine struct {
+	events chan string
+	filter string
+
+	mu         sync.Mutex
+	containers string // Replaces dockerTestContainers if set
+}
+
+func (t *dockerEngine) setContainers(containers string) {
+	t.mu.Lock()
+	t.containers = containers
+	t.mu.Unlock()
+}
+
+func (t *dockerEngine) ServeHTTP(wtr http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/containers/json":
+		t.mu.Lock()
+		containers := t.containers
+		t.mu.Unlock()
+		if len(containers) == 0 {
+			containers = dockerTestContainers
+		}
+		wtr.Write([]byte(containers))
+	case "/events":
+		t.filter = req.URL.Query().Get("filters")
+		wtr.WriteHeader(http.StatusOK)
+		wtr.(http.Flusher).Flush()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case ev, ok := <-t.events:
+				if !ok {
+					return // Abruptly end the stream
+				}
+				wtr.Write([]byte(ev + "\n"))
+				wtr.(http.Flusher).Flush()
+			}
+		}
+	default:
+		http.NotFound(wtr, req)
+	}
+}
+
+func startDockerEngine(t *testing.T, de *dockerEngine) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	srv := &http.Server{Handler: de}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	return socket
+}
+
+func TestDockerSourceURL(t *testing.T) {
+	testCases := []struct {
+		url string
+		err string
+	}{
+		{"docker:///var/run/docker.sock?domain=example.net", ""},
+		{"docker://localhost/var/run/docker.sock?domain=example.net", "cannot contain a host"},
+		{"docker://?domain=example.net", "must contain the Docker socket path"},
+		{"docker:///var/run/docker.sock", "must contain a domain parameter"},
+	}
+	for ix, tc := range testCases {
+		pz, err := newPTRZoneFromURL(nil, tc.url)
+		if len(tc.err) == 0 {
+			if err != nil {
+				t.Error(ix, "Unexpected error", err)
+			} else if pz.source.Describe() != "docker /var/run/docker.sock" {
+				t.Error(ix, "Wrong description", pz.source.Describe())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(ix, "Expected", tc.err, "not", err)
+		}
+	}
+}
+
+func TestDockerSourceLoad(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	socket := startDockerEngine(t, &dockerEngine{})
+	ar, pz := loadHTTPZone(t, "docker://"+socket+"?domain=example.net")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	db := ar.dbGetter.Current()
+	if db.Count() != 4 || pz.added != 4 {
+		t.Error("Wrong counts", db.Count(), pz.added, out.String())
+	}
+	for ip, name := range map[string]string{
+		"192.0.2.80":   "web.example.net.",
+		"2001:db8::80": "web.example.net.",
+		"192.0.2.81":   "web.example.net.",
+		"192.0.2.82":   "db.site-a.example.org.",
+	} {
+		ptrs := dbLookupIP(db, ip)
+		if len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != name || ptrs[0].Header().Ttl != 61 {
+			t.Error("Wrong PTR for", ip, ptrs)
+		}
+	}
+	if reason := pz.source.NeedsReload(time.Now().Add(time.Hour * 24 * 365)); len(reason) > 0 {
+		t.Error("docker source should never poll for reloads", reason)
+	}
+}
+
+func TestDockerSourceLoadError(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	ar, _ := loadHTTPZone(t, "docker://"+filepath.Join(t.TempDir(), "none.sock")+"?domain=example.net")
+	if ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Error("Load should fail with a missing socket")
+	}
+}
+
+func TestDockerSourceWatch(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	de := &dockerEngine{events: make(chan string)}
+	socket := startDockerEngine(t, de)
+	ar, pz := loadHTTPZone(t, "docker://"+socket+"?domain=example.net")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	ds := pz.source.(*dockerSource)
+	ds.settle = time.Millisecond * 50
+
+	reasons := make(chan string, 10)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		ds.Watch(done, func(reason string) { reasons <- reason })
+		close(finished)
+	}()
+
+	// Events which do not change any address, such as a restart loop, are ignored
+	for ix := 0; ix < 5; ix++ {
+		de.events <- `{"Type":"container","Action":"die","Actor":{"Attributes":{"name":"web"}}}`
+		de.events <- `{"Type":"container","Action":"start","Actor":{"Attributes":{"name":"web"}}}`
+	}
+	select {
+	case reason := <-reasons:
+		t.Error("Unexpected reload of unchanged addresses", reason)
+	case <-time.After(time.Millisecond * 300):
+	}
+	if !strings.Contains(de.filter, `"container"`) || !strings.Contains(de.filter, `"die"`) {
+		t.Error("Events not filtered", de.filter)
+	}
+
+	// A burst of events which change addresses results in one reload
+	de.setContainers(`[{"Names":["/web"],"NetworkSettings":{"Networks":{
+ "bridge":{"IPAddress":"192.0.2.90"}}}}]`)
+	de.events <- `{"Type":"container","Action":"start","Actor":{"Attributes":{"name":"web"}}}`
+	de.events <- `{"Type":"network","Action":"connect","Actor":{"Attributes":{"name":"bridge"}}}`
+	select {
+	case reason := <-reasons:
+		if reason != "docker container start web" {
+			t.Error("Wrong reason", reason)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Event did not trigger a reload")
+	}
+	select {
+	case reason := <-reasons:
+		t.Error("Burst not coalesced", reason)
+	case <-time.After(time.Millisecond * 300):
+	}
+
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Watch did not return when done closed")
+	}
+	if len(reasons) > 0 {
+		t.Error("Unexpected reload after done", <-reasons)
+	}
+}
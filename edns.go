@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"bytes"
@@ -48,6 +48,11 @@ func (t *request) genOpt() *dns.OPT {
 		opt.SetUDPSize(t.maxSize)
 	}
 
+	if t.dnssecOK {
+		returnOpt = true
+		opt.SetDo()
+	}
+
 	if len(t.nsidOut) > 0 {
 		returnOpt = true
 		e := new(dns.EDNS0_NSID)
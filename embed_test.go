@@ -0,0 +1,76 @@
+package autoreverse_test
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/autoreverse"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// embedSource is a registered source of an embedder. It also implements Watcher purely
+// to stop the server once it's running.
+type embedSource struct{}
+
+func (t *embedSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	sink.AddAddress(net.ParseIP("192.0.2.40"), "db1.example.net.", defaultTTL)
+
+	return nil
+}
+
+func (t *embedSource) NeedsReload(now time.Time) string { return "" }
+func (t *embedSource) Describe() string                 { return "embedded cmdb" }
+
+// Watch interrupts the server until it shuts down as the signal is dropped if Run is not
+// yet waiting for it.
+func (t *embedSource) Watch(done <-chan struct{}, reload func(reason string)) {
+	p, _ := os.FindProcess(os.Getpid())
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p.Signal(os.Interrupt)
+		}
+	}
+}
+
+const embedEnv = "AUTOREVERSE_EMBED_TEST"
+
+// An embedder registers a scheme from outside the package and runs the server with
+// Main. As Main owns the process, it runs in a sub-process of the test binary.
+func TestEmbedderRegister(t *testing.T) {
+	if os.Getenv(embedEnv) == "1" {
+		ptrsource.Register("embed-cmdb", func(u *url.URL) (ptrsource.Source, error) {
+			return &embedSource{}, nil
+		})
+		os.Args = []string{"autoreverse", "--listen", "127.0.0.1:0",
+			"--local-forward", "example.net", "--local-reverse", "192.0.2.0/24",
+			"--PTR-deduce", "embed-cmdb://cmdb.example.net/site-a", "--log-minor"}
+		autoreverse.Main()
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("Interrupt signal is not supported on Windows")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestEmbedderRegister$")
+	cmd.Env = append(os.Environ(), embedEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatal("Embedded Main failed", err, string(out))
+	}
+	for _, expect := range []string{"Loaded: embedded cmdb Lines=0 Deduced PTRs=1", "Exiting after"} {
+		if !strings.Contains(string(out), expect) {
+			t.Error("Output does not contain", expect, "\n", string(out))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package autoreverse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+const (
+	defaultExecTimeout = time.Second * 30
+	execWaitDelay      = time.Second * 2 // Bounds Wait after the command is killed
+	defaultExecRefresh = time.Hour       // Reload interval when there is neither interval nor SOA
+)
+
+// execSource is the exec:// source which runs the command named by the URL path and loads
+// its stdout as a zone. It is reloaded each interval or, absent an interval parameter,
+// each SOA Refresh. Output without an SOA would otherwise have the command re-run on
+// every reload check, so defaultExecRefresh applies.
+type execSource struct {
+	soaRefresh
+	path     string
+	args     []string      // Command arguments
+	timeout  time.Duration // Command is killed if it runs longer than this
+	interval time.Duration // Reload interval, zero means use the SOA Refresh
+}
+
+// newExecSource extracts the optional arg, timeout and interval query parameters from an
+// exec:// URL. The arg parameter is repeated for each command argument.
+func newExecSource(u *url.URL) (ptrsource.Source, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	t := &execSource{path: path, args: q["arg"], timeout: defaultExecTimeout}
+	if v := q.Get("timeout"); len(v) > 0 {
+		t.timeout, err = time.ParseDuration(v)
+		if err != nil || t.timeout <= 0 {
+			return nil, fmt.Errorf(u.Scheme+" URL timeout %s is not a positive duration", v)
+		}
+	}
+	if v := q.Get("interval"); len(v) > 0 {
+		t.interval, err = time.ParseDuration(v)
+		if err != nil || t.interval <= 0 {
+			return nil, fmt.Errorf(u.Scheme+" URL interval %s is not a positive duration", v)
+		}
+	}
+
+	return t, nil
+}
+
+func (t *execSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	return t.loadFromExec(t.sink(sink), defaultTTL)
+}
+
+func (t *execSource) NeedsReload(now time.Time) string {
+	interval := t.interval
+	if interval == 0 {
+		interval = time.Second * time.Duration(t.soa.Refresh)
+	}
+	if interval == 0 {
+		interval = defaultExecRefresh
+	}
+	if now.After(t.loadTime.Add(interval)) {
+		return "Expired interval"
+	}
+	return ""
+}
+
+func (t *execSource) Describe() string { return t.path }
+
+// loadFromExec runs the command and parses its stdout as a zone to populate the PTR
+// database with deduced and actual PTRs. The load fails if the command exits with a
+// non-zero status or fails to complete within the timeout, in which case any stderr
+// output is included in the error.
+//
+// WaitDelay is set as a command which leaves a background child holding stdout open
+// would otherwise cause Wait to block long after the command itself is killed.
+func (t *execSource) loadFromExec(sink ptrsource.Sink, defaultTTL uint32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.path, t.args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = execWaitDelay
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s", t.path, t.timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return fmt.Errorf("%s %w:%s", t.path, err, msg)
+		}
+		return fmt.Errorf("%s %w", t.path, err)
+	}
+
+	parser := dns.NewZoneParser(&stdout, "", t.path)
+	parser.SetIncludeAllowed(false)
+	parser.SetDefaultTTL(defaultTTL) // ZoneParser needs this in case $TTL is absent
+
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		sink.AddRR(rr)
+	}
+
+	return parser.Err() // Check for parser errors
+}
@@ -0,0 +1,140 @@
+package autoreverse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+
+	return path
+}
+
+func TestLoadFromExec(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	dir := t.TempDir()
+	good := writeScript(t, dir, "good", "cat <<'EOF'\n"+httpTestZone+"EOF\n")
+	args := writeScript(t, dir, "args",
+		`echo "\$ORIGIN $1"`+"\n"+`echo "$2 IN PTR $3"`+"\n")
+	fails := writeScript(t, dir, "fails", "echo partial\necho 'no such table' >&2\nexit 3\n")
+	slow := writeScript(t, dir, "slow", "exec sleep 5\n")
+	bad := writeScript(t, dir, "bad", "echo 'not a zone'\n")
+
+	testCases := []struct {
+		url      string
+		count    int
+		contains string // Expected in the load failure warning
+	}{
+		{"exec://" + good, 2, ""},
+		{"exec://" + args + "?arg=8.b.d.0.1.0.0.2.ip6.arpa." +
+			"&arg=1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0&arg=a.example.net.", 1, ""},
+		{"exec://" + fails, 0, "exit status 3:no such table"},
+		{"exec://" + slow + "?timeout=200ms", 0, "timed out after 200ms"},
+		{"exec://" + bad, 0, "bad owner name"},
+		{"exec://" + filepath.Join(dir, "missing"), 0, "no such file"},
+	}
+
+	for ix, tc := range testCases {
+		ar, _ := loadHTTPZone(t, tc.url)
+		out.Reset()
+		loaded := ar.loadAllZones(ar.cfg.PTRZones, "test")
+		if loaded != (len(tc.contains) == 0) {
+			t.Error(ix, "Wrong load result", loaded, out.String())
+			continue
+		}
+		if !loaded {
+			if !strings.Contains(out.String(), tc.contains) ||
+				!strings.Contains(out.String(), "Abandoned") {
+				t.Error(ix, "Expected", tc.contains, "Got", out.String())
+			}
+			continue
+		}
+		if ar.dbGetter.Current().Count() != tc.count {
+			t.Error(ix, "Expected", tc.count, "PTRs, got", ar.dbGetter.Current().Count())
+		}
+	}
+}
+
+// A background child which holds stdout open must not defeat the timeout.
+func TestLoadFromExecOrphan(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	orphan := writeScript(t, t.TempDir(), "orphan", "sleep 30 &\nexec sleep 30\n")
+	ar, _ := loadHTTPZone(t, "exec://"+orphan+"?timeout=200ms")
+	start := time.Now()
+	if ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load should time out", out.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*10 {
+		t.Error("Timeout defeated by background child", elapsed)
+	}
+	if !strings.Contains(out.String(), "timed out after 200ms") {
+		t.Error("Expected timeout, got", out.String())
+	}
+}
+
+func TestExecReload(t *testing.T) {
+	dir := t.TempDir()
+	good := writeScript(t, dir, "good", "cat <<'EOF'\n"+httpTestZone+"EOF\n")
+	now := time.Now()
+
+	ar, pz := loadHTTPZone(t, "exec://"+good+"?interval=5m")
+	pz.source.(*execSource).loadTime = now
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, now.Add(time.Minute)); len(trigger) > 0 {
+		t.Error("Unexpected interval trigger", trigger)
+	}
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, now.Add(6*time.Minute)); trigger != pz.url {
+		t.Error("Interval did not trigger reload", trigger)
+	}
+
+	ar, pz = loadHTTPZone(t, "exec://"+good) // Without interval the SOA Refresh applies
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	es := pz.source.(*execSource)
+	if es.soa.Refresh != 3 {
+		t.Fatal("SOA not loaded", es.soa)
+	}
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, es.loadTime.Add(time.Second)); len(trigger) > 0 {
+		t.Error("Unexpected refresh trigger", trigger)
+	}
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, es.loadTime.Add(time.Minute)); trigger != pz.url {
+		t.Error("Refresh did not trigger reload", trigger)
+	}
+
+	// Without interval or SOA the command must not be re-run on every check
+	noSOA := writeScript(t, dir, "nosoa", "echo '1.2.0.192.in-addr.arpa. IN PTR a.example.net.'\n")
+	ar, pz = loadHTTPZone(t, "exec://"+noSOA)
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	es = pz.source.(*execSource)
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, es.loadTime.Add(time.Minute)); len(trigger) > 0 {
+		t.Error("Unexpected trigger without SOA", trigger)
+	}
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, es.loadTime.Add(defaultExecRefresh+time.Second)); trigger != pz.url {
+		t.Error("Default refresh did not trigger reload", trigger)
+	}
+
+	for _, u := range []string{"exec:///bin/true?timeout=0s", "exec:///bin/true?interval=x",
+		"exec://host/bin/true"} {
+		_, err := newPTRZoneFromURL(resolver.NewResolver(), u)
+		if err == nil {
+			t.Error("Expected error from", u)
+		}
+	}
+}
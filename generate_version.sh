@@ -21,7 +21,7 @@ set -- $recent
 version=$2
 date=$4
 
-printf 'package main\n\nconst (\n'
+printf 'package autoreverse\n\nconst (\n'
 printf '\t// Version is auto-generated from ChangeLog.md\n'
 printf '\tVersion = "%s"\n' "${version}"
 printf '\t// ReleaseDate is also auto-generated from ChangeLog.md\n'
@@ -0,0 +1,86 @@
+package autoreverse
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// globMeta are the filepath.Match characters which make a file:// path a pattern. A '?'
+// only reaches the path when escaped as %3F, as an unescaped '?' starts the URL query.
+const globMeta = "*?["
+
+// loadFromGlob loads all files matching the file:// pattern, in lexical order, and
+// populates the PTR database with deduced and actual PTRs. The matched files and their
+// DTMs are retained so globChanged can detect added, removed and modified files. A
+// pattern which matches no files is not an error as it represents an empty directory.
+func (t *fileSource) loadFromGlob(sink ptrsource.Sink, defaultTTL uint32) error {
+	matches, err := t.globMatches()
+	if err != nil {
+		return err
+	}
+
+	t.dtm = time.Time{}
+	files := make(map[string]time.Time)
+	for _, path := range matches {
+		dtm, err := parseZoneFile(sink, defaultTTL, path)
+		if err != nil {
+			return err
+		}
+		files[path] = dtm
+		if dtm.After(t.dtm) {
+			t.dtm = dtm
+		}
+	}
+	t.globFiles = files
+
+	return nil
+}
+
+// globChanged returns a reason if the set of files matching the pattern or any of their
+// DTMs has changed since the last load, otherwise it returns an empty string.
+func (t *fileSource) globChanged() string {
+	matches, err := t.globMatches()
+	if err != nil {
+		warning(err, "Could not match zone files:"+t.path)
+		return ""
+	}
+	if len(matches) != len(t.globFiles) {
+		return "Changed file count"
+	}
+	for _, path := range matches {
+		dtm, ok := t.globFiles[path]
+		if !ok {
+			return "New file " + path
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "Removed file " + path
+		}
+		if fi.ModTime().After(dtm) {
+			return "DTM of " + path
+		}
+	}
+
+	return ""
+}
+
+// globMatches returns the regular files which match the pattern. filepath.Glob returns
+// them in lexical order.
+func (t *fileSource) globMatches() ([]string, error) {
+	matches, err := filepath.Glob(t.path)
+	if err != nil {
+		return nil, err
+	}
+	files := matches[:0]
+	for _, path := range matches {
+		fi, err := os.Stat(path)
+		if err == nil && fi.Mode().IsRegular() {
+			files = append(files, path)
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,115 @@
+package autoreverse
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+func TestLoadFromGlob(t *testing.T) {
+	log.SetOut(os.Stdout)
+	log.SetLevel(log.SilentLevel)
+
+	dir := t.TempDir()
+	example, err := os.ReadFile("testdata/loadzones/example.net.zone")
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	ula, err := os.ReadFile("testdata/loadzones/8.b.d.0.1.0.0.2.ip6.arpa.zone")
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	os.WriteFile(filepath.Join(dir, "ula.zone"), ula, 0600)
+	os.WriteFile(filepath.Join(dir, "ignored.txt"), example, 0600)
+	os.Mkdir(filepath.Join(dir, "dir.zone"), 0700) // Directories are skipped
+
+	ar, pz := loadHTTPZone(t, "file://"+dir+"/*.zone")
+	fs := pz.source.(*fileSource)
+	if !fs.isGlob {
+		t.Fatal("Pattern not recognized as a glob", fs.path)
+	}
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Initial load failed")
+	}
+	checkULAPtr(t, pz.url, ar.dbGetter.Current())
+	if len(fs.globFiles) != 1 || fs.dtm.IsZero() {
+		t.Error("Wrong glob state", fs.globFiles, fs.dtm)
+	}
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); len(trigger) > 0 {
+		t.Error("Unexpected trigger of unchanged files", trigger)
+	}
+
+	// Added file
+	added := filepath.Join(dir, "added.zone")
+	os.WriteFile(added, []byte("$ORIGIN 8.b.d.0.1.0.0.2.ip6.arpa.\n"+
+		"3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR added.example.com.\n"), 0600)
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); trigger != pz.url {
+		t.Error("Added file did not trigger reload", trigger)
+	}
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	if ar.dbGetter.Current().Count() != 3 || len(fs.globFiles) != 2 {
+		t.Error("Added file not loaded", ar.dbGetter.Current().Count(), fs.globFiles)
+	}
+
+	// Modified file
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(added, future, future)
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); trigger != pz.url {
+		t.Error("Modified file did not trigger reload", trigger)
+	}
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	if !fs.dtm.Equal(future) {
+		t.Error("DTM is not of the latest file", fs.dtm, future)
+	}
+
+	// Replaced file - same count, different name
+	os.Rename(added, filepath.Join(dir, "renamed.zone"))
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); trigger != pz.url {
+		t.Error("Renamed file did not trigger reload", trigger)
+	}
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+
+	// Removed file
+	os.Remove(filepath.Join(dir, "renamed.zone"))
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); trigger != pz.url {
+		t.Error("Removed file did not trigger reload", trigger)
+	}
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	checkULAPtr(t, pz.url, ar.dbGetter.Current())
+
+	// A bad file fails the load of all files so the last good data is used
+	os.WriteFile(filepath.Join(dir, "bad.zone"), []byte("not a zone\n"), 0600)
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	checkULAPtr(t, pz.url, ar.dbGetter.Current())
+
+	// An escaped '?' is a pattern character whereas an unescaped '?' starts the query
+	ar, pz = loadHTTPZone(t, "file://"+dir+"/ul%3F.zone")
+	fs = pz.source.(*fileSource)
+	if !fs.isGlob || fs.path != dir+"/ul?.zone" {
+		t.Fatal("Escaped ? not recognized as a glob", fs.path)
+	}
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Escaped ? pattern load failed")
+	}
+	checkULAPtr(t, pz.url, ar.dbGetter.Current())
+	_, pz = loadHTTPZone(t, "file://"+dir+"/ula.zone?max-stale=1h")
+	fs = pz.source.(*fileSource)
+	if fs.isGlob || fs.path != dir+"/ula.zone" {
+		t.Error("Query mistaken for a glob", fs.path)
+	}
+
+	// No matches is an empty load
+	ar, _ = loadHTTPZone(t, "file://"+dir+"/*.none")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Error("Expected empty match to load")
+	}
+
+	_, err = newPTRZoneFromURL(resolver.NewResolver(), "file:///zones/[a.zone")
+	if err == nil {
+		t.Error("Expected bad pattern error")
+	}
+}
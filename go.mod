@@ -10,6 +10,7 @@ require (
 	github.com/markdingo/rrl v1.0.0
 	github.com/miekg/dns v1.1.57
 	github.com/spf13/pflag v1.0.6-0.20210604193023-d5e0c0615ace
+	sigs.k8s.io/yaml v1.4.0
 )
 
 require (
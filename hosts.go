@@ -0,0 +1,120 @@
+package autoreverse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// hostsEntry is one line of a hosts(5) file.
+type hostsEntry struct {
+	ip        net.IP
+	canonical string
+	aliases   []string
+}
+
+// hostsSource is the hosts:// source which loads a hosts(5) format file.
+type hostsSource struct {
+	path    string
+	domain  string
+	aliases bool      // Add PTRs for aliases as well as canonical names
+	dtm     time.Time // Of the hosts file when loaded
+}
+
+func newHostsSource(u *url.URL) (ptrsource.Source, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, err
+	}
+	t := &hostsSource{path: path, domain: domainParam(u)}
+	if u.Query().Has("aliases") {
+		t.aliases, err = strconv.ParseBool(u.Query().Get("aliases"))
+		if err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL aliases:%w", err)
+		}
+	}
+
+	return t, nil
+}
+
+func (t *hostsSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	return t.loadFromHosts(sink, defaultTTL)
+}
+
+func (t *hostsSource) NeedsReload(now time.Time) string { return dtmChanged(t.path, t.dtm) }
+func (t *hostsSource) Describe() string                 { return t.path }
+
+// loadFromHosts reads a hosts(5) format file and populates the PTR database with a PTR
+// for the canonical name of each address. If the URL has ?aliases=true, PTRs for the
+// aliases are added too, which results in multiple PTRs for the address.
+func (t *hostsSource) loadFromHosts(sink ptrsource.Sink, defaultTTL uint32) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	t.dtm = fi.ModTime()
+
+	entries, err := parseHosts(f, t.path)
+	if err != nil {
+		return err
+	}
+
+	countLines(sink, len(entries))
+	for _, e := range entries {
+		names := []string{e.canonical}
+		if t.aliases {
+			names = append(names, e.aliases...)
+		}
+		for _, name := range names {
+			name = qualifyName(name, t.domain)
+			if len(name) > 0 {
+				sink.AddAddress(e.ip, name, defaultTTL)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseHosts parses hosts(5) syntax of "address canonical-name [aliases...]" with '#'
+// comments. Any ipv6 zone index, such as "fe80::1%lo0", is ignored.
+func parseHosts(r io.Reader, path string) (entries []hostsEntry, err error) {
+	var lineNo int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if ix := strings.IndexByte(line, '#'); ix >= 0 {
+			line = line[:ix]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d address %s has no hostname", path, lineNo, fields[0])
+		}
+		addr, _, _ := strings.Cut(fields[0], "%")
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("%s:%d invalid address %s", path, lineNo, fields[0])
+		}
+		entries = append(entries, hostsEntry{ip: ip, canonical: fields[1], aliases: fields[2:]})
+	}
+
+	return entries, scanner.Err()
+}
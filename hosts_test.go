@@ -0,0 +1,83 @@
+package autoreverse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/markdingo/autoreverse/database"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+func TestLoadFromHosts(t *testing.T) {
+	testCases := []struct {
+		url   string
+		count int // Database entries
+		oob   int
+		names map[string][]string
+	}{
+		{"hosts:///./testdata/hosts/hosts?domain=example.net", 3, 3,
+			map[string][]string{"192.0.2.20": {"router.example.net."},
+				"192.0.2.21": {"nas.example.net."}, "2001:db8::20": {"router.example.net."}}},
+		{"hosts:///./testdata/hosts/hosts?domain=example.net&aliases=true", 5, 5,
+			map[string][]string{"192.0.2.20": {"router.example.net.", "gw.example.net."},
+				"192.0.2.21":   {"nas.example.net.", "nas-backup.example.net."},
+				"2001:db8::20": {"router.example.net."}}},
+		{"hosts:///./testdata/hosts/hosts", 2, 0,
+			map[string][]string{"192.0.2.20": {"router.example.net."},
+				"2001:db8::20": {"router.example.net."}}},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(&config{TTLAsSecs: 61}, nil)
+		setAuthorities(ar)
+		pz, err := newPTRZoneFromURL(resolver.NewResolver(), tc.url)
+		if err != nil {
+			t.Fatal(ix, "Setup", err)
+		}
+		db := database.NewDatabase()
+		err = pz.source.Load(&zoneSink{pz: pz, db: db, auths: ar.authorities}, ar.cfg.TTLAsSecs)
+		if err != nil {
+			t.Error(ix, "Unexpected error", err)
+			continue
+		}
+		if db.Count() != tc.count || pz.oob != tc.oob || pz.lines != 6 {
+			t.Error(ix, "Wrong counts", db.Count(), pz.oob, pz.lines)
+			db.Dump()
+		}
+		for ip, names := range tc.names {
+			ptrs := dbLookupIP(db, ip)
+			if len(ptrs) != len(names) {
+				t.Error(ix, ip, "Expected", names, "Got", ptrs)
+				continue
+			}
+			for _, name := range names {
+				var found bool
+				for _, ptr := range ptrs {
+					found = found || strings.HasSuffix(ptr.String(), "\t"+name)
+				}
+				if !found {
+					t.Error(ix, ip, "Missing", name, ptrs)
+				}
+			}
+		}
+	}
+
+	_, err := newPTRZoneFromURL(nil, "hosts:///etc/hosts?aliases=maybe")
+	if err == nil || !strings.Contains(err.Error(), "aliases") {
+		t.Error("Expected aliases error, not", err)
+	}
+}
+
+func TestParseHostsErrors(t *testing.T) {
+	testCases := []struct{ text, contains string }{
+		{"192.0.2.1\n", "test:1 address 192.0.2.1 has no hostname"},
+		{"# comment\n192.0.2 host\n", "test:2 invalid address"},
+	}
+
+	for ix, tc := range testCases {
+		_, err := parseHosts(strings.NewReader(tc.text), "test")
+		if err == nil || !strings.Contains(err.Error(), tc.contains) {
+			t.Error(ix, "Expected", tc.contains, "Got", err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package autoreverse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// The http:// and https:// URL query parameters consumed by autoreverse. They are removed
+// from the URL before it is fetched, all other parameters are passed thru to the server.
+const (
+	httpAuthParam    = "auth"     // File containing bearer token or user:password
+	httpCAParam      = "tls-ca"   // PEM CA bundle used instead of the system roots
+	httpCertParam    = "tls-cert" // PEM client certificate
+	httpCertKeyParam = "tls-key"  // PEM client key - defaults to tls-cert
+	httpSigKeyParam  = "sig-key"  // Ed25519 public key which verifies the zone signature
+
+	httpSigSuffix = ".sig" // Appended to the URL path to form the signature URL
+)
+
+var (
+	httpRequestTimeout = time.Second * 30 // Per request. Set here so tests can over-ride
+	httpMaxZoneSize    = int64(256 << 20) // After decompression. Set here so tests can over-ride
+)
+
+// httpSource is the http:// and https:// source which fetches the zone from the URL. It
+// is reloaded each SOA Refresh.
+type httpSource struct {
+	soaRefresh
+	url, path string
+	fetchURL  string            // url less the parameters consumed by newHTTPSource()
+	sigURL    string            // fetchURL with httpSigSuffix appended to the path
+	client    *http.Client      // Configured with any TLS parameters
+	auth      string            // Authorization header value, if any
+	sigKey    ed25519.PublicKey // Verifies the zone signature if set
+
+	etag         string // Validators of body for conditional requests
+	lastModified string
+	body         []byte    // Retained zone from the last 200 response
+	dtm          time.Time // Last-Modified of body or when it was fetched
+}
+
+// newHTTPSource extracts the optional credential, TLS and signature parameters from an
+// http:// or https:// URL and creates the client used to fetch the zone. All files are
+// read immediately which means prior to --chroot processing.
+func newHTTPSource(u *url.URL) (ptrsource.Source, error) {
+	t := &httpSource{url: u.String(), path: u.Path}
+	if len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL must contain a host name")
+	}
+	if len(t.path) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL path must contain a zone name")
+	}
+
+	q := u.Query()
+	fetch := *u
+	for _, p := range []string{httpAuthParam, httpCAParam, httpCertParam, httpCertKeyParam,
+		httpSigKeyParam, maxStaleParam} {
+		q.Del(p)
+	}
+	fetch.RawQuery = q.Encode()
+	t.fetchURL = fetch.String()
+	fetch.Path += httpSigSuffix
+	fetch.RawPath = ""
+	t.sigURL = fetch.String()
+
+	q = u.Query()
+	if path := q.Get(httpAuthParam); len(path) > 0 {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL %s:%w", httpAuthParam, err)
+		}
+		creds := strings.TrimSpace(string(b))
+		if len(creds) == 0 {
+			return nil, fmt.Errorf(u.Scheme+" URL %s file %s is empty", httpAuthParam, path)
+		}
+		if strings.Contains(creds, ":") {
+			t.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+		} else {
+			t.auth = "Bearer " + creds
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if path := q.Get(httpCAParam); len(path) > 0 {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL %s:%w", httpCAParam, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf(u.Scheme+" URL %s %s contains no PEM certificates", httpCAParam, path)
+		}
+	}
+	if certFile := q.Get(httpCertParam); len(certFile) > 0 {
+		keyFile := q.Get(httpCertKeyParam)
+		if len(keyFile) == 0 {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL %s:%w", httpCertParam, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if q.Has(httpCertKeyParam) {
+		return nil, fmt.Errorf(u.Scheme+" URL %s requires %s", httpCertKeyParam, httpCertParam)
+	}
+
+	if path := q.Get(httpSigKeyParam); len(path) > 0 {
+		var err error
+		t.sigKey, err = readEd25519PublicKey(path)
+		if err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL %s:%w", httpSigKeyParam, err)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	t.client = &http.Client{Transport: transport}
+
+	return t, nil
+}
+
+func (t *httpSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	return t.loadFromHTTP(t.sink(sink), defaultTTL)
+}
+
+func (t *httpSource) NeedsReload(now time.Time) string { return t.refreshExpired(now) }
+func (t *httpSource) Describe() string                 { return t.path }
+
+// loadFromHTTP fetches the zone and populates the PTR database with deduced and actual
+// PTRs. The zone is retained along with the ETag and Last-Modified validators of the
+// response so that subsequent fetches are conditional. A 304 Not Modified response causes
+// the retained zone to be re-used as each load starts with a fresh database.
+//
+// Compressed responses are transparently decompressed, whether negotiated with
+// Content-Encoding or served as a gzip file. If the zone has a sig-key, a changed zone is
+// only accepted if its detached signature verifies. Each request is limited to
+// httpRequestTimeout so a stalled server cannot block reloads indefinitely.
+func (t *httpSource) loadFromHTTP(sink ptrsource.Sink, defaultTTL uint32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.fetchURL, nil)
+	if err != nil {
+		return err
+	}
+	if t.body != nil {
+		if len(t.etag) > 0 {
+			req.Header.Set("If-None-Match", t.etag)
+		}
+		if len(t.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", t.lastModified)
+		}
+	}
+	if len(t.auth) > 0 {
+		req.Header.Set("Authorization", t.auth)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body := t.body
+	switch {
+	case resp.StatusCode == http.StatusNotModified && t.body != nil:
+		log.Minorf("HTTP %s Not Modified since %s", t.url, t.dtm.Format(http.TimeFormat))
+
+	case resp.StatusCode == http.StatusOK:
+		body, err = readZone(resp.Body)
+		if err != nil {
+			return err
+		}
+		if t.sigKey != nil { // Verify before any RRs reach the database
+			err = t.verifyHTTPSignature(body)
+			if err != nil {
+				return err
+			}
+		}
+		body, err = gunzipIfCompressed(body)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return errors.New(resp.Status)
+	}
+
+	parser := dns.NewZoneParser(bytes.NewReader(body), "", t.url)
+	parser.SetIncludeAllowed(false)
+	parser.SetDefaultTTL(defaultTTL) // ZoneParser needs this in case $TTL is absent
+
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		sink.AddRR(rr)
+	}
+	err = parser.Err() // Check for parser errors
+	if err != nil || resp.StatusCode == http.StatusNotModified {
+		return err
+	}
+
+	// Only retain a zone which parses, otherwise a 304 would perpetuate the bad zone
+	t.body = body
+	t.etag = resp.Header.Get("ETag")
+	t.lastModified = resp.Header.Get("Last-Modified")
+	t.dtm, err = http.ParseTime(t.lastModified)
+	if err != nil {
+		t.dtm = time.Now() // Server did not supply a usable Last-Modified
+	}
+
+	return nil
+}
+
+// verifyHTTPSignature fetches the detached signature of the zone and verifies it with the
+// sig-key. The signature is over the zone as served, i.e. prior to any gzip file
+// decompression, and is either 64 raw bytes or the base64 encoding thereof.
+func (t *httpSource) verifyHTTPSignature(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.sigURL, nil)
+	if err != nil {
+		return err
+	}
+	if len(t.auth) > 0 {
+		req.Header.Set("Authorization", t.auth)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Signature fetch failed:%w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Signature fetch of %s failed:%s", t.sigURL, resp.Status)
+	}
+	sig, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("Signature fetch failed:%w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil || len(sig) != ed25519.SignatureSize {
+			return fmt.Errorf("Signature %s is not a valid Ed25519 signature", t.sigURL)
+		}
+	}
+	if !ed25519.Verify(t.sigKey, body, sig) {
+		return fmt.Errorf("Signature verification of %s failed", t.url)
+	}
+
+	return nil
+}
+
+// readEd25519PublicKey reads a public key in PEM (as generated by "openssl pkey -pubout")
+// or base64 encoded raw form.
+func readEd25519PublicKey(path string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", path, err)
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+		}
+		return key, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s is not a PEM or base64 Ed25519 public key", path)
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
+
+// gunzipIfCompressed decompresses b if it has the gzip magic number. This catches zones
+// served as .gz files as the http package only decompresses Content-Encoding responses.
+func gunzipIfCompressed(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+		return b, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return readZone(zr)
+}
+
+// readZone reads the whole zone, failing if it exceeds httpMaxZoneSize. The limit applies
+// to the decompressed zone so that a small gzip bomb cannot exhaust memory.
+func readZone(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, httpMaxZoneSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > httpMaxZoneSize {
+		return nil, fmt.Errorf("Zone exceeds maximum size of %d bytes", httpMaxZoneSize)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,437 @@
+package autoreverse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+const httpTestZone = `$ORIGIN 8.b.d.0.1.0.0.2.ip6.arpa.
+$TTL 120
+@ IN SOA internal.example.net. hostmaster.example.net. 1636863624 3 3 1209600 480
+1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR images.example.com.
+2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR pluton.example.com.
+`
+
+// zoneHandler serves zone with http.ServeContent so conditional requests are honoured
+// exactly as a regular web server would. Status codes are recorded for inspection.
+type zoneHandler struct {
+	zone     []byte
+	modTime  time.Time
+	auth     string // Required Authorization header, if set
+	statuses []int
+}
+
+func (t *zoneHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(t.auth) > 0 && r.Header.Get("Authorization") != t.auth {
+		t.statuses = append(t.statuses, http.StatusUnauthorized)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	sw.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(t.zone)))
+	http.ServeContent(sw, r, "zone", t.modTime, bytes.NewReader(t.zone))
+	t.statuses = append(t.statuses, sw.status)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (t *statusWriter) WriteHeader(status int) {
+	t.status = status
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func loadHTTPZone(t *testing.T, url string) (*autoReverse, *PTRZone) {
+	t.Helper()
+	ar := newAutoReverse(&config{TTLAsSecs: 61}, nil)
+	setAuthorities(ar)
+	pz, err := newPTRZoneFromURL(resolver.NewResolver(), url)
+	if err != nil {
+		t.Fatal("Setup", url, err)
+	}
+	ar.cfg.PTRZones = append(ar.cfg.PTRZones, pz)
+
+	return ar, pz
+}
+
+func TestLoadHTTPConditional(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	modTime := time.Date(2021, 11, 14, 4, 20, 24, 0, time.UTC)
+	zh := &zoneHandler{zone: []byte(httpTestZone), modTime: modTime}
+	srv := httptest.NewServer(zh)
+	defer srv.Close()
+
+	ar, pz := loadHTTPZone(t, srv.URL+"/zone?version=1")
+	hs := pz.source.(*httpSource)
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Initial load failed", out.String())
+	}
+	if c := ar.dbGetter.Current().Count(); c != 2 {
+		t.Error("Expected 2 PTRs, not", c)
+	}
+	if !hs.dtm.Equal(modTime) {
+		t.Error("DTM not set from Last-Modified", hs.dtm)
+	}
+
+	out.Reset()
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Reload failed", out.String())
+	}
+	if c := ar.dbGetter.Current().Count(); c != 2 {
+		t.Error("Expected 2 PTRs from retained zone, not", c)
+	}
+	if !strings.Contains(out.String(), "Not Modified") {
+		t.Error("Expected Not Modified log", out.String())
+	}
+
+	// A changed zone is fetched in full
+	zh.zone = append(zh.zone, []byte("3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR c.example.com.\n")...)
+	zh.modTime = modTime.Add(time.Hour)
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Changed load failed", out.String())
+	}
+	if c := ar.dbGetter.Current().Count(); c != 3 {
+		t.Error("Expected 3 PTRs from changed zone, not", c)
+	}
+	if !hs.dtm.Equal(zh.modTime) {
+		t.Error("DTM not updated from Last-Modified", hs.dtm)
+	}
+
+	want := []int{http.StatusOK, http.StatusNotModified, http.StatusOK}
+	if len(zh.statuses) != len(want) {
+		t.Fatal("Wrong number of fetches", zh.statuses)
+	}
+	for ix, s := range want {
+		if zh.statuses[ix] != s {
+			t.Error(ix, "Wrong status", zh.statuses[ix], "want", s)
+		}
+	}
+}
+
+func TestLoadHTTPAuthGzip(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(httpTestZone))
+	zw.Close()
+
+	dir := t.TempDir()
+	bearer := filepath.Join(dir, "bearer")
+	basic := filepath.Join(dir, "basic")
+	empty := filepath.Join(dir, "empty")
+	os.WriteFile(bearer, []byte("s3cr3t-token\n"), 0600)
+	os.WriteFile(basic, []byte("zones:pa55word\n"), 0600)
+	os.WriteFile(empty, []byte("\n"), 0600)
+
+	testCases := []struct {
+		auth  string // Required by server
+		param string // Supplied by client
+		good  bool
+	}{
+		{"", "", true},
+		{"Bearer s3cr3t-token", "", false},
+		{"Bearer s3cr3t-token", "?auth=" + bearer, true},
+		{"Basic em9uZXM6cGE1NXdvcmQ=", "?auth=" + basic, true},
+		{"Basic em9uZXM6cGE1NXdvcmQ=", "?auth=" + bearer, false},
+	}
+
+	for ix, tc := range testCases {
+		zh := &zoneHandler{zone: gz.Bytes(), modTime: time.Now(), auth: tc.auth}
+		srv := httptest.NewServer(zh)
+		ar, _ := loadHTTPZone(t, srv.URL+"/zone.gz"+tc.param)
+		good := ar.loadAllZones(ar.cfg.PTRZones, "test")
+		srv.Close()
+		if good != tc.good {
+			t.Error(ix, "Wrong load result", good, out.String())
+			continue
+		}
+		if good && ar.dbGetter.Current().Count() != 2 {
+			t.Error(ix, "Gzip zone not loaded", ar.dbGetter.Current().Count())
+		}
+	}
+
+	for _, u := range []string{"http://127.0.0.1/zone?auth=" + empty,
+		"http://127.0.0.1/zone?auth=" + filepath.Join(dir, "missing"),
+		"http://127.0.0.1/zone?tls-key=" + basic} {
+		_, err := newPTRZoneFromURL(nil, u)
+		if err == nil {
+			t.Error("Expected error from", u)
+		}
+	}
+}
+
+// writeSelfSigned creates a self-signed certificate and key as PEM files usable for TLS
+// client authentication.
+func writeSelfSigned(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "autoreverse client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+
+	return
+}
+
+func TestLoadHTTPTLS(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeSelfSigned(t, dir)
+
+	srv := httptest.NewUnstartedServer(&zoneHandler{zone: []byte(httpTestZone), modTime: time.Now()})
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	srv.TLS.ClientCAs.AddCert(clientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+
+	testCases := []struct {
+		params string
+		good   bool
+	}{
+		{"", false}, // Server certificate is not trusted
+		{"?tls-ca=" + caFile, false},
+		{"?tls-ca=" + caFile + "&tls-cert=" + certFile + "&tls-key=" + keyFile, true},
+	}
+	for ix, tc := range testCases {
+		ar, _ := loadHTTPZone(t, srv.URL+"/zone"+tc.params)
+		good := ar.loadAllZones(ar.cfg.PTRZones, "test")
+		if good != tc.good {
+			t.Error(ix, "Wrong load result", good, out.String())
+		}
+	}
+
+	_, err := newPTRZoneFromURL(nil, srv.URL+"/zone?tls-ca="+keyFile)
+	if err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Error("Expected bad CA error, not", err)
+	}
+}
+
+func TestLoadHTTPSignature(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	dir := t.TempDir()
+	rawKey := filepath.Join(dir, "raw.pub")
+	os.WriteFile(rawKey, []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0600)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	pemKey := filepath.Join(dir, "pem.pub")
+	os.WriteFile(pemKey, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+
+	zone := []byte(httpTestZone)
+	good := ed25519.Sign(priv, zone)
+	bad := ed25519.Sign(priv, append([]byte("; tampered\n"), zone...))
+
+	var sig []byte
+	var sigFetches int
+	mux := http.NewServeMux()
+	zh := &zoneHandler{zone: zone, modTime: time.Now().Add(-time.Hour)}
+	mux.Handle("/zone", zh)
+	mux.HandleFunc("/zone.sig", func(w http.ResponseWriter, r *http.Request) {
+		sigFetches++
+		if sig == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(sig)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	testCases := []struct {
+		key  string
+		sig  []byte
+		good bool
+	}{
+		{rawKey, good, true},
+		{pemKey, []byte(base64.StdEncoding.EncodeToString(good) + "\n"), true},
+		{rawKey, bad, false},
+		{rawKey, nil, false},
+		{rawKey, []byte("not a signature"), false},
+		{"", nil, true}, // Signature is not checked without a key
+	}
+	for ix, tc := range testCases {
+		sig = tc.sig
+		u := srv.URL + "/zone"
+		if len(tc.key) > 0 {
+			u += "?sig-key=" + tc.key
+		}
+		ar, _ := loadHTTPZone(t, u)
+		out.Reset()
+		loaded := ar.loadAllZones(ar.cfg.PTRZones, "test")
+		if loaded != tc.good {
+			t.Error(ix, "Wrong load result", loaded, out.String())
+		}
+		if !loaded && ar.dbGetter.Current().Count() != 0 {
+			t.Error(ix, "Unverified zone reached the database")
+		}
+	}
+
+	// An unchanged zone was verified when fetched so the signature is not re-fetched
+	sig = good
+	ar, _ := loadHTTPZone(t, srv.URL+"/zone?sig-key="+rawKey)
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	sigFetches = 0
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") || sigFetches != 0 {
+		t.Error("Not Modified reload failed or re-fetched signature", sigFetches, out.String())
+	}
+
+	_, err = newPTRZoneFromURL(nil, srv.URL+"/zone?sig-key="+filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("Expected error with missing sig-key")
+	}
+}
+
+// A stalled server must not block the load beyond httpRequestTimeout, whether it's the
+// zone or the signature which stalls.
+func TestLoadHTTPTimeout(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	defer func(d time.Duration) { httpRequestTimeout = d }(httpRequestTimeout)
+	httpRequestTimeout = time.Millisecond * 200
+
+	stall := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush() // Headers arrive but the body never does
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second * 30):
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stalled", stall)
+	mux.Handle("/zone", &zoneHandler{zone: []byte(httpTestZone), modTime: time.Now()})
+	mux.HandleFunc("/zone.sig", stall)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	key := filepath.Join(t.TempDir(), "raw.pub")
+	os.WriteFile(key, []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0600)
+
+	for _, u := range []string{srv.URL + "/stalled", srv.URL + "/zone?sig-key=" + key} {
+		ar, _ := loadHTTPZone(t, u)
+		start := time.Now()
+		if ar.loadAllZones(ar.cfg.PTRZones, "test") {
+			t.Error(u, "Load should fail when stalled")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second*10 {
+			t.Error(u, "Stalled fetch not timed out", elapsed)
+		}
+		if !strings.Contains(out.String(), "deadline exceeded") {
+			t.Error(u, "Expected deadline error, got", out.String())
+		}
+		out.Reset()
+	}
+}
+
+// The size limit applies to the zone as served and to the zone after gzip decompression.
+func TestLoadHTTPMaxZoneSize(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	defer func(s int64) { httpMaxZoneSize = s }(httpMaxZoneSize)
+	httpMaxZoneSize = int64(len(httpTestZone) * 2)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(httpTestZone))
+	zw.Write(make([]byte, httpMaxZoneSize)) // Compresses to a fraction of the limit
+	zw.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/zone", &zoneHandler{zone: []byte(httpTestZone), modTime: time.Now()})
+	mux.Handle("/large", &zoneHandler{zone: []byte(httpTestZone + strings.Repeat(";\n", len(httpTestZone))),
+		modTime: time.Now()})
+	mux.Handle("/bomb.gz", &zoneHandler{zone: gz.Bytes(), modTime: time.Now()})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	testCases := []struct {
+		path string
+		good bool
+	}{
+		{"/zone", true},
+		{"/large", false},
+		{"/bomb.gz", false},
+	}
+	for _, tc := range testCases {
+		ar, _ := loadHTTPZone(t, srv.URL+tc.path)
+		good := ar.loadAllZones(ar.cfg.PTRZones, "test")
+		if good != tc.good {
+			t.Error(tc.path, "Wrong load result", good, out.String())
+		}
+		if !tc.good && !strings.Contains(out.String(), "exceeds maximum size") {
+			t.Error(tc.path, "Expected size error, got", out.String())
+		}
+		out.Reset()
+	}
+}
@@ -0,0 +1,257 @@
+package autoreverse
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// The URL schemes of the supported inventory file formats
+const (
+	csvFormat  = "csv"  // Header line names the columns
+	jsonFormat = "json" // Array of objects
+)
+
+// inventoryMapping names the columns or fields of an inventory file which contain the
+// values of interest. Each name can be changed with the URL query parameter of the same
+// name, e.g. ?name=dns_name. The ttl and tags columns are optional.
+type inventoryMapping struct {
+	format              string
+	ip, name, ttl, tags string
+	filter              map[string]bool // Records must have one of these tags, if set
+}
+
+// inventoryRecord is the format-independent form of an inventory record.
+type inventoryRecord struct {
+	ip, name, ttl string
+	tags          []string
+}
+
+func newInventoryMapping(u *url.URL) *inventoryMapping {
+	q := u.Query()
+	t := &inventoryMapping{format: u.Scheme, ip: "ip", name: "hostname", ttl: "ttl", tags: "tags"}
+	for _, p := range []struct {
+		param string
+		field *string
+	}{{"ip", &t.ip}, {"name", &t.name}, {"ttl", &t.ttl}, {"tags", &t.tags}} {
+		if v := q.Get(p.param); len(v) > 0 {
+			*p.field = v
+		}
+	}
+	for _, tag := range q["tag"] {
+		if t.filter == nil {
+			t.filter = make(map[string]bool)
+		}
+		t.filter[tag] = true
+	}
+
+	return t
+}
+
+// selected returns true if the record passes the tag filter.
+func (t *inventoryMapping) selected(ir *inventoryRecord) bool {
+	if t.filter == nil {
+		return true
+	}
+	for _, tag := range ir.tags {
+		if t.filter[tag] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inventorySource is the csv:// and json:// source which loads an inventory file.
+type inventorySource struct {
+	path    string
+	domain  string
+	mapping *inventoryMapping // Field mapping and tag filter
+	dtm     time.Time         // Of the inventory file when loaded
+}
+
+func newInventorySource(u *url.URL) (ptrsource.Source, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inventorySource{path: path, domain: domainParam(u), mapping: newInventoryMapping(u)}, nil
+}
+
+func (t *inventorySource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	return t.loadFromInventory(sink, defaultTTL)
+}
+
+func (t *inventorySource) NeedsReload(now time.Time) string { return dtmChanged(t.path, t.dtm) }
+func (t *inventorySource) Describe() string                 { return t.path }
+
+// loadFromInventory reads a CSV or JSON inventory file and populates the PTR database
+// with a PTR for each record which passes the tag filter. The record TTL is used if
+// present, otherwise the default TTL applies. Lines counts all records, including those
+// excluded by the tag filter.
+func (t *inventorySource) loadFromInventory(sink ptrsource.Sink, defaultTTL uint32) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	t.dtm = fi.ModTime()
+
+	var records []inventoryRecord
+	switch t.mapping.format {
+	case csvFormat:
+		records, err = t.mapping.parseCSV(f, t.path)
+	case jsonFormat:
+		records, err = t.mapping.parseJSON(f, t.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	countLines(sink, len(records))
+	for ix, ir := range records {
+		if !t.mapping.selected(&ir) {
+			continue
+		}
+		ip := net.ParseIP(ir.ip)
+		if ip == nil {
+			return fmt.Errorf("%s record %d invalid %s '%s'", t.path, ix+1, t.mapping.ip, ir.ip)
+		}
+		ttl := defaultTTL
+		if len(ir.ttl) > 0 {
+			v, err := strconv.ParseUint(ir.ttl, 10, 32)
+			if err != nil {
+				return fmt.Errorf("%s record %d invalid %s '%s'", t.path, ix+1, t.mapping.ttl, ir.ttl)
+			}
+			ttl = uint32(v)
+		}
+		name := qualifyName(ir.name, t.domain)
+		if len(name) > 0 {
+			sink.AddAddress(ip, name, ttl)
+		}
+	}
+
+	return nil
+}
+
+// parseCSV locates the mapped columns with the header line. Multiple tags in the one
+// column are separated by whitespace, commas or semicolons.
+func (t *inventoryMapping) parseCSV(r io.Reader, path string) (records []inventoryRecord, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	columns := make(map[string]int)
+	for ix, name := range header {
+		columns[strings.TrimSpace(name)] = ix
+	}
+	for _, name := range []string{t.ip, t.name} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s header has no %s column", path, name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if ix, ok := columns[name]; ok && ix < len(record) {
+			return strings.TrimSpace(record[ix])
+		}
+		return ""
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+		records = append(records, inventoryRecord{
+			ip:   field(record, t.ip),
+			name: field(record, t.name),
+			ttl:  field(record, t.ttl),
+			tags: splitTags(field(record, t.tags)),
+		})
+	}
+
+	return records, nil
+}
+
+// splitTags splits multiple tags separated by whitespace, commas or semicolons.
+func splitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t'
+	})
+}
+
+// parseJSON expects an array of objects. The ttl field can be a number or a string and
+// the tags field can be an array of strings or a single string of tags separated as per
+// a CSV tags column.
+func (t *inventoryMapping) parseJSON(r io.Reader, path string) (records []inventoryRecord, err error) {
+	var objects []map[string]any
+	err = json.NewDecoder(r).Decode(&objects)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+
+	for ix, obj := range objects {
+		var ir inventoryRecord
+		for _, f := range []struct {
+			name  string
+			value *string
+		}{{t.ip, &ir.ip}, {t.name, &ir.name}, {t.ttl, &ir.ttl}} {
+			switch v := obj[f.name].(type) {
+			case nil:
+			case string:
+				*f.value = v
+			case float64:
+				if v != math.Trunc(v) || v < 0 {
+					return nil, fmt.Errorf("%s record %d %s is not a whole number", path, ix+1, f.name)
+				}
+				*f.value = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return nil, fmt.Errorf("%s record %d %s is not a string or number", path, ix+1, f.name)
+			}
+		}
+		switch v := obj[t.tags].(type) {
+		case nil:
+		case string:
+			ir.tags = splitTags(v)
+		case []any:
+			for _, tag := range v {
+				s, ok := tag.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s record %d %s is not an array of strings", path, ix+1, t.tags)
+				}
+				ir.tags = append(ir.tags, s)
+			}
+		default:
+			return nil, fmt.Errorf("%s record %d %s is not an array of strings", path, ix+1, t.tags)
+		}
+		records = append(records, ir)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,123 @@
+package autoreverse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/markdingo/autoreverse/database"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+func TestLoadFromInventory(t *testing.T) {
+	const jsonMapping = "&ip=address&name=dns_name&tags=labels"
+	all := map[string]uint32{"192.0.2.30": 300, "192.0.2.31": 61, "2001:db8::30": 600}
+	testCases := []struct {
+		url   string
+		ttls  map[string]uint32 // Expected PTR TTL of each address
+		lines int
+		oob   int
+	}{
+		{"csv:///./testdata/inventory/inventory.csv?domain=example.net", all, 5, 1},
+		{"json:///./testdata/inventory/inventory.json?domain=example.net" + jsonMapping, all, 5, 1},
+		{"csv:///./testdata/inventory/inventory.csv?tag=site-a",
+			map[string]uint32{"192.0.2.30": 300, "2001:db8::30": 600}, 5, 1},
+		{"json:///./testdata/inventory/inventory.json?domain=example.net&tag=site-b&tag=prod" +
+			jsonMapping, map[string]uint32{"192.0.2.30": 300, "192.0.2.31": 61}, 5, 0},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(&config{TTLAsSecs: 61}, nil)
+		setAuthorities(ar)
+		pz, err := newPTRZoneFromURL(resolver.NewResolver(), tc.url)
+		if err != nil {
+			t.Fatal(ix, "Setup", err)
+		}
+		db := database.NewDatabase()
+		err = pz.source.Load(&zoneSink{pz: pz, db: db, auths: ar.authorities}, ar.cfg.TTLAsSecs)
+		if err != nil {
+			t.Error(ix, "Unexpected error", err)
+			continue
+		}
+		if db.Count() != len(tc.ttls) || pz.added != len(tc.ttls) || pz.lines != tc.lines ||
+			pz.oob != tc.oob {
+			t.Error(ix, "Wrong counts", db.Count(), pz.added, pz.lines, pz.oob)
+			db.Dump()
+		}
+		for ip, ttl := range tc.ttls {
+			ptrs := dbLookupIP(db, ip)
+			if len(ptrs) != 1 || ptrs[0].Header().Ttl != ttl {
+				t.Error(ix, ip, "Expected TTL", ttl, "Got", ptrs)
+			}
+		}
+		if pz.source.(*inventorySource).dtm.IsZero() {
+			t.Error(ix, "DTM not set")
+		}
+	}
+}
+
+func TestInventoryErrors(t *testing.T) {
+	testCases := []struct {
+		scheme, params, text, contains string
+	}{
+		{"csv", "", "address,hostname\n", "no ip column"},
+		{"csv", "?ip=address&name=dns_name", "address,hostname\n", "no dns_name column"},
+		{"csv", "", "ip,hostname\n192.0.2,a.example.net\n", "record 1 invalid ip '192.0.2'"},
+		{"csv", "", "ip,hostname,ttl\n192.0.2.1,a.example.net,-1\n", "record 1 invalid ttl"},
+		{"csv", "", "ip,hostname\n\"192.0.2.1,a\n", "extraneous or missing"},
+		{"json", "", `{"ip": "192.0.2.1"}`, "cannot unmarshal"},
+		{"json", "", `[{"ip": "192.0.2.1", "ttl": 1.5}]`, "record 1 ttl is not a whole number"},
+		{"json", "", `[{"ip": true}]`, "record 1 ip is not a string or number"},
+		{"json", "", `[{"ip": "192.0.2.1", "tags": [1]}]`, "tags is not an array of strings"},
+		{"json", "", `[{"ip": "192.0.2.1", "tags": {}}]`, "tags is not an array of strings"},
+	}
+
+	dir := t.TempDir()
+	for ix, tc := range testCases {
+		path := filepath.Join(dir, "inventory")
+		os.WriteFile(path, []byte(tc.text), 0600)
+		ar := newAutoReverse(&config{TTLAsSecs: 61}, nil)
+		setAuthorities(ar)
+		pz, err := newPTRZoneFromURL(resolver.NewResolver(), tc.scheme+"://"+path+tc.params)
+		if err != nil {
+			t.Fatal(ix, "Setup", err)
+		}
+		err = pz.source.Load(&zoneSink{pz: pz, db: database.NewDatabase(), auths: ar.authorities}, ar.cfg.TTLAsSecs)
+		if err == nil || !strings.Contains(err.Error(), tc.contains) {
+			t.Error(ix, "Expected", tc.contains, "Got", err)
+		}
+	}
+}
+
+// A JSON tags string is split the same way as a CSV tags column so that the one inventory
+// filters identically in either format.
+func TestInventoryStringTags(t *testing.T) {
+	files := map[string]string{
+		"csv": "ip,hostname,tags\n192.0.2.30,a.example.net,\"site-a, prod\"\n192.0.2.31,b.example.net,site-a;dev\n",
+		"json": `[{"ip": "192.0.2.30", "hostname": "a.example.net", "tags": "site-a, prod"},` +
+			`{"ip": "192.0.2.31", "hostname": "b.example.net", "tags": "site-a;dev"}]`,
+	}
+
+	dir := t.TempDir()
+	for scheme, text := range files {
+		path := filepath.Join(dir, "inventory."+scheme)
+		os.WriteFile(path, []byte(text), 0600)
+		ar := newAutoReverse(&config{TTLAsSecs: 61}, nil)
+		setAuthorities(ar)
+		pz, err := newPTRZoneFromURL(resolver.NewResolver(), scheme+"://"+path+"?tag=prod")
+		if err != nil {
+			t.Fatal(scheme, "Setup", err)
+		}
+		db := database.NewDatabase()
+		err = pz.source.Load(&zoneSink{pz: pz, db: db, auths: ar.authorities}, ar.cfg.TTLAsSecs)
+		if err != nil {
+			t.Error(scheme, "Unexpected error", err)
+			continue
+		}
+		if db.Count() != 1 || len(dbLookupIP(db, "192.0.2.30")) != 1 {
+			t.Error(scheme, "Tags string not split", db.Count())
+			db.Dump()
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package autoreverse
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// ixfrDelta is one RFC1995 difference sequence.
+type ixfrDelta struct {
+	fromSerial, toSerial uint32
+	deleted, added       []dns.RR
+}
+
+// loadFromIXFR is the ixfr:// equivalent of loadFromAXFR. The zone RRs of interest to
+// addRR() are retained between loads so that after the first load only the changes since
+// the last serial need be transferred. If the primary answers the IXFR with a complete
+// zone, as it's allowed to do if it has no history for our serial, the retained RRs are
+// simply replaced. If the IXFR fails for any other reason, an AXFR is attempted instead.
+//
+// Regardless of how the retained RRs are updated, the database is populated from all of
+// them as each load starts with a fresh database.
+func (t *axfrSource) loadFromIXFR(sink ptrsource.Sink) error {
+	var rrs []dns.RR
+	var err error
+	if t.ixfrRRs != nil {
+		req := new(dns.Msg)
+		req.SetIxfr(t.domain, t.ixfrSerial, ".", ".")
+		rrs, err = t.transferIn(req)
+		if err == nil {
+			err = t.applyIXFR(rrs)
+		}
+		if err != nil {
+			log.Minorf("IXFR of %s from serial %d failed, falling back to AXFR:%s",
+				t.domain, t.ixfrSerial, err.Error())
+		}
+	}
+	if t.ixfrRRs == nil || err != nil {
+		req := new(dns.Msg)
+		req.SetAxfr(t.domain)
+		rrs, err = t.transferIn(req)
+		if err != nil {
+			return err
+		}
+		err = t.applyIXFR(rrs)
+		if err != nil {
+			return err
+		}
+	}
+
+	sink.AddRR(rrs[0]) // Transfers always start with the current SOA
+	for _, rr := range t.ixfrRRs {
+		sink.AddRR(rr)
+	}
+
+	return nil
+}
+
+// transferIn fetches the AXFR or IXFR and returns all RRs in the order received.
+func (t *axfrSource) transferIn(req *dns.Msg) (rrs []dns.RR, err error) {
+	channel, provider, err := t.startTransfer(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for env := range channel {
+		if env.Error != nil {
+			err = env.Error // Keep draining so the transfer go-routine exits
+			continue
+		}
+		rrs = append(rrs, env.RR...)
+	}
+
+	return rrs, t.transferError(err, provider)
+}
+
+// applyIXFR updates the retained RRs with the transfer response. Nothing is changed
+// unless the response is valid in its entirety.
+func (t *axfrSource) applyIXFR(rrs []dns.RR) error {
+	soa, full, deltas, err := parseIXFR(rrs, t.ixfrSerial)
+	if err != nil {
+		return err
+	}
+
+	if full != nil || t.ixfrRRs == nil {
+		t.ixfrRRs = make(map[string]dns.RR)
+		for _, rr := range full {
+			if ixfrRetained(rr) {
+				t.ixfrRRs[rrKey(rr)] = rr
+			}
+		}
+		log.Minorf("IXFR %s full zone at serial %d RRs=%d", t.domain, soa.Serial, len(t.ixfrRRs))
+	}
+
+	var deleted, added int
+	for _, d := range deltas {
+		for _, rr := range d.deleted {
+			if ixfrRetained(rr) {
+				delete(t.ixfrRRs, rrKey(rr))
+				deleted++
+			}
+		}
+		for _, rr := range d.added {
+			if ixfrRetained(rr) {
+				t.ixfrRRs[rrKey(rr)] = rr
+				added++
+			}
+		}
+	}
+	if len(deltas) > 0 {
+		log.Minorf("IXFR %s serial %d to %d Deltas=%d Deleted=%d Added=%d",
+			t.domain, t.ixfrSerial, soa.Serial, len(deltas), deleted, added)
+	}
+	t.ixfrSerial = soa.Serial
+
+	return nil
+}
+
+// parseIXFR validates an IXFR (or AXFR) response as per RFC1995 Section 4 and returns
+// the current SOA along with either the complete zone or the difference sequences. A
+// response consisting solely of the SOA means we are up to date, in which case both full
+// and deltas are nil. The full zone excludes the bookending SOAs but is non-nil, even if
+// the zone is otherwise empty.
+func parseIXFR(rrs []dns.RR, fromSerial uint32) (soa *dns.SOA, full []dns.RR, deltas []ixfrDelta, err error) {
+	if len(rrs) == 0 {
+		return nil, nil, nil, errors.New("Empty transfer response")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, nil, nil, errors.New("Transfer response does not start with SOA")
+	}
+	if len(rrs) == 1 {
+		if soa.Serial != fromSerial {
+			return nil, nil, nil, fmt.Errorf("IXFR returned SOA serial %d only", soa.Serial)
+		}
+		return soa, nil, nil, nil
+	}
+	last, ok := rrs[len(rrs)-1].(*dns.SOA)
+	if !ok || last.Serial != soa.Serial {
+		return nil, nil, nil, errors.New("Transfer response does not end with current SOA")
+	}
+
+	body := rrs[1 : len(rrs)-1]
+	if len(body) == 0 || body[0].Header().Rrtype != dns.TypeSOA { // AXFR-form
+		return soa, append([]dns.RR{}, body...), nil, nil
+	}
+
+	// Incremental form consists of sequences of: old SOA, deletions, new SOA, additions
+	var d *ixfrDelta
+	serial := fromSerial
+	for _, rr := range body {
+		s, isSOA := rr.(*dns.SOA)
+		switch {
+		case isSOA && (d == nil || d.added != nil): // Start of sequence
+			if s.Serial != serial {
+				return nil, nil, nil, fmt.Errorf("IXFR sequence starts at %d, not %d",
+					s.Serial, serial)
+			}
+			deltas = append(deltas, ixfrDelta{fromSerial: s.Serial})
+			d = &deltas[len(deltas)-1]
+		case isSOA: // End of deletions
+			d.toSerial = s.Serial
+			d.added = []dns.RR{}
+			serial = s.Serial
+		case d.added != nil:
+			d.added = append(d.added, rr)
+		default:
+			d.deleted = append(d.deleted, rr)
+		}
+	}
+	if d == nil || d.added == nil || serial != soa.Serial {
+		return nil, nil, nil, fmt.Errorf("IXFR sequences end at %d, not %d", serial, soa.Serial)
+	}
+
+	return soa, nil, deltas, nil
+}
+
+// ixfrRetained returns true if the RR is of interest to addRR() and thus worth retaining
+// between incremental loads.
+func ixfrRetained(rr dns.RR) bool {
+	switch rr.Header().Rrtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypePTR, dns.TypeCNAME:
+		return true
+	}
+
+	return false
+}
+
+// rrKey returns a case-insensitive key which identifies the RR regardless of its TTL, as
+// RFC1995 deletions match on owner, type, class and RDATA.
+func rrKey(rr dns.RR) string {
+	rr = dns.Copy(rr)
+	rr.Header().Ttl = 0
+
+	return strings.ToLower(rr.String())
+}
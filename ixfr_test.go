@@ -0,0 +1,185 @@
+package autoreverse
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+func newRRs(ss ...string) (rrs []dns.RR) {
+	for _, s := range ss {
+		rrs = append(rrs, newRR(s))
+	}
+
+	return
+}
+
+const (
+	ixfrSOA1 = "example.net. IN SOA ns. mbox. 1 60 60 60 60"
+	ixfrSOA2 = "example.net. IN SOA ns. mbox. 2 60 60 60 60"
+	ixfrSOA3 = "example.net. IN SOA ns. mbox. 3 60 60 60 60"
+)
+
+func TestParseIXFR(t *testing.T) {
+	testCases := []struct {
+		rrs      []dns.RR
+		from     uint32
+		full     int // -1 means nil
+		deltas   int
+		contains string
+	}{
+		{nil, 1, 0, 0, "Empty"},
+		{newRRs("a.example.net. IN A 192.0.2.1"), 1, 0, 0, "start with SOA"},
+		{newRRs(ixfrSOA1), 1, -1, 0, ""}, // Up to date
+		{newRRs(ixfrSOA2), 1, 0, 0, "SOA serial 2 only"},
+		{newRRs(ixfrSOA2, "a.example.net. IN A 192.0.2.1"), 1, 0, 0, "end with current SOA"},
+		{newRRs(ixfrSOA2, "a.example.net. IN A 192.0.2.1", ixfrSOA1), 1, 0, 0, "end with current SOA"},
+		{newRRs(ixfrSOA2, ixfrSOA2), 1, 0, 0, ""}, // Empty zone
+		{newRRs(ixfrSOA2, "a.example.net. IN A 192.0.2.1", ixfrSOA2), 1, 1, 0, ""},
+		{newRRs(ixfrSOA2, ixfrSOA1, "a.example.net. IN A 192.0.2.1", ixfrSOA2,
+			"b.example.net. IN A 192.0.2.2", ixfrSOA2), 1, -1, 1, ""},
+		{newRRs(ixfrSOA3, ixfrSOA1, ixfrSOA2, "b.example.net. IN A 192.0.2.2",
+			ixfrSOA2, "b.example.net. IN A 192.0.2.2", ixfrSOA3, ixfrSOA3), 1, -1, 2, ""},
+		{newRRs(ixfrSOA2, ixfrSOA1, "a.example.net. IN A 192.0.2.1", ixfrSOA2), 3, 0, 0, "starts at 1"},
+		{newRRs(ixfrSOA3, ixfrSOA1, "a.example.net. IN A 192.0.2.1", ixfrSOA2, ixfrSOA3), 1, 0, 0, "end at 2"},
+		{newRRs(ixfrSOA2, ixfrSOA1, "a.example.net. IN A 192.0.2.1", ixfrSOA2), 1, 0, 0, "end at 1"},
+	}
+
+	for ix, tc := range testCases {
+		soa, full, deltas, err := parseIXFR(tc.rrs, tc.from)
+		if err != nil {
+			if len(tc.contains) == 0 {
+				t.Error(ix, "Unexpected error", err)
+			} else if !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		if soa == nil || soa != tc.rrs[0] {
+			t.Error(ix, "Wrong SOA returned", soa)
+		}
+		if (full == nil) != (tc.full == -1) || (full != nil && len(full) != tc.full) {
+			t.Error(ix, "Wrong full zone", tc.full, full)
+		}
+		if len(deltas) != tc.deltas {
+			t.Error(ix, "Wrong delta count", tc.deltas, deltas)
+		}
+	}
+}
+
+// ixfrPrimary is a minimal primary which answers each AXFR/IXFR with the next canned
+// response.
+type ixfrPrimary struct {
+	mu        sync.Mutex
+	responses [][]dns.RR
+	qTypes    []uint16
+}
+
+func (t *ixfrPrimary) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.qTypes = append(t.qTypes, query.Question[0].Qtype)
+	m := new(dns.Msg)
+	m.SetReply(query)
+	if len(t.responses) == 0 {
+		m.Rcode = dns.RcodeRefused
+	} else {
+		m.Answer = t.responses[0]
+		t.responses = t.responses[1:]
+	}
+	wtr.WriteMsg(m)
+}
+
+func TestLoadFromIXFR(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	const addr = "127.0.0.1:2062"
+	primary := &ixfrPrimary{}
+	started := make(chan struct{})
+	srv := &dns.Server{Addr: addr, Net: "tcp", Handler: primary,
+		NotifyStartedFunc: func() { close(started) }}
+	go srv.ListenAndServe()
+	<-started
+	defer srv.Shutdown()
+
+	a1 := "a.example.net. IN A 192.0.2.1"
+	a2 := "b.example.net. IN A 192.0.2.2"
+	a3 := "c.example.net. IN A 192.0.2.3"
+	primary.responses = [][]dns.RR{
+		newRRs(ixfrSOA1, a1, a2, "example.net. IN NS ns.", ixfrSOA1),                           // Initial AXFR
+		newRRs(ixfrSOA2, ixfrSOA1, "B.example.net. 99 IN A 192.0.2.2", ixfrSOA2, a3, ixfrSOA2), // Delta
+		newRRs(ixfrSOA2),               // Up to date
+		newRRs(ixfrSOA3, a3, ixfrSOA3), // IXFR answered in AXFR-form
+		newRRs(ixfrSOA1),               // Primary serial went backwards so IXFR is unusable...
+		newRRs(ixfrSOA1, a1, ixfrSOA1), // ...and AXFR fallback is used
+	}
+	expect := []struct {
+		qTypes []uint16
+		ptrs   []string
+	}{
+		{[]uint16{dns.TypeAXFR}, []string{"1", "2"}},
+		{[]uint16{dns.TypeIXFR}, []string{"1", "3"}},
+		{[]uint16{dns.TypeIXFR}, []string{"1", "3"}},
+		{[]uint16{dns.TypeIXFR}, []string{"3"}},
+		{[]uint16{dns.TypeIXFR, dns.TypeAXFR}, []string{"1"}},
+	}
+
+	ar := newAutoReverse(&config{TTLAsSecs: 61}, nil)
+	setAuthorities(ar)
+	pz, err := newPTRZoneFromURL(resolver.NewResolver(), "ixfr://127.0.0.1:2062/example.net")
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	pzs := []*PTRZone{pz}
+
+	for ix, exp := range expect {
+		primary.qTypes = nil
+		if !ar.loadAllZones(pzs, "TestLoadFromIXFR") {
+			t.Fatal(ix, "Load failed", out.String())
+		}
+		if len(primary.qTypes) != len(exp.qTypes) {
+			t.Error(ix, "Wrong transfer types", primary.qTypes)
+		} else {
+			for jx, qt := range exp.qTypes {
+				if primary.qTypes[jx] != qt {
+					t.Error(ix, jx, "Wrong transfer type", dns.TypeToString[primary.qTypes[jx]])
+				}
+			}
+		}
+		if pz.added != len(exp.ptrs) {
+			t.Error(ix, "Wrong PTR count", pz.added, "expected", exp.ptrs)
+		}
+		db := ar.dbGetter.Current()
+		for _, h := range exp.ptrs {
+			qName := h + ".2.0.192.in-addr.arpa."
+			rrs, _ := db.LookupRR(dns.ClassINET, dns.TypePTR, qName)
+			if len(rrs) != 1 {
+				t.Error(ix, "PTR missing for", qName)
+			}
+		}
+	}
+	if as := pz.source.(*axfrSource); pz.soa.Serial != 1 || as.ixfrSerial != 1 {
+		t.Error("Serial not tracked", pz.soa.Serial, as.ixfrSerial)
+	}
+
+	// No more responses so both fail and the last good data is used instead
+	out.Reset()
+	if !ar.loadAllZones(pzs, "TestLoadFromIXFR") || !strings.Contains(out.String(), "last good") {
+		t.Error("Expected last good data when primary refuses", out.String())
+	}
+	if len(dbLookupIP(ar.dbGetter.Current(), "192.0.2.1")) != 1 {
+		t.Error("Last good data not loaded", ar.dbGetter.Current().Count())
+	}
+}
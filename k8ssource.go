@@ -0,0 +1,567 @@
+package autoreverse
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"sigs.k8s.io/yaml"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+const (
+	k8sListLimit      = 500 // Objects per list page
+	k8sRequestTimeout = time.Second * 30
+)
+
+// Vars for the benefit of tests
+var (
+	k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount" // In-cluster credentials
+	k8sRetryInterval     = time.Second * 5
+)
+
+// k8sResources are the API resources which contribute addresses.
+var k8sResources = []string{"pods", "services"}
+
+// k8sSource is the k8s:// source which creates PTRs for the addresses of Pods and
+// Services by way of the Kubernetes API server. The URL path names a kubeconfig file,
+// e.g. k8s:///etc/autoreverse/kubeconfig?domain=cluster.example.net, otherwise the
+// in-cluster service account credentials are used, e.g. k8s://?domain=cluster.example.net.
+// Objects are named <name>.<namespace>.<domain>. Pods and Services are watched so that
+// address changes trigger an immediate reload.
+//
+// As Watch runs concurrently with Load, the token and the addresses and versions learnt
+// by Load are protected by mu.
+type k8sSource struct {
+	server    string // Base URL of the API server
+	domain    string
+	namespace string // Limit to this namespace if set
+	tokenFile string // Re-read on each request as in-cluster tokens are rotated
+	client    *http.Client
+	describe  string
+
+	mu        sync.Mutex
+	token     string            // Bearer token, if any
+	addresses map[string]string // "resource/namespace/name" -> addresses from last load
+	versions  map[string]string // resource -> resourceVersion of last list
+}
+
+// k8sObject has the fields of interest from both Pods and Services.
+type k8sObject struct {
+	Metadata struct {
+		Name            string
+		Namespace       string
+		ResourceVersion string
+	}
+	Spec struct {
+		HostNetwork bool     `json:"hostNetwork"`
+		ClusterIPs  []string `json:"clusterIPs"`
+	}
+	Status struct {
+		Phase  string
+		PodIPs []struct {
+			IP string `json:"ip"`
+		} `json:"podIPs"`
+	}
+}
+
+type k8sList struct {
+	Metadata struct {
+		ResourceVersion string
+		Continue        string
+	}
+	Items []k8sObject
+}
+
+type k8sWatchEvent struct {
+	Type   string
+	Object json.RawMessage
+}
+
+type k8sStatus struct {
+	Code    int
+	Message string
+}
+
+// kubeconfig is the subset of the kubeconfig format needed to reach the API server with
+// static credentials. The file is normally YAML but may also be JSON, both of which are
+// decoded via the json tags.
+type kubeconfig struct {
+	CurrentContext string `json:"current-context"`
+	Contexts       []struct {
+		Name    string
+		Context struct {
+			Cluster   string
+			User      string
+			Namespace string
+		}
+	}
+	Clusters []struct {
+		Name    string
+		Cluster struct {
+			Server                   string
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		}
+	}
+	Users []struct {
+		Name string
+		User struct {
+			Token                 string
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         string `json:"client-key-data"`
+			Exec                  any
+		}
+	}
+}
+
+// newK8sSource reads all credentials up front as loads occur after any chroot.
+func newK8sSource(u *url.URL) (ptrsource.Source, error) {
+	if len(u.Host) > 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL cannot contain a host or port")
+	}
+	q := u.Query()
+	t := &k8sSource{domain: q.Get("domain"), namespace: q.Get("namespace"),
+		addresses: make(map[string]string)}
+	if len(t.domain) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL must contain a domain parameter")
+	}
+	t.domain = dns.CanonicalName(t.domain)
+
+	tlsConfig := &tls.Config{}
+	var err error
+	if len(u.Path) > 0 {
+		err = t.fromKubeconfig(u.Path, q.Get("context"), tlsConfig)
+	} else {
+		if q.Has("context") {
+			return nil, fmt.Errorf(u.Scheme + " URL context parameter requires a kubeconfig path")
+		}
+		err = t.fromServiceAccount(tlsConfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", u.Scheme, err)
+	}
+	t.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	t.describe = "k8s " + t.server
+	if len(t.namespace) > 0 {
+		t.describe += " namespace " + t.namespace
+	}
+
+	return t, nil
+}
+
+func (t *k8sSource) fromServiceAccount(tlsConfig *tls.Config) error {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return errors.New("not in-cluster as KUBERNETES_SERVICE_HOST/PORT are not set")
+	}
+	t.server = "https://" + net.JoinHostPort(host, port)
+	t.tokenFile = filepath.Join(k8sServiceAccountDir, "token")
+	token, err := os.ReadFile(t.tokenFile)
+	if err != nil {
+		return err
+	}
+	t.token = strings.TrimSpace(string(token))
+
+	ca, err := os.ReadFile(filepath.Join(k8sServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return err
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return errors.New("no certificates in service account ca.crt")
+	}
+
+	return nil
+}
+
+func (t *k8sSource) fromKubeconfig(path, contextName string, tlsConfig *tls.Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var kc kubeconfig
+	err = yaml.Unmarshal(data, &kc)
+	if err != nil {
+		return fmt.Errorf("%s is not a kubeconfig:%w", path, err)
+	}
+	if len(contextName) == 0 {
+		contextName = kc.CurrentContext
+	}
+	ctxIx := -1
+	for ix, c := range kc.Contexts {
+		if c.Name == contextName {
+			ctxIx = ix
+		}
+	}
+	if ctxIx == -1 {
+		return fmt.Errorf("%s has no context '%s'", path, contextName)
+	}
+	kctx := kc.Contexts[ctxIx].Context
+	if len(t.namespace) == 0 {
+		t.namespace = kctx.Namespace
+	}
+
+	// Relative paths within a kubeconfig are relative to the kubeconfig itself
+	relPath := func(p string) string {
+		if len(p) > 0 && !filepath.IsAbs(p) {
+			return filepath.Join(filepath.Dir(path), p)
+		}
+		return p
+	}
+	// Each credential is either inline base64 data or a file
+	readData := func(b64, file, what string) ([]byte, error) {
+		if len(b64) > 0 {
+			b, err := base64.StdEncoding.DecodeString(b64)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s:%w", path, what, err)
+			}
+			return b, nil
+		}
+		if len(file) > 0 {
+			return os.ReadFile(relPath(file))
+		}
+		return nil, nil
+	}
+
+	found := false
+	for _, c := range kc.Clusters {
+		if c.Name != kctx.Cluster {
+			continue
+		}
+		found = true
+		t.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		ca, err := readData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority,
+			"certificate-authority-data")
+		if err != nil {
+			return err
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return fmt.Errorf("%s cluster %s has no certificate authority certificates",
+					path, c.Name)
+			}
+		}
+	}
+	if !found || len(t.server) == 0 {
+		return fmt.Errorf("%s has no server for cluster '%s'", path, kctx.Cluster)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != kctx.User {
+			continue
+		}
+		if u.User.Exec != nil {
+			return fmt.Errorf("%s user %s exec credential plugins are not supported", path, u.Name)
+		}
+		t.token = u.User.Token
+		if len(u.User.TokenFile) > 0 {
+			t.tokenFile = relPath(u.User.TokenFile)
+			token, err := os.ReadFile(t.tokenFile)
+			if err != nil {
+				return err
+			}
+			t.token = strings.TrimSpace(string(token))
+		}
+		cert, err := readData(u.User.ClientCertificateData, u.User.ClientCertificate,
+			"client-certificate-data")
+		if err != nil {
+			return err
+		}
+		key, err := readData(u.User.ClientKeyData, u.User.ClientKey, "client-key-data")
+		if err != nil {
+			return err
+		}
+		if cert != nil || key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return fmt.Errorf("%s user %s:%w", path, u.Name, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	return nil
+}
+
+// Load lists all Pods and Services and adds a PTR for each of their addresses.
+func (t *k8sSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	addresses := make(map[string]string)
+	versions := make(map[string]string)
+	for _, resource := range k8sResources {
+		rv, err := t.list(resource, func(obj *k8sObject) {
+			ips := obj.addresses(resource)
+			if len(ips) == 0 {
+				return
+			}
+			addresses[obj.key(resource)] = strings.Join(ips, " ")
+			name := qualifyName(obj.Metadata.Name+"."+obj.Metadata.Namespace+"."+t.domain, "")
+			if len(name) == 0 {
+				return
+			}
+			for _, s := range ips {
+				sink.AddAddress(net.ParseIP(s), name, defaultTTL)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		versions[resource] = rv
+	}
+
+	t.mu.Lock()
+	t.addresses = addresses
+	t.versions = versions
+	t.mu.Unlock()
+
+	return nil
+}
+
+// list pages through all objects of the resource and returns the resourceVersion at which
+// the list was taken.
+func (t *k8sSource) list(resource string, fn func(obj *k8sObject)) (string, error) {
+	var cont string
+	for {
+		q := url.Values{}
+		q.Set("limit", fmt.Sprint(k8sListLimit))
+		if len(cont) > 0 {
+			q.Set("continue", cont)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), k8sRequestTimeout)
+		resp, err := t.get(ctx, resource, q)
+		if err != nil {
+			cancel()
+			return "", err
+		}
+		var list k8sList
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			return "", fmt.Errorf("k8s %s list:%w", resource, err)
+		}
+		for ix := range list.Items {
+			fn(&list.Items[ix])
+		}
+		cont = list.Metadata.Continue
+		if len(cont) == 0 {
+			return list.Metadata.ResourceVersion, nil
+		}
+	}
+}
+
+// get issues an authenticated GET for the resource, limited to the namespace if set. The
+// caller must close the response body.
+func (t *k8sSource) get(ctx context.Context, resource string, q url.Values) (*http.Response, error) {
+	path := "/api/v1/" + resource
+	if len(t.namespace) > 0 {
+		path = "/api/v1/namespaces/" + url.PathEscape(t.namespace) + "/" + resource
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.server+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token := t.bearerToken(); len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("k8s %s request failed:%s", resource, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// bearerToken returns the most recent token. If the token file cannot be read, the
+// previous token is used in the hope that it remains valid.
+func (t *k8sSource) bearerToken() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.tokenFile) > 0 {
+		if token, err := os.ReadFile(t.tokenFile); err == nil {
+			t.token = strings.TrimSpace(string(token))
+		}
+	}
+
+	return t.token
+}
+
+// NeedsReload never requests a reload as changes are learnt from the watches.
+func (t *k8sSource) NeedsReload(now time.Time) string {
+	return ""
+}
+
+func (t *k8sSource) Describe() string {
+	return t.describe
+}
+
+// Watch watches each resource from the version of the last load and triggers a reload
+// when the addresses of an object change. Events which do not change addresses, such as
+// Pod status updates, are ignored. API servers routinely end watches, in which case the
+// watch simply resumes from the last version seen. If a watch fails it is restarted after
+// k8sRetryInterval, with a reload to catch up on any events missed in the meantime if the
+// watch could not be established or the server no longer has the history of the version.
+func (t *k8sSource) Watch(done <-chan struct{}, reload func(reason string)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	var wg sync.WaitGroup
+	for _, resource := range k8sResources {
+		wg.Add(1)
+		go func(resource string) {
+			defer wg.Done()
+			t.mu.Lock()
+			rv := t.versions[resource]
+			t.mu.Unlock()
+			for {
+				var resync bool
+				var err error
+				rv, resync, err = t.watchResource(ctx, resource, rv, reload)
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil { // Closed by the server, so resume from rv
+					continue
+				}
+				log.Minorf("k8s %s watch %s failed:%s", resource, t.server, err)
+				select {
+				case <-done:
+					return
+				case <-time.After(k8sRetryInterval):
+				}
+				if resync {
+					reload("k8s " + resource + " watch restart")
+				}
+			}
+		}(resource)
+	}
+	wg.Wait()
+}
+
+// watchResource returns the latest resourceVersion seen when the watch ends or fails. A
+// nil error means the server closed the watch normally. An empty version is returned if
+// the server no longer has the history of the version requested, in which case the next
+// watch starts from the current state. resync is returned true if events may have been
+// missed, that is, the watch could not be established or the version was reset.
+func (t *k8sSource) watchResource(ctx context.Context, resource, rv string,
+	reload func(reason string)) (string, bool, error) {
+	q := url.Values{}
+	q.Set("watch", "1")
+	q.Set("allowWatchBookmarks", "true")
+	if len(rv) > 0 {
+		q.Set("resourceVersion", rv)
+	}
+	resp, err := t.get(ctx, resource, q)
+	if err != nil {
+		return rv, true, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev k8sWatchEvent
+		err = dec.Decode(&ev)
+		if err == io.EOF {
+			return rv, false, nil
+		}
+		if err != nil {
+			return rv, false, err
+		}
+		if ev.Type == "ERROR" {
+			var status k8sStatus
+			json.Unmarshal(ev.Object, &status)
+			if status.Code == http.StatusGone {
+				return "", true, fmt.Errorf("%d %s", status.Code, status.Message)
+			}
+			return rv, false, fmt.Errorf("%d %s", status.Code, status.Message)
+		}
+		var obj k8sObject
+		err = json.Unmarshal(ev.Object, &obj)
+		if err != nil {
+			return rv, false, err
+		}
+		rv = obj.Metadata.ResourceVersion
+		if ev.Type == "BOOKMARK" {
+			continue
+		}
+
+		var ips string
+		if ev.Type != "DELETED" {
+			ips = strings.Join(obj.addresses(resource), " ")
+		}
+		key := obj.key(resource)
+		t.mu.Lock()
+		changed := t.addresses[key] != ips
+		if changed {
+			t.addresses[key] = ips // Avoid repeat triggers before the reload completes
+		}
+		t.mu.Unlock()
+		if changed {
+			reload("k8s " + strings.ToLower(ev.Type) + " " + key)
+		}
+	}
+}
+
+func (t *k8sObject) key(resource string) string {
+	return resource + "/" + t.Metadata.Namespace + "/" + t.Metadata.Name
+}
+
+// addresses returns the sorted addresses which belong to the object. Host network Pods are
+// excluded as their address is that of the node, as are Pods which have finished.
+// Headless Services have a ClusterIP of "None" which fails to parse.
+func (t *k8sObject) addresses(resource string) (ips []string) {
+	var candidates []string
+	switch resource {
+	case "pods":
+		if t.Spec.HostNetwork || t.Status.Phase == "Succeeded" || t.Status.Phase == "Failed" {
+			return nil
+		}
+		for _, p := range t.Status.PodIPs {
+			candidates = append(candidates, p.IP)
+		}
+	case "services":
+		candidates = t.Spec.ClusterIPs
+	}
+	for _, s := range candidates {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip.String())
+		}
+	}
+	sort.Strings(ips)
+
+	return
+}
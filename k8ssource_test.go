@@ -0,0 +1,354 @@
+package autoreverse
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"sigs.k8s.io/yaml"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+)
+
+// The pods list is returned in two pages to exercise continue. Excluded are the host
+// network and finished pods.
+var k8sTestPods = []string{
+	`{"metadata":{"resourceVersion":"100","continue":"page2"},"items":[
+ {"metadata":{"name":"web-0","namespace":"shop"},
+  "status":{"phase":"Running","podIPs":[{"ip":"2001:db8::a"},{"ip":"192.0.2.10"}]}},
+ {"metadata":{"name":"node-agent","namespace":"kube-system"},"spec":{"hostNetwork":true},
+  "status":{"phase":"Running","podIPs":[{"ip":"192.0.2.1"}]}}]}`,
+	`{"metadata":{"resourceVersion":"101"},"items":[
+ {"metadata":{"name":"batch-1","namespace":"shop"},
+  "status":{"phase":"Succeeded","podIPs":[{"ip":"192.0.2.11"}]}},
+ {"metadata":{"name":"elsewhere","namespace":"shop"},
+  "status":{"phase":"Running","podIPs":[{"ip":"198.51.100.10"}]}}]}`,
+}
+
+const k8sTestServices = `{"metadata":{"resourceVersion":"102"},"items":[
+ {"metadata":{"name":"api","namespace":"shop"},"spec":{"clusterIPs":["2001:db8::100"]}},
+ {"metadata":{"name":"headless","namespace":"shop"},"spec":{"clusterIPs":["None"]}}]}`
+
+// k8sAPIServer is a fake API server which serves the test lists and sends pod watch
+// events on request. A "CLOSE" event ends the watch as a server does when it times out.
+type k8sAPIServer struct {
+	token  string
+	events chan string
+
+	mu       sync.Mutex
+	paths    []string
+	versions []string // resourceVersion of each watch request
+}
+
+func (t *k8sAPIServer) ServeHTTP(wtr http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer "+t.token {
+		http.Error(wtr, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	q := req.URL.Query()
+	t.mu.Lock()
+	t.paths = append(t.paths, req.URL.Path)
+	if q.Has("watch") {
+		t.versions = append(t.versions, q.Get("resourceVersion"))
+	}
+	t.mu.Unlock()
+
+	resource := req.URL.Path[strings.LastIndexByte(req.URL.Path, '/')+1:]
+	if q.Has("watch") {
+		wtr.WriteHeader(http.StatusOK)
+		wtr.(http.Flusher).Flush()
+		events := t.events
+		if resource != "pods" {
+			events = nil // Services never change
+		}
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case ev := <-events:
+				if ev == "CLOSE" {
+					return
+				}
+				wtr.Write([]byte(ev + "\n"))
+				wtr.(http.Flusher).Flush()
+			}
+		}
+	}
+
+	switch resource {
+	case "pods":
+		if q.Get("continue") == "page2" {
+			wtr.Write([]byte(k8sTestPods[1]))
+		} else {
+			wtr.Write([]byte(k8sTestPods[0]))
+		}
+	case "services":
+		wtr.Write([]byte(k8sTestServices))
+	default:
+		http.NotFound(wtr, req)
+	}
+}
+
+// writeKubeconfig writes a YAML kubeconfig with a "test" context for the server.
+func writeKubeconfig(t *testing.T, server, token string, user map[string]any) string {
+	t.Helper()
+	if user == nil {
+		user = map[string]any{"token": token}
+	}
+	kc := map[string]any{
+		"current-context": "test",
+		"contexts": []any{
+			map[string]any{"name": "test", "context": map[string]any{"cluster": "c1", "user": "u1"}},
+			map[string]any{"name": "shop", "context": map[string]any{"cluster": "c1", "user": "u1",
+				"namespace": "shop"}},
+		},
+		"clusters": []any{map[string]any{"name": "c1", "cluster": map[string]any{"server": server}}},
+		"users":    []any{map[string]any{"name": "u1", "user": user}},
+	}
+	data, err := yaml.Marshal(kc)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+
+	return path
+}
+
+func TestK8sSourceURL(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	good := writeKubeconfig(t, "https://k8s.example.net:6443/", "secret", nil)
+	plugin := writeKubeconfig(t, "https://k8s.example.net:6443", "",
+		map[string]any{"exec": map[string]any{"command": "aws"}})
+	jsonForm := filepath.Join(t.TempDir(), "kubeconfig.json") // As per kubectl -o json
+	os.WriteFile(jsonForm, []byte(`{"current-context":"test",
+ "contexts":[{"name":"test","context":{"cluster":"c1","user":"u1"}}],
+ "clusters":[{"name":"c1","cluster":{"server":"https://json.example.net"}}],
+ "users":[{"name":"u1","user":{"token":"secret"}}]}`), 0600)
+	notKC := filepath.Join(t.TempDir(), "notkc")
+	os.WriteFile(notKC, []byte("contexts: [unterminated\n"), 0600)
+	testCases := []struct {
+		url      string
+		err      string
+		describe string
+	}{
+		{"k8s://" + good + "?domain=example.net", "", "k8s https://k8s.example.net:6443"},
+		{"k8s://" + good + "?domain=example.net&context=shop", "",
+			"k8s https://k8s.example.net:6443 namespace shop"},
+		{"k8s://" + good + "?domain=example.net&namespace=dev", "",
+			"k8s https://k8s.example.net:6443 namespace dev"},
+		{"k8s://localhost" + good + "?domain=example.net", "cannot contain a host", ""},
+		{"k8s://" + good, "must contain a domain parameter", ""},
+		{"k8s://" + good + "?domain=example.net&context=prod", "has no context 'prod'", ""},
+		{"k8s://" + jsonForm + "?domain=example.net", "", "k8s https://json.example.net"},
+		{"k8s://" + notKC + "?domain=example.net", "is not a kubeconfig", ""},
+		{"k8s://" + plugin + "?domain=example.net", "exec credential plugins are not supported", ""},
+		{"k8s:///nonexistent/kubeconfig?domain=example.net", "no such file", ""},
+		{"k8s://?domain=example.net", "not in-cluster", ""},
+		{"k8s://?domain=example.net&context=shop", "requires a kubeconfig path", ""},
+	}
+	for ix, tc := range testCases {
+		pz, err := newPTRZoneFromURL(nil, tc.url)
+		if len(tc.err) == 0 {
+			if err != nil {
+				t.Error(ix, "Unexpected error", err)
+			} else if pz.source.Describe() != tc.describe {
+				t.Error(ix, "Wrong description", pz.source.Describe())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(ix, "Expected", tc.err, "not", err)
+		}
+	}
+}
+
+func TestK8sSourceLoad(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	api := &k8sAPIServer{token: "secret"}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	ar, pz := loadHTTPZone(t, "k8s://"+writeKubeconfig(t, srv.URL, "secret", nil)+"?domain=example.net")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	db := ar.dbGetter.Current()
+	if db.Count() != 3 || pz.added != 3 || pz.oob != 1 {
+		t.Error("Wrong counts", db.Count(), pz.added, pz.oob, out.String())
+	}
+	for ip, name := range map[string]string{
+		"2001:db8::a":   "web-0.shop.example.net.",
+		"192.0.2.10":    "web-0.shop.example.net.",
+		"2001:db8::100": "api.shop.example.net.",
+	} {
+		ptrs := dbLookupIP(db, ip)
+		if len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != name || ptrs[0].Header().Ttl != 61 {
+			t.Error("Wrong PTR for", ip, ptrs)
+		}
+	}
+	ks := pz.source.(*k8sSource)
+	if ks.versions["pods"] != "101" || ks.versions["services"] != "102" {
+		t.Error("Wrong list versions", ks.versions)
+	}
+
+	// A namespace limits the list requests
+	api.paths = nil
+	ar, _ = loadHTTPZone(t, "k8s://"+writeKubeconfig(t, srv.URL, "secret", nil)+
+		"?domain=example.net&context=shop")
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	if len(api.paths) != 3 || api.paths[0] != "/api/v1/namespaces/shop/pods" ||
+		api.paths[2] != "/api/v1/namespaces/shop/services" {
+		t.Error("Namespace not used", api.paths)
+	}
+
+	// A bad token fails the initial load
+	ar, _ = loadHTTPZone(t, "k8s://"+writeKubeconfig(t, srv.URL, "wrong", nil)+"?domain=example.net")
+	if ar.loadAllZones(ar.cfg.PTRZones, "test") || !strings.Contains(out.String(), "401 Unauthorized") {
+		t.Error("Load should fail with a bad token", out.String())
+	}
+}
+
+func TestK8sSourceInCluster(t *testing.T) {
+	log.SetOut(&mock.IOWriter{})
+	log.SetLevel(log.MinorLevel)
+
+	api := &k8sAPIServer{token: "sa-token"}
+	srv := httptest.NewTLSServer(api)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	saved := k8sServiceAccountDir
+	k8sServiceAccountDir = dir
+	defer func() { k8sServiceAccountDir = saved }()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	os.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0600)
+	os.WriteFile(filepath.Join(dir, "token"), []byte("old-token\n"), 0600)
+	u, _ := url.Parse(srv.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+
+	ar, pz := loadHTTPZone(t, "k8s://?domain=example.net")
+	if pz.source.Describe() != "k8s https://"+u.Host {
+		t.Error("Wrong description", pz.source.Describe())
+	}
+
+	// The rotated token is used
+	os.WriteFile(filepath.Join(dir, "token"), []byte("sa-token\n"), 0600)
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("In-cluster load failed")
+	}
+	if pz.added != 3 {
+		t.Error("Wrong count", pz.added)
+	}
+}
+
+func TestK8sSourceWatch(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	defer func(d time.Duration) { k8sRetryInterval = d }(k8sRetryInterval)
+	k8sRetryInterval = time.Millisecond * 300
+
+	api := &k8sAPIServer{token: "secret", events: make(chan string)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	ar, pz := loadHTTPZone(t, "k8s://"+writeKubeconfig(t, srv.URL, "secret", nil)+"?domain=example.net")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	ks := pz.source.(*k8sSource)
+	out.Reset()
+
+	reasons := make(chan string, 10)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		ks.Watch(done, func(reason string) { reasons <- reason })
+		close(finished)
+	}()
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case reason := <-reasons:
+			if reason != want {
+				t.Error("Wrong reason", reason, "expected", want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("No reload for", want)
+		}
+	}
+
+	// Status changes which keep the same addresses are ignored, as are bookmarks
+	api.events <- `{"type":"MODIFIED","object":{"metadata":{"name":"web-0","namespace":"shop",
+"resourceVersion":"103"},"status":{"phase":"Running","podIPs":[{"ip":"192.0.2.10"},{"ip":"2001:db8::a"}]}}}`
+	api.events <- `{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"104"}}}`
+	api.events <- `{"type":"ADDED","object":{"metadata":{"name":"web-1","namespace":"shop",
+"resourceVersion":"105"},"status":{"phase":"Pending"}}}`
+	api.events <- `{"type":"MODIFIED","object":{"metadata":{"name":"web-1","namespace":"shop",
+"resourceVersion":"106"},"status":{"phase":"Running","podIPs":[{"ip":"192.0.2.12"}]}}}`
+	expect("k8s modified pods/shop/web-1")
+	api.events <- `{"type":"DELETED","object":{"metadata":{"name":"web-0","namespace":"shop",
+"resourceVersion":"107"}}}`
+	expect("k8s deleted pods/shop/web-0")
+
+	// A watch closed by the server resumes from the last version without a reload
+	api.events <- "CLOSE"
+	api.events <- `{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"108"}}}`
+	if strings.Contains(out.String(), "failed") {
+		t.Error("Normal close logged as a failure", out.String())
+	}
+
+	// Expired versions restart the watch from the current state after a catch-up reload
+	api.events <- `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old"}}`
+	select {
+	case reason := <-reasons:
+		t.Error("Unexpected reload before retry", reason)
+	case <-time.After(time.Millisecond * 100):
+	}
+	expect("k8s pods watch restart")
+	api.events <- `{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"109"}}}`
+
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Watch did not return when done closed")
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.versions) != 4 || api.versions[0] == api.versions[1] {
+		t.Fatal("Watches did not start from the list versions", api.versions)
+	}
+	for _, v := range api.versions[:2] {
+		if v != "101" && v != "102" {
+			t.Error("Unexpected watch version", v)
+		}
+	}
+	if api.versions[2] != "107" || api.versions[3] != "" {
+		t.Error("Watches did not resume from the last version or restart", api.versions[2:])
+	}
+	if !strings.Contains(out.String(), "pods watch "+srv.URL+" failed:410 too old") {
+		t.Error("Watch failure not logged", out.String())
+	}
+}
@@ -0,0 +1,316 @@
+package autoreverse
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// The URL schemes of the supported DHCP server lease files
+const (
+	dhcpdFormat   = "dhcpd"   // ISC dhcpd.leases
+	keaFormat     = "kea"     // Kea memfile CSV, either lease4 or lease6
+	dnsmasqFormat = "dnsmasq" // dnsmasq.leases
+)
+
+// dhcpLease is the format-independent subset of a lease of interest to loadFromLeases. A
+// zero ends means the lease never expires.
+type dhcpLease struct {
+	ip     net.IP
+	name   string
+	ends   time.Time
+	active bool
+}
+
+// leaseSource is the dhcpd://, kea:// and dnsmasq:// source which loads the active
+// leases of a DHCP server lease file. It is reloaded when the file changes or when the
+// earliest lease expires.
+type leaseSource struct {
+	path   string
+	domain string
+	format string    // dhcpd, kea or dnsmasq
+	dtm    time.Time // Of the lease file when loaded
+	expiry time.Time // Earliest expiry of the loaded leases, zero if none expire
+}
+
+func newLeaseSource(u *url.URL) (ptrsource.Source, error) {
+	path, err := filePath(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaseSource{path: path, domain: domainParam(u), format: u.Scheme}, nil
+}
+
+func (t *leaseSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	return t.loadFromLeases(sink, defaultTTL, time.Now())
+}
+
+func (t *leaseSource) NeedsReload(now time.Time) string {
+	if reason := dtmChanged(t.path, t.dtm); len(reason) > 0 {
+		return reason
+	}
+	if !t.expiry.IsZero() && now.After(t.expiry) {
+		return "Lease expiry"
+	}
+	return ""
+}
+
+func (t *leaseSource) Describe() string { return t.path }
+
+// loadFromLeases reads a DHCP server lease file and populates the PTR database with the
+// PTRs of active leases which have a hostname and have not expired as of now. The TTL of
+// each PTR is limited to the remaining lease time. expiry is set to the earliest
+// expiry of the loaded leases so that checkForReload can drop the lease once it expires.
+//
+// All supported lease files are append-only journals, at least between server rewrites,
+// so a later lease for an address always replaces an earlier one.
+func (t *leaseSource) loadFromLeases(sink ptrsource.Sink, defaultTTL uint32, now time.Time) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	t.dtm = fi.ModTime()
+
+	var leases []dhcpLease
+	switch t.format {
+	case dhcpdFormat:
+		leases, err = parseDhcpdLeases(f, t.path)
+	case keaFormat:
+		leases, err = parseKeaLeases(f, t.path)
+	case dnsmasqFormat:
+		leases, err = parseDnsmasqLeases(f, t.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]int) // Index of latest lease for each address
+	for ix, l := range leases {
+		latest[l.ip.String()] = ix
+	}
+
+	countLines(sink, len(leases))
+	t.expiry = time.Time{}
+	for ix, l := range leases {
+		if latest[l.ip.String()] != ix || !l.active {
+			continue
+		}
+		name := qualifyName(l.name, t.domain)
+		if len(name) == 0 {
+			continue
+		}
+		ttl := defaultTTL
+		if !l.ends.IsZero() {
+			if !l.ends.After(now) {
+				continue
+			}
+			remaining := uint32(l.ends.Sub(now).Seconds())
+			if remaining < ttl {
+				ttl = remaining
+			}
+			if t.expiry.IsZero() || l.ends.Before(t.expiry) {
+				t.expiry = l.ends
+			}
+		}
+		sink.AddAddress(l.ip, name, ttl)
+	}
+
+	return nil
+}
+
+// parseDhcpdLeases parses the ipv4 lease declarations of an ISC dhcpd.leases file. All
+// other declarations, such as ipv6 ia-na and failover state, are skipped. Lease times
+// are either in the default UTC form or the "db-time-format local" epoch form.
+func parseDhcpdLeases(r io.Reader, path string) (leases []dhcpLease, err error) {
+	var current *dhcpLease
+	var depth, lineNo int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if ix := strings.IndexByte(line, '#'); ix >= 0 {
+			line = line[:ix]
+		}
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), ";"))
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "}":
+			depth--
+			if depth == 0 && current != nil {
+				leases = append(leases, *current)
+				current = nil
+			}
+			continue
+
+		case fields[len(fields)-1] == "{":
+			depth++
+			if depth == 1 && fields[0] == "lease" && len(fields) == 3 {
+				ip := net.ParseIP(fields[1])
+				if ip == nil || ip.To4() == nil {
+					return nil, fmt.Errorf("%s:%d invalid lease address %s", path, lineNo, fields[1])
+				}
+				current = &dhcpLease{ip: ip.To4(), active: true}
+			}
+			continue
+		}
+
+		if current == nil || depth != 1 {
+			continue
+		}
+		switch fields[0] {
+		case "ends":
+			current.ends, err = parseDhcpdTime(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d %w", path, lineNo, err)
+			}
+		case "binding": // Not "next binding state" nor "rewind binding state"
+			if len(fields) == 3 && fields[1] == "state" {
+				current.active = fields[2] == "active"
+			}
+		case "client-hostname":
+			if len(fields) == 2 {
+				current.name = strings.Trim(fields[1], `"`)
+			}
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("%s:%d unterminated lease %s", path, lineNo, current.ip)
+	}
+
+	return leases, scanner.Err()
+}
+
+// parseDhcpdTime parses the value of a dhcpd.leases time statement which is one of
+// "never", "epoch seconds" or "weekday yyyy/mm/dd hh:mm:ss" in UTC.
+func parseDhcpdTime(fields []string) (time.Time, error) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+	case len(fields) == 2 && fields[0] == "epoch":
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch time %s", fields[1])
+		}
+		return time.Unix(secs, 0), nil
+	case len(fields) == 3:
+		tm, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid lease time %s", strings.Join(fields, " "))
+		}
+		return tm, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid lease time %s", strings.Join(fields, " "))
+}
+
+// parseKeaLeases parses a Kea memfile lease file. Columns are located by the header line
+// so the same code handles lease4 and lease6 files as well as the columns added by newer
+// Kea versions. A lease with a zero valid_lifetime is a release and delegated prefixes
+// are ignored as they have no single address.
+func parseKeaLeases(r io.Reader, path string) (leases []dhcpLease, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	columns := make(map[string]int)
+	for ix, name := range header {
+		columns[strings.TrimSpace(name)] = ix
+	}
+	for _, name := range []string{"address", "expire", "hostname"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s header has no %s column", path, name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if ix, ok := columns[name]; ok && ix < len(record) {
+			return record[ix]
+		}
+		return ""
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", path, err)
+		}
+		line, _ := cr.FieldPos(0)
+		ip := net.ParseIP(field(record, "address"))
+		if ip == nil {
+			return nil, fmt.Errorf("%s:%d invalid address %s", path, line, field(record, "address"))
+		}
+		expire, err := strconv.ParseInt(field(record, "expire"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d invalid expire %s", path, line, field(record, "expire"))
+		}
+		l := dhcpLease{ip: ip, ends: time.Unix(expire, 0),
+			name: strings.ReplaceAll(field(record, "hostname"), "&#x2c", ",")}
+		state := field(record, "state")
+		l.active = (len(state) == 0 || state == "0") && field(record, "valid_lifetime") != "0" &&
+			field(record, "lease_type") != "2"
+		leases = append(leases, l)
+	}
+
+	return leases, nil
+}
+
+// parseDnsmasqLeases parses a dnsmasq.leases file. Each lease is one line of "expiry mac
+// address hostname client-id" where an expiry of zero means infinite and a hostname of
+// "*" means none. ipv6 leases have the iaid in place of the mac and are preceded by a
+// "duid" line which is skipped.
+func parseDnsmasqLeases(r io.Reader, path string) (leases []dhcpLease, err error) {
+	var lineNo int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("%s:%d expected at least 4 fields, not %d", path, lineNo, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d invalid expiry %s", path, lineNo, fields[0])
+		}
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			return nil, fmt.Errorf("%s:%d invalid address %s", path, lineNo, fields[2])
+		}
+		l := dhcpLease{ip: ip, name: fields[3], active: true}
+		if expiry != 0 {
+			l.ends = time.Unix(expiry, 0)
+		}
+		leases = append(leases, l)
+	}
+
+	return leases, scanner.Err()
+}
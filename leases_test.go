@@ -0,0 +1,133 @@
+package autoreverse
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/autoreverse/database"
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+func TestLoadFromLeases(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.SilentLevel)
+
+	now := time.Date(2021, 11, 18, 3, 0, 0, 0, time.UTC)
+	laptopEnds := time.Date(2021, 11, 18, 13, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		url   string
+		names map[string]string // Expected PTR of each address
+		oob   int
+	}{
+		{"dhcpd:///./testdata/leases/dhcpd.leases?domain=example.net",
+			map[string]string{"192.0.2.10": "laptop.example.net.",
+				"192.0.2.12": "printer.example.org.", "192.0.2.15": "tablet.example.net."}, 1},
+		{"kea:///./testdata/leases/kea-leases4.csv?domain=example.net",
+			map[string]string{"192.0.2.10": "laptop.example.net.",
+				"192.0.2.12": "printer.example.org.", "192.0.2.15": "tablet.example.net."}, 1},
+		{"kea:///./testdata/leases/kea-leases6.csv?domain=example.net",
+			map[string]string{"2001:db8::10": "laptop.example.net."}, 0},
+		{"dnsmasq:///./testdata/leases/dnsmasq.leases?domain=example.net",
+			map[string]string{"192.0.2.10": "laptop.example.net.",
+				"192.0.2.12": "printer.example.org.", "2001:db8::10": "tablet.example.net."}, 1},
+		{"dnsmasq:///./testdata/leases/dnsmasq.leases", // Bare names are dropped
+			map[string]string{"192.0.2.12": "printer.example.org."}, 0},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(&config{TTLAsSecs: 3600}, nil)
+		setAuthorities(ar)
+		pz, err := newPTRZoneFromURL(resolver.NewResolver(), tc.url)
+		if err != nil {
+			t.Fatal(ix, "Setup", err)
+		}
+		ls := pz.source.(*leaseSource)
+		db := database.NewDatabase()
+		err = ls.loadFromLeases(&zoneSink{pz: pz, db: db, auths: ar.authorities}, ar.cfg.TTLAsSecs, now)
+		if err != nil {
+			t.Error(ix, "Unexpected error", err)
+			continue
+		}
+		if db.Count() != len(tc.names) || pz.added != len(tc.names) || pz.oob != tc.oob {
+			t.Error(ix, "Wrong counts", db.Count(), pz.added, pz.oob)
+			db.Dump()
+		}
+		for ip, name := range tc.names {
+			ptrs := dbLookupIP(db, ip)
+			if len(ptrs) != 1 || !strings.HasSuffix(ptrs[0].String(), name) {
+				t.Error(ix, "Wrong PTR for", ip, ptrs)
+			}
+		}
+		if ls.dtm.IsZero() {
+			t.Error(ix, "DTM not set")
+		}
+	}
+
+	// Check TTLs are limited to the remaining lease time and that the earliest expiry
+	// triggers a reload.
+	ar := newAutoReverse(&config{TTLAsSecs: 3600}, nil)
+	setAuthorities(ar)
+	pz, _ := newPTRZoneFromURL(resolver.NewResolver(),
+		"dhcpd:///./testdata/leases/dhcpd.leases?domain=example.net")
+	ls := pz.source.(*leaseSource)
+	db := database.NewDatabase()
+	ls.loadFromLeases(&zoneSink{pz: pz, db: db, auths: ar.authorities}, ar.cfg.TTLAsSecs, laptopEnds.Add(-time.Minute))
+	ptrs := dbLookupIP(db, "192.0.2.10")
+	if len(ptrs) != 1 || ptrs[0].Header().Ttl != 60 {
+		t.Error("TTL not limited to lease remaining", ptrs)
+	}
+	ptrs = dbLookupIP(db, "192.0.2.12") // Never expires
+	if len(ptrs) != 1 || ptrs[0].Header().Ttl != 3600 {
+		t.Error("TTL of infinite lease not defaulted", ptrs)
+	}
+	if !ls.expiry.Equal(laptopEnds) {
+		t.Error("Wrong lease expiry", ls.expiry)
+	}
+	pzs := []*PTRZone{pz}
+	if trigger := ar.checkForReload(pzs, laptopEnds.Add(-time.Second)); len(trigger) > 0 {
+		t.Error("Unexpected reload trigger", trigger)
+	}
+	if trigger := ar.checkForReload(pzs, laptopEnds.Add(time.Second)); trigger != pz.url {
+		t.Error("Lease expiry did not trigger reload", trigger)
+	}
+}
+
+func TestLeaseParseErrors(t *testing.T) {
+	testCases := []struct {
+		format, text, contains string
+	}{
+		{dhcpdFormat, "lease 2001:db8::1 {\n}\n", "invalid lease address"},
+		{dhcpdFormat, "lease 192.0.2.1 {\n ends 4 2021/13/18 13:00:00;\n}\n", "test:2 invalid lease time"},
+		{dhcpdFormat, "lease 192.0.2.1 {\n ends epoch x;\n}\n", "invalid epoch"},
+		{dhcpdFormat, "lease 192.0.2.1 {\n ends 4;\n", "invalid lease time"},
+		{dhcpdFormat, "lease 192.0.2.1 {\n ends never;\n", "unterminated"},
+		{keaFormat, "address,hwaddr\n", "no expire column"},
+		{keaFormat, "address,expire,hostname\nbogus,0,x\n", "2 invalid address"},
+		{keaFormat, "address,expire,hostname\n192.0.2.1,x,x\n", "invalid expire"},
+		{dnsmasqFormat, "0 52:54:00:12:34:56 192.0.2.1\n", "at least 4"},
+		{dnsmasqFormat, "x 52:54:00:12:34:56 192.0.2.1 a *\n", "invalid expiry"},
+		{dnsmasqFormat, "0 52:54:00:12:34:56 192.0.2 a *\n", "invalid address"},
+	}
+
+	for ix, tc := range testCases {
+		var err error
+		r := strings.NewReader(tc.text)
+		switch tc.format {
+		case dhcpdFormat:
+			_, err = parseDhcpdLeases(r, "test")
+		case keaFormat:
+			_, err = parseKeaLeases(r, "test")
+		case dnsmasqFormat:
+			_, err = parseDnsmasqLeases(r, "test")
+		}
+		if err == nil {
+			t.Error(ix, "Expected error containing", tc.contains)
+		} else if !strings.Contains(err.Error(), tc.contains) {
+			t.Error(ix, "Wrong error. Exp:", tc.contains, "Got:", err.Error())
+		}
+	}
+}
@@ -1,12 +1,13 @@
-package main
+package autoreverse
 
 import (
 	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,149 +16,287 @@ import (
 	"github.com/markdingo/autoreverse/database"
 	"github.com/markdingo/autoreverse/dnsutil"
 	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/ptrsource"
 	"github.com/markdingo/autoreverse/resolver"
 )
 
+// newPTRZoneFromURL creates the PTRZone and the Source of its URL scheme. We could allow
+// all other schemes thru and let http.Get() deal with potentially new schemes as they
+// come along, but that risks letting thru a scheme that we want to do additional check
+// on, so for now, disallow all unknown schemes other than those registered by embedders.
 func newPTRZoneFromURL(r resolver.Resolver, s string) (*PTRZone, error) {
 	url, err := url.Parse(s)
 	if err != nil {
 		return nil, err
 	}
-	pz := &PTRZone{
-		resolver: r,
-		url:      s,
-		host:     url.Hostname(),
-		port:     url.Port(),
-		path:     url.Path,
-	}
+	pz := &PTRZone{resolver: r, url: s}
 
-	switch url.Scheme {
-	case "file":
-		pz.scheme = fileScheme
-		if len(pz.path) == 0 {
-			return nil, fmt.Errorf(url.Scheme + " URL must contain a file system path")
-		}
-		if len(url.Hostname()) > 0 || len(url.Port()) > 0 {
-			return nil, fmt.Errorf(url.Scheme + " URL cannot contain a host or port")
+	factory, builtin := builtinSources[url.Scheme]
+	if !builtin {
+		factory = ptrsource.Lookup(url.Scheme)
+		if factory == nil {
+			return nil, fmt.Errorf(url.Scheme + " is not a supported scheme")
 		}
+	}
+	pz.source, err = factory(url)
+	if err != nil {
+		return nil, err
+	}
 
-		// Special case mostly for tests. if path starts with "/./" remove the
-		// leading "/" to make it relative. Otherwise there is no way to specify a
-		// relative path in a file: URL as url.Path always starts at the first
-		// byte past the hostname, which by definition has to be a "/".
-		if strings.HasPrefix(pz.path, "/./") {
-			pz.path = pz.path[1:]
-		}
+	as, isAXFR := pz.source.(*axfrSource)
+	if isAXFR && len(as.redactedURL) > 0 {
+		pz.url = as.redactedURL
+	}
+	if builtin && !isAXFR && url.Query().Has("tsig") {
+		return nil, fmt.Errorf(url.Scheme + " URL cannot contain a tsig parameter")
+	}
 
-	case "http", "https":
-		pz.scheme = httpScheme
-		if len(pz.host) == 0 {
-			return nil, fmt.Errorf(url.Scheme + " URL must contain a host name")
-		}
-		if len(pz.path) == 0 {
-			return nil, fmt.Errorf(url.Scheme + " URL path must contain a zone name")
+	if v := url.Query().Get(maxStaleParam); len(v) > 0 {
+		pz.maxStale, err = time.ParseDuration(v)
+		if err != nil || pz.maxStale <= 0 {
+			return nil, fmt.Errorf(url.Scheme+" URL %s %s is not a positive duration", maxStaleParam, v)
 		}
+	}
 
-	case "axfr":
-		pz.scheme = axfrScheme
-		if len(pz.host) == 0 {
-			return nil, fmt.Errorf(url.Scheme + " URL host must contain a name server name")
-		}
-		if len(pz.path) > 0 && pz.path[0] == '/' { // Path is zone name - remove leading /
-			pz.path = pz.path[1:]
-		}
-		if len(pz.path) == 0 {
-			return nil, fmt.Errorf(url.Scheme + " URL path must contain a zone name")
-		}
-		pz.domain = dns.CanonicalName(pz.path)
-		if len(pz.port) == 0 {
-			pz.port = defaultService
-		}
+	return pz, nil
+}
 
-		// We could allow all other schemes thru and let http.Get() deal with
-		// potentially new schemes as they come along, but that risks letting thru
-		// a scheme that we want to do additional check on, so for now, disallow
-		// all unknown schemes.
-	default:
-		return nil, fmt.Errorf(url.Scheme + " is not a supported scheme")
+// staleLimit returns how long the last-good data is used in place of failed loads. It is
+// the max-stale URL parameter, if set, otherwise the SOA Expire of the zone or
+// defaultMaxStale for sources without an SOA.
+func (t *PTRZone) staleLimit() time.Duration {
+	switch {
+	case t.maxStale > 0:
+		return t.maxStale
+	case t.soa.Expire > 0:
+		return time.Second * time.Duration(t.soa.Expire)
 	}
 
-	return pz, nil
+	return defaultMaxStale
+}
+
+// fileSource is the file:// source which loads a zone file, or if the path is a pattern,
+// all zone files which match the pattern.
+type fileSource struct {
+	path      string
+	dtm       time.Time            // Latest DTM of the loaded file(s)
+	isGlob    bool                 // path is a pattern matching multiple zone files
+	globFiles map[string]time.Time // DTMs of the files matched by the last load
 }
 
-func (t *PTRZone) loadFromHTTP(db *database.Database, auths authorities, defaultTTL uint32) error {
-	resp, err := http.Get(t.url)
+func newFileSource(u *url.URL) (ptrsource.Source, error) {
+	path, err := filePath(u)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(resp.Status)
+	t := &fileSource{path: path, isGlob: strings.ContainsAny(path, globMeta)}
+	if t.isGlob {
+		if _, err = filepath.Match(t.path, ""); err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL path %s:%w", t.path, err)
+		}
 	}
 
-	t.dtm = time.Now() // Fake out a DTM for tests mostly
-
-	parser := dns.NewZoneParser(resp.Body, "", t.url)
-	parser.SetIncludeAllowed(false)
-	parser.SetDefaultTTL(defaultTTL) // ZoneParser needs this in case $TTL is absent
+	return t, nil
+}
 
-	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
-		t.addRR(db, auths, rr)
+func (t *fileSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	if t.isGlob {
+		return t.loadFromGlob(sink, defaultTTL)
 	}
+	return t.loadFromFile(sink, defaultTTL)
+}
 
-	return parser.Err() // Check for parser errors
+func (t *fileSource) NeedsReload(now time.Time) string {
+	if t.isGlob {
+		return t.globChanged()
+	}
+	return dtmChanged(t.path, t.dtm)
 }
 
+func (t *fileSource) Describe() string { return t.path }
+
 // loadFromFile reads the zone from a file and populates the PTR database with deduced
 // and actual PTRs.
-func (t *PTRZone) loadFromFile(db *database.Database, auths authorities, defaultTTL uint32) error {
-	f, err := os.Open(t.path)
+func (t *fileSource) loadFromFile(sink ptrsource.Sink, defaultTTL uint32) error {
+	dtm, err := parseZoneFile(sink, defaultTTL, t.path)
+	if !dtm.IsZero() {
+		t.dtm = dtm
+	}
+
+	return err
+}
+
+// parseZoneFile parses one zone file into the PTR database and returns its DTM.
+func parseZoneFile(sink ptrsource.Sink, defaultTTL uint32, path string) (time.Time, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 	defer f.Close()
 
 	fi, err := f.Stat()
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
-	t.dtm = fi.ModTime()
 
-	parser := dns.NewZoneParser(f, "", t.path)
+	parser := dns.NewZoneParser(f, "", path)
 	parser.SetIncludeAllowed(true)
 	parser.SetDefaultTTL(defaultTTL) // ZoneParser needs this in case $TTL is absent
 
 	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
-		t.addRR(db, auths, rr)
+		sink.AddRR(rr)
+	}
+
+	return fi.ModTime(), parser.Err() // Check for parser errors
+}
+
+// axfrSource is the axfr:// and ixfr:// source which transfers the zone named by the URL
+// path from the primary named by the URL host. It describes itself by the zone name.
+type axfrSource struct {
+	soaRefresh
+	host, port  string
+	path        string // Zone name as given in the URL
+	domain      string // Canonical zone name
+	redactedURL string // URL with any tsig secret redacted as the URL is logged
+
+	tsigKeyName string           // ?tsig=key-name naming a --TSIG-key
+	tsigKey     *dnsutil.TSIGKey // Signs transfer requests and verifies responses if set
+
+	incremental bool              // ixfr:// scheme
+	ixfrSerial  uint32            // Serial of ixfrRRs
+	ixfrRRs     map[string]dns.RR // Retained zone RRs keyed by rrKey()
+}
+
+func newAXFRSource(u *url.URL) (ptrsource.Source, error) {
+	t := &axfrSource{host: u.Hostname(), port: u.Port(), path: u.Path, incremental: u.Scheme == "ixfr"}
+	if len(t.host) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL host must contain a name server name")
+	}
+	if len(t.path) > 0 && t.path[0] == '/' { // Path is zone name - remove leading /
+		t.path = t.path[1:]
+	}
+	if len(t.path) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL path must contain a zone name")
+	}
+	t.domain = dns.CanonicalName(t.path)
+	if len(t.port) == 0 {
+		t.port = defaultService
+	}
+
+	err := t.setTSIG(u)
+	if err != nil {
+		return nil, err
 	}
 
-	return parser.Err() // Check for parser errors
+	return t, nil
+}
+
+func (t *axfrSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	if t.incremental {
+		return t.loadFromIXFR(t.sink(sink))
+	}
+	return t.loadFromAXFR(t.sink(sink))
+}
+
+func (t *axfrSource) NeedsReload(now time.Time) string { return t.refreshExpired(now) }
+func (t *axfrSource) Describe() string                 { return t.path }
+
+// setTSIG extracts the optional tsig query parameter from an axfr:// or ixfr:// URL. The
+// parameter is either the name of a --TSIG-key key, which is resolved later by
+// ValidateCommandLineOptions, or a complete key in the dig -y form of
+// [algorithm:]name:secret. In the latter case the secret is redacted from the URL as the
+// URL is logged.
+func (t *axfrSource) setTSIG(u *url.URL) (err error) {
+	q := u.Query()
+	spec := q.Get("tsig")
+	if len(spec) == 0 {
+		return nil
+	}
+	if !strings.Contains(spec, ":") {
+		t.tsigKeyName = dns.CanonicalName(spec)
+		return nil
+	}
+
+	// A '+' in an unescaped base64 secret is decoded as a space, so undo that.
+	t.tsigKey, err = dnsutil.ParseTSIGSpec(strings.ReplaceAll(spec, " ", "+"))
+	if err != nil {
+		return fmt.Errorf(u.Scheme+" URL %w", err)
+	}
+	redacted := *u
+	q.Set("tsig", t.tsigKey.Name+":REDACTED")
+	redacted.RawQuery = q.Encode()
+	t.redactedURL = redacted.String()
+
+	return nil
 }
 
 // loadFromAXFR AXFRs the domain and populates the PTR database with deduced and
 // actual PTRs.
-func (t *PTRZone) loadFromAXFR(db *database.Database, auths authorities) error {
-	transfer := &dns.Transfer{}
+func (t *axfrSource) loadFromAXFR(sink ptrsource.Sink) error {
 	req := new(dns.Msg)
 	req.SetAxfr(t.domain)
-	host := normalizeHostPort(t.host, t.port)
-	channel, err := transfer.In(req, host)
+	channel, provider, err := t.startTransfer(req)
 	if err != nil {
-		return fmt.Errorf("Failed to fetch '%s' from %s:%w", t.domain, host, err)
+		return err
 	}
-	t.dtm = time.Now() // Fake out a DTM for tests mostly
 
 	for env := range channel { // I think this only ever returns one env...
 		err := env.Error
 		if err != nil {
-			return err
+			return t.transferError(err, provider)
 		}
 		for _, rr := range env.RR {
-			t.addRR(db, auths, rr)
+			sink.AddRR(rr)
 		}
 	}
 
-	return nil
+	return t.transferError(nil, provider)
+}
+
+// startTransfer sends the AXFR or IXFR request to the primary, signed with the TSIG key
+// if the zone has one, in which case the returned provider verifies the responses.
+func (t *axfrSource) startTransfer(req *dns.Msg) (chan *dns.Envelope, *dnsutil.TSIGProvider, error) {
+	transfer := &dns.Transfer{}
+	var provider *dnsutil.TSIGProvider
+	if t.tsigKey != nil {
+		provider = t.tsigKey.Provider()
+		transfer.TsigProvider = provider
+		req.SetTsig(t.tsigKey.Name, t.tsigKey.Algorithm, 300, time.Now().Unix())
+	}
+	host := normalizeHostPort(t.host, t.port)
+	channel, err := transfer.In(req, host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to fetch '%s' from %s:%w", t.domain, host, err)
+	}
+
+	return channel, provider, nil
+}
+
+// transferError makes TSIG failures clearly identifiable, in part because miekg reports a
+// primary rejecting our key as a bare rcode. A transfer which otherwise succeeds is also
+// a failure if the zone has a key and no response message was signed with it.
+func (t *axfrSource) transferError(err error, provider *dnsutil.TSIGProvider) error {
+	if t.tsigKey == nil {
+		return err
+	}
+	if err == nil {
+		if provider.Verified() == 0 {
+			return fmt.Errorf("TSIG verification of '%s' with key %s failed:response is not signed",
+				t.domain, t.tsigKey.Name)
+		}
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, dns.ErrSig), errors.Is(err, dns.ErrTime),
+		errors.Is(err, dns.ErrSecret), errors.Is(err, dns.ErrKeyAlg):
+		return fmt.Errorf("TSIG verification of '%s' with key %s failed:%w",
+			t.domain, t.tsigKey.Name, err)
+	case strings.HasSuffix(err.Error(), fmt.Sprintf("rcode: %d", dns.RcodeNotAuth)):
+		return fmt.Errorf("Primary rejected TSIG key %s for '%s':%w", t.tsigKey.Name, t.domain, err)
+	}
+
+	return err
 }
 
 // Load in-domain Zone Of Authority address RRs into the candidate database. All other
@@ -211,34 +350,78 @@ func (t *autoReverse) loadFromChaos(db *database.Database) (count int) {
 	return 5
 }
 
+// zoneTrigger is a request to reload a single PTRZone.
+type zoneTrigger struct {
+	pz      *PTRZone
+	trigger string
+}
+
+// zoneReloadQueue is the number of pending zoneTriggers. Beyond that, a full reload is
+// triggered instead.
+const zoneReloadQueue = 16
+
 // loadAllZones creates a new database and populates it from exteral zones, the Zones Of
 // Authority and the CHAOS statics. If there are no errors, the new database replaces the
 // current one and true is returned.
+//
+// Each PTRZone loads into its own database which is retained as its last-good data. If a
+// PTRZone fails to load, its last-good data is used in place of fresh data until that
+// data is older than the PTRZone staleness limit, at which point the data is dropped. The
+// only load errors which prevent the new database from replacing the current one are from
+// PTRZones which fail the initial load. Once that has succeeded, a PTRZone which has never
+// loaded, such as one which is newly failing, simply contributes no data.
 func (t *autoReverse) loadAllZones(pzs []*PTRZone, trigger string) bool {
+	return t.loadZones(pzs, nil, trigger)
+}
+
+// loadZones is loadAllZones except that if only is non-nil, only those PTRZones are
+// loaded. All other PTRZones contribute their last-good data to the new database, which
+// saves a frequently changing source from causing every source to reload.
+func (t *autoReverse) loadZones(pzs []*PTRZone, only map[*PTRZone]bool, trigger string) bool {
 	newDB := database.NewDatabase()
-	var errorCount int
+	var errorCount, staleCount, emptyCount int
 	for _, pz := range pzs {
-		pz.loadTime = time.Now()
-		var err error
-		switch pz.scheme {
-		case fileScheme:
-			err = pz.loadFromFile(newDB, t.authorities, t.cfg.TTLAsSecs)
-
-		case httpScheme:
-			err = pz.loadFromHTTP(newDB, t.authorities, t.cfg.TTLAsSecs)
-
-		case axfrScheme:
-			err = pz.loadFromAXFR(newDB, t.authorities)
+		if only != nil && !only[pz] {
+			if pz.lastGood != nil {
+				pz.lastGood.Walk(dns.ClassINET, "", func(rr dns.RR) { newDB.AddRR(rr) })
+			}
+			continue
 		}
-
-		if err != nil {
-			errorCount++
+		now := time.Now()
+		pz.lines, pz.added, pz.oob = 0, 0, 0
+		zoneDB := database.NewDatabase()
+		if s, ok := pz.source.(ptrsource.Scoper); ok {
+			s.Scope(t.authorities.reverseCIDRs())
+		}
+		err := pz.source.Load(&zoneSink{pz: pz, db: zoneDB, auths: t.authorities}, t.cfg.TTLAsSecs)
+		pz.failing = err != nil
+		if err == nil {
+			pz.lastGood, pz.lastGoodTime = zoneDB, now
+			log.Minorf("Loaded: %s Lines=%d Deduced PTRs=%d OOB=%d Serial=%d Refresh=%d",
+				pz.source.Describe(), pz.lines, pz.added, pz.oob, pz.soa.Serial, pz.soa.Refresh)
+		} else {
 			warning(fmt.Errorf("PTRZone load of %s failed: %w", pz.url, err))
-			continue
+			switch {
+			case pz.lastGoodTime.IsZero() && !t.zonesLoaded:
+				errorCount++
+				continue
+			case pz.lastGoodTime.IsZero():
+				emptyCount++
+				continue
+			case pz.lastGood == nil: // Already dropped
+				continue
+			case now.Sub(pz.lastGoodTime) > pz.staleLimit():
+				log.Majorf("PTRZone %s data dropped as last good load at %s exceeds staleness limit of %s",
+					pz.url, pz.lastGoodTime.Format(time.RFC3339), pz.staleLimit())
+				pz.lastGood = nil
+				continue
+			}
+			staleCount++
+			zoneDB = pz.lastGood
+			log.Minorf("PTRZone %s using data from last good load at %s",
+				pz.url, pz.lastGoodTime.Format(time.RFC3339))
 		}
-
-		log.Minorf("Loaded: %s Lines=%d Deduced PTRs=%d OOB=%d Serial=%d Refresh=%d",
-			pz.path, pz.lines, pz.added, pz.oob, pz.soa.Serial, pz.soa.Refresh)
+		zoneDB.Walk(dns.ClassINET, "", func(rr dns.RR) { newDB.AddRR(rr) })
 	}
 
 	// Errors can only come from external loads, so deal with them now
@@ -247,6 +430,14 @@ func (t *autoReverse) loadAllZones(pzs []*PTRZone, trigger string) bool {
 			errorCount, trigger)
 		return false
 	}
+	if staleCount > 0 {
+		log.Majorf("LoadAllZones using last good data of %d PTRZones. Trigger: %s\n",
+			staleCount, trigger)
+	}
+	if emptyCount > 0 {
+		log.Majorf("LoadAllZones without data of %d PTRZones which have never loaded. Trigger: %s\n",
+			emptyCount, trigger)
+	}
 
 	c := t.loadFromAuthorities(newDB)
 	log.Minorf("Load Zones Of Authority: %d\n", c)
@@ -255,9 +446,12 @@ func (t *autoReverse) loadAllZones(pzs []*PTRZone, trigger string) bool {
 		log.Minorf("Load Chaos: %d\n", c)
 	}
 
-	log.Majorf("LoadAllZones Database Entries: %d. Trigger: %s\n", newDB.Count(), trigger)
+	newDB.SetSerial(nextSerial(t.dbGetter.Current().Serial(), time.Now()))
+	log.Majorf("LoadAllZones Database Entries: %d. Serial: %d. Trigger: %s\n",
+		newDB.Count(), newDB.Serial(), trigger)
 
 	t.dbGetter.Replace(newDB) // Can replace since no errors occurred
+	t.zonesLoaded = true
 
 	return true
 }
@@ -281,14 +475,21 @@ func (t *PTRZone) addRR(db *database.Database, auths authorities, rr dns.RR) {
 	}
 }
 
-// Add the PTR into the database iff it's in-domain
+// Add the PTR into the database iff it's in-domain. PTRs with regular owner names which
+// fall within an RFC2317 classless authority are renamed into that authority as that's
+// where the parent's CNAME directs queries.
 func (t *PTRZone) addPTR(db *database.Database, auths authorities, ptr *dns.PTR) {
-	if auths.findInDomain(ptr.Hdr.Name) != nil {
-		if db.AddRR(ptr) {
-			t.added++
+	if auths.findInDomain(ptr.Hdr.Name) == nil {
+		auth, qName := auths.findClassless(ptr.Hdr.Name)
+		if auth == nil {
+			t.oob++
+			return
 		}
-	} else {
-		t.oob++
+		ptr = dns.Copy(ptr).(*dns.PTR)
+		ptr.Hdr.Name = qName
+	}
+	if db.AddRR(ptr) {
+		t.added++
 	}
 }
 
@@ -301,41 +502,75 @@ func (t *PTRZone) resolveAndAddCNAME(db *database.Database, auths authorities, c
 	}
 
 	for _, ip := range ips {
-		if ip4 := ip.To4(); ip4 != nil {
-			var rr dns.A
-			rr.Hdr.Name = cname.Hdr.Name
-			rr.Hdr.Class = cname.Hdr.Class
-			rr.Hdr.Rrtype = dns.TypeA
-			rr.A = ip4
-			ptr, _ := dnsutil.DeducePtr(&rr)
-			if ptr != nil {
-				t.addPTR(db, auths, ptr)
-			}
-		} else if ip6 := ip.To16(); ip6 != nil {
-			var rr dns.AAAA
-			rr.Hdr.Name = cname.Hdr.Name
-			rr.Hdr.Class = cname.Hdr.Class
-			rr.Hdr.Rrtype = dns.TypeAAAA
-			rr.AAAA = ip6
-			ptr, _ := dnsutil.DeducePtr(&rr)
-			if ptr != nil {
-				t.addPTR(db, auths, ptr)
-			}
+		t.addAddress(db, auths, ip, cname.Hdr.Name, cname.Hdr.Ttl)
+	}
+}
+
+// addAddress deduces the PTR of an address and name pair for sources which are not zone
+// files and adds it via addPTR.
+func (t *PTRZone) addAddress(db *database.Database, auths authorities, ip net.IP, name string, ttl uint32) {
+	var rr dns.RR
+	if ip4 := ip.To4(); ip4 != nil {
+		rr = &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A: ip4}
+	} else if ip6 := ip.To16(); ip6 != nil {
+		rr = &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: ip6}
+	} else {
+		return
+	}
+	ptr, _ := dnsutil.DeducePtr(rr)
+	if ptr != nil {
+		t.addPTR(db, auths, ptr)
+	}
+}
+
+// qualifyName converts a hostname from a source which is not a zone into a PTR
+// target. Names with a trailing dot are used as-is, names without any dots have the
+// ?domain= parameter appended and all other names are assumed to be fully qualified. An
+// empty string is returned for absent or unusable names, including bare names when there
+// is no domain.
+func qualifyName(name, domain string) string {
+	name = strings.TrimSpace(name)
+	switch {
+	case len(name) == 0, name == "*", strings.ContainsAny(name, " \t"):
+		return ""
+	case strings.HasSuffix(name, "."):
+	case !strings.Contains(name, "."):
+		if len(domain) == 0 {
+			return ""
 		}
+		name += "." + domain
+	}
+	name = dns.CanonicalName(name)
+	if _, ok := dns.IsDomainName(name); !ok {
+		return ""
 	}
+
+	return name
 }
 
 // Periodically check whether any of the PTR-deduce zones needs reloading. A reload of all
 // zones occurs when any of the zones reach their minimum reload or any of the files DTM
 // changes. Because it's not easy to be notified of DTM changes across platforms, this
 // routine simply polls at a relatively low rate. This go-routine exits when
-// autoReverse->Done() closes.
+// autoReverse->Done() closes. Sources which implement ptrsource.Watcher are started from
+// here so they can trigger their own reloads. As watched sources can change frequently,
+// such as with docker events or SLAAC address churn, their reloads only reload their own
+// PTRZone.
 //
 // One this function is given control, only it can
 func (t *autoReverse) watchForZoneReloads(pzs []*PTRZone, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	for _, pz := range pzs {
+		if w, ok := pz.source.(ptrsource.Watcher); ok {
+			pz := pz
+			go w.Watch(t.Done(), func(trigger string) { t.triggerZoneReload(pz, trigger) })
+		}
+	}
+
 	for {
 		select {
 		case <-t.Done():
@@ -344,6 +579,21 @@ func (t *autoReverse) watchForZoneReloads(pzs []*PTRZone, interval time.Duration
 		case <-t.forceReload:
 			t.loadAllZones(pzs, "force reload")
 
+		case trigger := <-t.reload:
+			t.loadAllZones(pzs, trigger)
+
+		case zt := <-t.zoneReload:
+			only := map[*PTRZone]bool{zt.pz: true}
+			for pending := true; pending; { // Coalesce all pending zoneTriggers
+				select {
+				case more := <-t.zoneReload:
+					only[more.pz] = true
+				default:
+					pending = false
+				}
+			}
+			t.loadZones(pzs, only, zt.trigger)
+
 		case now := <-ticker.C:
 			trigger := t.checkForReload(pzs, now)
 			if len(trigger) > 0 {
@@ -353,29 +603,37 @@ func (t *autoReverse) watchForZoneReloads(pzs []*PTRZone, interval time.Duration
 	}
 }
 
+// triggerReload is the autoReverse equivalent of server.triggerReload.
+func (t *autoReverse) triggerReload(trigger string) {
+	select {
+	case t.reload <- trigger:
+	default:
+	}
+}
+
+// triggerZoneReload asks the zone watcher to reload just the PTRZone on behalf of its
+// ptrsource.Watcher. If the queue is full a full reload is triggered, which satisfies it.
+func (t *autoReverse) triggerZoneReload(pz *PTRZone, trigger string) {
+	select {
+	case t.zoneReload <- zoneTrigger{pz: pz, trigger: trigger}:
+	default:
+		t.triggerReload(trigger)
+	}
+}
+
 // checkForReload returns a trigger reason if a reload should be attempted. As soon as one
 // condition determines that a reload is necessary then return that fact. Don't bother to
-// check any others.
+// check any others. A failing PTRZone whose last good data has exceeded its staleness
+// limit triggers a reload so that the data is dropped even if nothing else changes.
 func (t *autoReverse) checkForReload(pzs []*PTRZone, now time.Time) string {
 	for _, pz := range pzs {
-		switch pz.scheme {
-		case fileScheme:
-			fi, err := os.Stat(pz.path)
-			if err != nil {
-				warning(err, "Could not stat zone file:"+pz.path)
-				continue
-			}
-			if fi.ModTime().After(pz.dtm) {
-				log.Debug(pz.path, "DTM triggers reload")
-				return pz.url
-			}
-
-		case axfrScheme, httpScheme:
-			nextLoad := pz.loadTime.Add(time.Second * time.Duration(pz.soa.Refresh))
-			if now.After(nextLoad) {
-				log.Debug(pz.domain, "Expired Refresh triggers reload")
-				return pz.url
-			}
+		if pz.failing && pz.lastGood != nil && now.Sub(pz.lastGoodTime) > pz.staleLimit() {
+			log.Debug(pz.source.Describe(), "stale data triggers reload")
+			return pz.url
+		}
+		if reason := pz.source.NeedsReload(now); len(reason) > 0 {
+			log.Debug(pz.source.Describe(), reason, "triggers reload")
+			return pz.url
 		}
 	}
 
@@ -1,9 +1,10 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
@@ -16,6 +17,7 @@ import (
 	"github.com/markdingo/autoreverse/log"
 	"github.com/markdingo/autoreverse/mock"
 	mockDNS "github.com/markdingo/autoreverse/mock/dns"
+	"github.com/markdingo/autoreverse/ptrsource"
 	"github.com/markdingo/autoreverse/resolver"
 )
 
@@ -34,6 +36,21 @@ func TestNewPTRZoneFromURL(t *testing.T) {
 		{"axfr://ns.example.net/example.org", "ns.example.net", "example.org", ""},
 		{"axfr://", "", "", "must contain a name"},
 		{"axfr://ns.example.net", "", "", "must contain a zone"},
+		{"ixfr://ns.example.net/example.org", "ns.example.net", "example.org", ""},
+		{"ixfr://ns.example.net", "", "", "must contain a zone"},
+
+		{"dhcpd:///var/db/dhcpd.leases", "", "/var/db/dhcpd.leases", ""},
+		{"kea://host/var/lib/kea/kea-leases4.csv", "", "", "cannot contain"},
+		{"dnsmasq://", "", "", "file system path"},
+
+		{"hosts:///etc/hosts", "", "/etc/hosts", ""},
+		{"hosts://host/etc/hosts", "", "", "cannot contain"},
+
+		{"csv:///var/ipam/export.csv", "", "/var/ipam/export.csv", ""},
+		{"json://host/var/ipam/export.json", "", "", "cannot contain"},
+
+		{"exec:///usr/local/bin/dump-ptrs?interval=5m", "", "/usr/local/bin/dump-ptrs", ""},
+		{"exec://", "", "", "file system path"},
 
 		{"ftp://ns.example.net", "", "", "not a supported scheme"},
 		{"http:\n control char", "", "", "invalid control character"},
@@ -53,11 +70,54 @@ func TestNewPTRZoneFromURL(t *testing.T) {
 		if len(tc.contains) > 0 {
 			t.Errorf("Expected error with '%s'", tc.contains)
 		}
-		if tc.host != pz.host {
-			t.Error(ix, "hosts mismatch", tc.host, pz.host)
+		host, path := sourceHostPath(pz.source)
+		if tc.host != host {
+			t.Error(ix, "hosts mismatch", tc.host, host)
+		}
+		if tc.path != path {
+			t.Error(ix, "paths mismatch", tc.path, path)
 		}
-		if tc.path != pz.path {
-			t.Error(ix, "paths mismatch", tc.path, pz.path)
+	}
+}
+
+// sourceHostPath returns the host and path which the built-in source extracted from its URL.
+func sourceHostPath(source ptrsource.Source) (host, path string) {
+	switch s := source.(type) {
+	case *fileSource:
+		return "", s.path
+	case *httpSource:
+		u, _ := url.Parse(s.fetchURL)
+		return u.Hostname(), s.path
+	case *axfrSource:
+		return s.host, s.path
+	case *leaseSource:
+		return "", s.path
+	case *hostsSource:
+		return "", s.path
+	case *inventorySource:
+		return "", s.path
+	case *execSource:
+		return "", s.path
+	}
+
+	return "", ""
+}
+
+func TestQualifyName(t *testing.T) {
+	testCases := []struct{ name, domain, exp string }{
+		{"laptop", "example.net.", "laptop.example.net."},
+		{"laptop", "", ""},
+		{"Laptop.Example.Org", "example.net.", "laptop.example.org."},
+		{"laptop.", "example.net.", "laptop."},
+		{"*", "example.net.", ""},
+		{"", "example.net.", ""},
+		{"Bob's iPhone", "example.net.", ""},
+	}
+
+	for ix, tc := range testCases {
+		got := qualifyName(tc.name, tc.domain)
+		if got != tc.exp {
+			t.Error(ix, "Exp:", tc.exp, "Got:", got)
 		}
 	}
 }
@@ -136,8 +196,8 @@ func TestLoadFromFile(t *testing.T) {
 		}
 
 		// Check side-effects
-		if pz.dtm.IsZero() {
-			t.Error("loadZoneFromFile did not return a DTM", tc.zone, pz.dtm)
+		if fs := pz.source.(*fileSource); fs.dtm.IsZero() {
+			t.Error("loadZoneFromFile did not return a DTM", tc.zone, fs.dtm)
 		}
 		if pz.soa.Serial != 1636863624 {
 			t.Error("Incorrect serial", tc.zone, pz.soa.Serial)
@@ -234,8 +294,8 @@ func TestLoadFromAXFR(t *testing.T) {
 		}
 
 		// Check side-effects
-		if pz.dtm.IsZero() {
-			t.Error(ix, url, "loadZoneFromFile did not return a DTM", pz.dtm)
+		if as := pz.source.(*axfrSource); as.loadTime.IsZero() {
+			t.Error(ix, url, "loadFromAXFR did not set the load time", as.loadTime)
 		}
 		if pz.soa.Serial != 1636863624 {
 			t.Error(ix, url, "Incorrect serial", pz.soa.Serial)
@@ -434,8 +494,8 @@ func checkULAPtr(t *testing.T, src string, db *database.Database) {
 // This is a racy test as the watcher is meant to have complete ownership of the PTRZones,
 // but we happen to know it's ok and it's only a test risk, rather than a production risk.
 func checkReload(t *testing.T, ar *autoReverse, source string, trigger func()) {
-	pz := ar.cfg.PTRZones[0] // Only called with a single PTRZone - must copy
-	dtm := pz.dtm            // Note current value
+	pz := ar.cfg.PTRZones[0]  // Only called with a single PTRZone - must copy
+	loaded := pz.lastGoodTime // Note current value
 	go ar.watchForZoneReloads(ar.cfg.PTRZones, time.Millisecond*100)
 	if trigger != nil {
 		trigger()
@@ -444,7 +504,7 @@ func checkReload(t *testing.T, ar *autoReverse, source string, trigger func()) {
 		ar.forceReload <- struct{}{}
 	}
 	time.Sleep(time.Second * 1) // make sure watcher has plenty of time
-	if dtm == pz.dtm {
+	if loaded == pz.lastGoodTime {
 		t.Error("Watcher failed to reload", source)
 	}
 }
@@ -479,3 +539,86 @@ func dbLookupIP(db *database.Database, ipStr string) (ar []dns.RR) {
 
 	return
 }
+
+// tsigPrimary answers transfers with a tiny zone and optionally signs the response.
+type tsigPrimary struct {
+	sign         bool // Sign the response
+	ignoreStatus bool // Answer even if the request failed verification
+}
+
+func (t *tsigPrimary) ServeDNS(wtr dns.ResponseWriter, query *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(query)
+	if wtr.TsigStatus() != nil && !t.ignoreStatus {
+		m.Rcode = dns.RcodeNotAuth
+	} else {
+		soa := "example.net. IN SOA ns1.example.net. mbox. 1 60 60 60 60"
+		m.Answer = append(m.Answer, newRR(soa), newRR("ns1.example.net. IN A 192.0.2.1"), newRR(soa))
+	}
+	if tsig := query.IsTsig(); tsig != nil && t.sign {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+	wtr.WriteMsg(m)
+}
+
+func TestLoadTSIG(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	const keyName = "xfr.example.net."
+	const secret = "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE="
+	primary := &tsigPrimary{}
+	for _, p := range []struct{ addr, secret string }{
+		{"127.0.0.1:2063", secret},
+		{"127.0.0.1:2064", "YmFkc2VjcmV0YmFkc2VjcmV0"}, // Primary has a different secret
+	} {
+		started := make(chan struct{})
+		srv := &dns.Server{Addr: p.addr, Net: "tcp", Handler: primary,
+			TsigSecret:        map[string]string{keyName: p.secret},
+			NotifyStartedFunc: func() { close(started) }}
+		go srv.ListenAndServe()
+		<-started
+		defer srv.Shutdown()
+	}
+
+	testCases := []struct {
+		url          string
+		sign         bool
+		ignoreStatus bool
+		contains     string
+	}{
+		{"axfr://127.0.0.1:2063/example.net", true, false, ""},
+		{"ixfr://127.0.0.1:2063/example.net", true, false, ""},
+		{"axfr://127.0.0.1:2063/example.net", false, false, "response is not signed"},
+		{"ixfr://127.0.0.1:2063/example.net", false, false, "response is not signed"},
+		{"axfr://127.0.0.1:2064/example.net", false, false, "Primary rejected TSIG key"},
+		{"axfr://127.0.0.1:2064/example.net", true, true, "bad signature"},
+	}
+
+	ar := newAutoReverse(nil, nil)
+	setAuthorities(ar)
+	for ix, tc := range testCases {
+		primary.sign = tc.sign
+		primary.ignoreStatus = tc.ignoreStatus
+		pz, err := newPTRZoneFromURL(ar.resolver, tc.url+"?tsig=hmac-sha256:"+keyName+":"+secret)
+		if err != nil {
+			t.Fatal(ix, "Setup", err)
+		}
+		db := database.NewDatabase()
+		err = pz.source.Load(&zoneSink{pz: pz, db: db, auths: ar.authorities}, ar.cfg.TTLAsSecs)
+		if err != nil {
+			if len(tc.contains) == 0 || !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		if pz.added != 1 {
+			t.Error(ix, "Expected one PTR to be deduced, not", pz.added)
+		}
+	}
+}
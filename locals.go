@@ -1,7 +1,8 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
+	"net"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -9,29 +10,65 @@ import (
 	"github.com/markdingo/autoreverse/dnsutil"
 )
 
-// Synthesize the --local-forward zone which includes making an SOA.
-func (t *autoReverse) generateLocalForward(forward string) {
+// Synthesize the --local-forward zone which includes making an SOA. Also used for forwards
+// mapped with --local-reverse CIDR=forward which are not otherwise delegated.
+func (t *autoReverse) generateLocalForward(forward string) *authority {
 	auth := &authority{forward: true}
 	auth.Source = "--local-forward"
 	auth.Domain = dns.CanonicalName(forward)
 	auth.synthesizeSOA(auth.Domain, t.cfg.TTLAsSecs)
 	logAuth(auth, "Local Forward")
-	t.forwardAuthority = auth
-	t.addAuthority(auth)
+	t.addForwardAuthority(auth)
+
+	return auth
 }
 
 // Synthesize zones for --local-reverse zones. This includes making an SOA and copying the
 // forward NS details which may or may not be present and may or may not be right...
 // generateLocalReverses relies on the forward zone already being set.
+//
+// ipv6 CIDRs which are not nibble-aligned are split into the nibble-aligned zones which
+// exactly cover the CIDR, so no addresses outside the CIDR are ever in-domain.
+//
+// A CIDR mapped to a forward other than the default uses that forward's authority, which
+// is generated as a local forward if it was not previously discovered.
 func (t *autoReverse) generateLocalReverses() error {
 	for _, ipNet := range t.localReverses {
-		auth := &authority{cidr: ipNet}
-		auth.Source = "--local-reverse"
+		forward := t.reverseForward(ipNet)
+		fwd := t.forwardAuthority
+		if forward != t.forward {
+			fwd = t.forwardAuthorities[forward]
+			if fwd == nil {
+				fwd = t.generateLocalForward(forward)
+			}
+		}
+		for _, subNet := range dnsutil.NibbleCIDRs(ipNet) {
+			err := t.generateLocalReverse(fwd, subNet)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// generateLocalReverse synthesizes one --local-reverse zone for the nibble-aligned or
+// classless CIDR and associates it with the forward authority.
+func (t *autoReverse) generateLocalReverse(fwd *authority, ipNet *net.IPNet) error {
+	auth := &authority{cidr: ipNet, ptrSuffix: fwd.Domain}
+	auth.Source = "--local-reverse"
 
-		// The reverse zone is needed to match the PTR queries. In a slightly
-		// hacky way, we generate a full PTR qName - because that function already
-		// exists - and trim off the excess tokens based on the prefix length.
+	// The reverse zone is needed to match the PTR queries. In a slightly hacky way, we
+	// generate a full PTR qName - because that function already exists - and trim off
+	// the excess tokens based on the prefix length.
+	//
+	// Classless ipv4 CIDRs have no natural reverse zone so they get the RFC2317
+	// suggested zone name as there is no parent to tell us otherwise.
 
+	if dnsutil.IsClassless(ipNet) {
+		auth.Domain = dnsutil.ClasslessReverseZone(ipNet)
+	} else {
 		domain := dnsutil.IPToReverseQName(ipNet.IP) // full PTR qName
 		ones, bits := ipNet.Mask.Size()
 		var remove int
@@ -45,22 +82,21 @@ func (t *autoReverse) generateLocalReverses() error {
 			return fmt.Errorf("Internal error, local %s (%d) < %d tokens",
 				domain, len(tokens), remove)
 		}
-		domain = strings.Join(tokens[remove:], ".")
-		auth.Domain = domain
+		auth.Domain = strings.Join(tokens[remove:], ".")
+	}
 
-		// Now we have a domain the NS qNames can be mutated
-		for _, ns := range t.forwardAuthority.NS {
-			rr := dns.Copy(ns) // Take a copy as we modify
-			rr.Header().Name = auth.Domain
-			auth.NS = append(auth.NS, rr)
+	// Now we have a domain the NS qNames can be mutated
+	for _, ns := range fwd.NS {
+		rr := dns.Copy(ns) // Take a copy as we modify
+		rr.Header().Name = auth.Domain
+		auth.NS = append(auth.NS, rr)
 
-		}
-		auth.synthesizeSOA(t.forward, t.cfg.TTLAsSecs)
-		if !t.addAuthority(auth) {
-			return fmt.Errorf("--local-reverse %s is duplicated", auth.Domain)
-		}
-		logAuth(auth, "Local Reverse")
 	}
+	auth.synthesizeSOA(fwd.Domain, t.cfg.TTLAsSecs)
+	if !t.addAuthority(auth) {
+		return fmt.Errorf("--local-reverse %s is duplicated", auth.Domain)
+	}
+	logAuth(auth, "Local Reverse")
 
 	return nil
 }
@@ -1,9 +1,10 @@
-package main
+package autoreverse
 
 import (
 	"net"
 	"testing"
 
+	"github.com/markdingo/autoreverse/dnsutil"
 	"github.com/markdingo/autoreverse/log"
 	"github.com/markdingo/autoreverse/mock"
 )
@@ -81,3 +82,114 @@ func TestGenerateLocalReverse(t *testing.T) {
 		t.Error("Expected a 'duplicates' error")
 	}
 }
+
+func TestGenerateLocalReverseClassless(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	ar := newAutoReverse(nil, nil)
+	ar.generateLocalForward("example.net.")
+
+	_, ipNet, err := net.ParseCIDR("192.0.2.64/27")
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	ar.localReverses = append(ar.localReverses, ipNet)
+	err = ar.generateLocalReverses()
+	if err != nil {
+		t.Fatal("Unexpected classless error", ipNet, err)
+	}
+
+	auth := ar.authorities.slice[1]
+	exp := "64/27.2.0.192.in-addr.arpa."
+	if auth.Domain != exp {
+		t.Error("Wrong classless reverse. Exp", exp, "Got", auth.Domain)
+	}
+	if !auth.classless() {
+		t.Error("Authority should be classless", auth.Domain)
+	}
+}
+
+func TestGenerateLocalReverseSplit(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	ar := newAutoReverse(nil, nil)
+	ar.generateLocalForward("example.net.")
+
+	_, ipNet, err := net.ParseCIDR("fd2d:ffff:4::/46")
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	ar.localReverses = append(ar.localReverses, ipNet)
+	err = ar.generateLocalReverses()
+	if err != nil {
+		t.Fatal("Unexpected split error", ipNet, err)
+	}
+	if ar.authorities.len() != 5 { // Forward + four /48s
+		t.Fatal("Expected /46 to be split into four /48s", ar.authorities.len())
+	}
+
+	for ix, exp := range []string{"4.0.0.0.f.f.f.f.d.2.d.f.ip6.arpa.", "5.0.0.0.f.f.f.f.d.2.d.f.ip6.arpa.",
+		"6.0.0.0.f.f.f.f.d.2.d.f.ip6.arpa.", "7.0.0.0.f.f.f.f.d.2.d.f.ip6.arpa."} {
+		auth := ar.authorities.slice[ix+1]
+		if auth.Domain != exp {
+			t.Error(ix, "Wrong split reverse. Exp", exp, "Got", auth.Domain)
+		}
+	}
+
+	// Addresses in the rounded-down /44 but outside the /46 must not be in-domain
+	for _, s := range []string{"fd2d:ffff:0::1", "fd2d:ffff:8::1", "fd2d:ffff:f::1"} {
+		if ar.authorities.findIPInDomain(net.ParseIP(s)) != nil {
+			t.Error("Address outside /46 should not be in-domain", s)
+		}
+		if ar.authorities.findInDomain(dnsutil.IPToReverseQName(net.ParseIP(s))) != nil {
+			t.Error("Reverse outside /46 should not be in-domain", s)
+		}
+	}
+	for _, s := range []string{"fd2d:ffff:4::1", "fd2d:ffff:7:ffff::1"} {
+		if ar.authorities.findIPInDomain(net.ParseIP(s)) == nil {
+			t.Error("Address inside /46 should be in-domain", s)
+		}
+		if ar.authorities.findInDomain(dnsutil.IPToReverseQName(net.ParseIP(s))) == nil {
+			t.Error("Reverse inside /46 should be in-domain", s)
+		}
+	}
+}
+
+func TestGenerateLocalReverseMapped(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	ar := newAutoReverse(nil, nil)
+	ar.forward = "example.net."
+	ar.generateLocalForward(ar.forward)
+
+	_, ipNet, err := net.ParseCIDR("fd2d:1::/48")
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	ar.localReverses = append(ar.localReverses, ipNet)
+	ar.reverseForwards[ipNet.String()] = "cust1.example.org."
+	err = ar.generateLocalReverses()
+	if err != nil {
+		t.Fatal("Unexpected mapped error", ipNet, err)
+	}
+	if ar.authorities.len() != 3 { // Default forward + mapped forward + reverse
+		t.Fatal("Expected mapped forward to be generated", ar.authorities.len())
+	}
+	if ar.forwardAuthority.Domain != "example.net." {
+		t.Error("Default forward changed to", ar.forwardAuthority.Domain)
+	}
+	fwd := ar.forwardAuthorities["cust1.example.org."]
+	if fwd == nil || !fwd.forward {
+		t.Fatal("Mapped forward authority not generated")
+	}
+	auth := ar.authorities.findIPInDomain(ipNet.IP)
+	if auth == nil || auth.ptrSuffix != "cust1.example.org." {
+		t.Error("Reverse not mapped to forward", auth)
+	}
+}
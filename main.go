@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
@@ -31,7 +31,13 @@ func warning(err error, messages ...string) {
 
 //////////////////////////////////////////////////////////////////////
 
-func main() {
+// Main is the autoreverse program. It parses os.Args, starts the servers and only returns
+// once they have been shut down by a signal. Fatal errors exit the process.
+//
+// The autoreverse command is a thin wrapper around Main. Embedders with their own
+// --PTR-deduce sources write a similar wrapper which calls ptrsource.Register before
+// calling Main.
+func Main() {
 	ar := newAutoReverse(nil, nil)
 	switch ar.parseOptions(os.Args) {
 	case parseStop:
@@ -93,6 +99,14 @@ func main() {
 
 	ar.Constrain() // setuid/setgid/chroot
 
+	if ar.updates != nil { // Journal is relative to chroot so replay after Constrain()
+		count, err := ar.updates.loadJournal()
+		if err != nil {
+			fatal(err, "--UPDATE-journal")
+		}
+		log.Majorf("UPDATE journal replayed: %d names", count)
+	}
+
 	if !ar.loadAllZones(ar.cfg.PTRZones, "Initial load") {
 		fatal(nil, "Cannot continue due to failed -PTRZone load")
 	}
@@ -0,0 +1,319 @@
+package autoreverse
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+const (
+	neighborEthersParam     = "ethers"
+	neighborIntervalParam   = "interval"
+	neighborDefaultInterval = time.Second * 30
+)
+
+// neighbor is a live entry from a kernel neighbor (ARP or NDP) table.
+type neighbor struct {
+	ip  net.IP
+	mac net.HardwareAddr
+}
+
+// neighborSource is the neighbor:// source which creates PTRs for the addresses of hosts
+// present in the kernel neighbor tables. Each MAC address is mapped to a name with an
+// ethers(5) file, thus every address used by a known host is covered, including ipv6
+// privacy addresses. Without a URL path the tables are read via netlink, e.g.
+// neighbor://?ethers=/etc/ethers&domain=example.net, otherwise the path names a file in
+// /proc/net/arp or "ip neigh" format. Names are qualified with the domain parameter.
+//
+// As the tables change constantly, they are polled by Watch and a reload is only
+// triggered when the set of named addresses changes. The ethers file is re-read each load.
+type neighborSource struct {
+	path     string // Empty means netlink
+	ethers   string
+	domain   string
+	interval time.Duration
+
+	mu     sync.Mutex
+	loaded string // fingerprint of the last load
+	dtm    time.Time
+}
+
+func newNeighborSource(u *url.URL) (ptrsource.Source, error) {
+	if len(u.Host) > 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL cannot contain a host or port")
+	}
+	q := u.Query()
+	t := &neighborSource{path: u.Path, ethers: q.Get(neighborEthersParam),
+		domain: q.Get("domain"), interval: neighborDefaultInterval}
+	if strings.HasPrefix(t.path, "/./") { // Same relative path convention as file://
+		t.path = t.path[1:]
+	}
+	if len(t.ethers) == 0 {
+		return nil, fmt.Errorf(u.Scheme+" URL must contain an %s parameter", neighborEthersParam)
+	}
+	if len(t.domain) > 0 {
+		t.domain = dns.CanonicalName(t.domain)
+	}
+	if v := q.Get(neighborIntervalParam); len(v) > 0 {
+		var err error
+		t.interval, err = time.ParseDuration(v)
+		if err != nil || t.interval <= 0 {
+			return nil, fmt.Errorf(u.Scheme+" URL %s '%s' is not a positive duration",
+				neighborIntervalParam, v)
+		}
+	}
+
+	return t, nil
+}
+
+// Load adds a PTR for each neighbor with a MAC address in the ethers file.
+func (t *neighborSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	named, dtm, err := t.named()
+	if err != nil {
+		return err
+	}
+	for _, n := range named {
+		sink.AddAddress(n.ip, n.name, defaultTTL)
+	}
+
+	t.mu.Lock()
+	t.loaded = fingerprintNamed(named)
+	t.dtm = dtm
+	t.mu.Unlock()
+
+	return nil
+}
+
+// namedNeighbor is a neighbor with a name from the ethers file.
+type namedNeighbor struct {
+	ip   net.IP
+	name string
+}
+
+// named returns the sorted neighbors which have a name along with the DTM of the ethers
+// file.
+func (t *neighborSource) named() (named []namedNeighbor, dtm time.Time, err error) {
+	f, err := os.Open(t.ethers)
+	if err != nil {
+		return nil, dtm, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, dtm, err
+	}
+	ethers, err := parseEthers(f, t.ethers)
+	if err != nil {
+		return nil, dtm, err
+	}
+
+	var neighbors []neighbor
+	if len(t.path) == 0 {
+		neighbors, err = netlinkNeighbors()
+	} else {
+		neighbors, err = readNeighbors(t.path)
+	}
+	if err != nil {
+		return nil, dtm, err
+	}
+
+	for _, n := range neighbors {
+		name := qualifyName(ethers[n.mac.String()], t.domain)
+		if len(name) > 0 {
+			named = append(named, namedNeighbor{ip: n.ip, name: name})
+		}
+	}
+	sort.Slice(named, func(i, j int) bool { return named[i].ip.String() < named[j].ip.String() })
+
+	return named, fi.ModTime(), nil
+}
+
+func fingerprintNamed(named []namedNeighbor) string {
+	var sb strings.Builder
+	for _, n := range named {
+		sb.WriteString(n.ip.String() + " " + n.name + "\n")
+	}
+
+	return sb.String()
+}
+
+// NeedsReload never requests a reload as changes are learnt by polling in Watch.
+func (t *neighborSource) NeedsReload(now time.Time) string {
+	return ""
+}
+
+func (t *neighborSource) Describe() string {
+	if len(t.path) == 0 {
+		return "neighbor netlink " + t.ethers
+	}
+
+	return "neighbor " + t.path + " " + t.ethers
+}
+
+// Watch polls the neighbor tables and the ethers file every interval and triggers a reload
+// if the named addresses differ from those of the last load.
+func (t *neighborSource) Watch(done <-chan struct{}, reload func(reason string)) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		named, dtm, err := t.named()
+		if err != nil {
+			log.Minorf("Neighbor poll of %s failed:%s", t.Describe(), err)
+			continue
+		}
+		t.mu.Lock()
+		changed := fingerprintNamed(named) != t.loaded
+		ethersChanged := dtm.After(t.dtm)
+		t.mu.Unlock()
+		switch {
+		case ethersChanged:
+			reload("DTM of " + t.ethers)
+		case changed:
+			reload("Neighbor change")
+		}
+	}
+}
+
+// readNeighbors reads a file of neighbors in /proc/net/arp or "ip neigh" format.
+func readNeighbors(path string) ([]neighbor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseNeighbors(f, path)
+}
+
+// parseNeighbors parses either the /proc/net/arp format, recognized by its header line:
+//
+//	IP address       HW type     Flags       HW address            Mask     Device
+//	192.0.2.10       0x1         0x2         52:54:00:12:34:56     *        eth0
+//
+// or the "ip neigh" format:
+//
+//	2001:db8::1c4a:2bff:fe3d:4e5f dev eth0 lladdr 1e:4a:2b:3d:4e:5f REACHABLE
+//
+// Incomplete and failed entries are excluded as they are not present hosts.
+func parseNeighbors(r io.Reader, path string) (neighbors []neighbor, err error) {
+	const atfCom = 0x2 // /proc/net/arp flag of a completed entry
+
+	var lineNo int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || (lineNo == 1 && fields[0] == "IP") { // Empty or arp header
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("%s:%d invalid address %s", path, lineNo, fields[0])
+		}
+		var macStr string
+		if len(fields) >= 6 && strings.HasPrefix(fields[1], "0x") { // /proc/net/arp
+			flags, err := strconv.ParseUint(fields[2], 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d invalid flags %s", path, lineNo, fields[2])
+			}
+			if flags&atfCom == 0 {
+				continue
+			}
+			macStr = fields[3]
+		} else { // ip neigh
+			state := fields[len(fields)-1]
+			if state == "FAILED" || state == "INCOMPLETE" {
+				continue
+			}
+			for ix := 1; ix < len(fields)-1; ix++ {
+				if fields[ix] == "lladdr" {
+					macStr = fields[ix+1]
+				}
+			}
+			if len(macStr) == 0 {
+				continue
+			}
+		}
+		mac, err := parseMAC(macStr)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d %w", path, lineNo, err)
+		}
+		neighbors = append(neighbors, neighbor{ip: ip, mac: mac})
+	}
+
+	return neighbors, scanner.Err()
+}
+
+// parseEthers parses ethers(5) syntax of "MAC-address hostname" with '#' comments and
+// returns a map of MAC address, in net.HardwareAddr.String() form, to hostname. Entries
+// which map to an IP address rather than a hostname are ignored.
+func parseEthers(r io.Reader, path string) (map[string]string, error) {
+	ethers := make(map[string]string)
+	var lineNo int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if ix := strings.IndexByte(line, '#'); ix >= 0 {
+			line = line[:ix]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d MAC address %s has no hostname", path, lineNo, fields[0])
+		}
+		mac, err := parseMAC(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d %w", path, lineNo, err)
+		}
+		if net.ParseIP(fields[1]) == nil {
+			ethers[mac.String()] = fields[1]
+		}
+	}
+
+	return ethers, scanner.Err()
+}
+
+// parseMAC parses a 48-bit MAC address with ':' or '-' separators. Unlike net.ParseMAC,
+// leading zeros can be omitted from each octet, as is common in ethers files, e.g.
+// 8:0:20:1:2:3.
+func parseMAC(s string) (net.HardwareAddr, error) {
+	octets := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '-' })
+	if len(octets) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %s", s)
+	}
+	mac := make(net.HardwareAddr, 0, len(octets))
+	for _, o := range octets {
+		if len(o) == 1 {
+			o = "0" + o
+		}
+		b, err := hex.DecodeString(o)
+		if err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("invalid MAC address %s", s)
+		}
+		mac = append(mac, b[0])
+	}
+
+	return mac, nil
+}
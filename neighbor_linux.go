@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+package autoreverse
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+// Neighbor attribute types and states from linux/neighbour.h
+const (
+	ndaDst        = 1
+	ndaLLAddr     = 2
+	nudIncomplete = 0x01
+	nudFailed     = 0x20
+	nudNoARP      = 0x40
+	sizeofNdMsg   = 12
+)
+
+// netlinkNeighbors dumps the ipv4 and ipv6 neighbor tables via netlink.
+func netlinkNeighbors() ([]neighbor, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNeighMessages(msgs), nil
+}
+
+// parseNeighMessages extracts the neighbors from RTM_NEWNEIGH messages. Each message is an
+// ndmsg followed by route attributes. Incomplete, failed and NOARP entries, such as
+// multicast groups, are excluded as are entries without a 48-bit link layer address.
+func parseNeighMessages(msgs []syscall.NetlinkMessage) (neighbors []neighbor) {
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < sizeofNdMsg {
+			continue
+		}
+		state := binary.NativeEndian.Uint16(m.Data[8:10])
+		if state&(nudIncomplete|nudFailed|nudNoARP) != 0 {
+			continue
+		}
+		var n neighbor
+		for b := m.Data[sizeofNdMsg:]; len(b) >= syscall.SizeofRtAttr; {
+			attrLen := int(binary.NativeEndian.Uint16(b[0:2]))
+			attrType := binary.NativeEndian.Uint16(b[2:4])
+			if attrLen < syscall.SizeofRtAttr || attrLen > len(b) {
+				break
+			}
+			value := b[syscall.SizeofRtAttr:attrLen]
+			switch attrType {
+			case ndaDst:
+				if len(value) == net.IPv4len || len(value) == net.IPv6len {
+					n.ip = net.IP(append([]byte{}, value...))
+				}
+			case ndaLLAddr:
+				if len(value) == 6 {
+					n.mac = net.HardwareAddr(append([]byte{}, value...))
+				}
+			}
+			attrLen = (attrLen + syscall.RTA_ALIGNTO - 1) &^ (syscall.RTA_ALIGNTO - 1)
+			if attrLen > len(b) {
+				break
+			}
+			b = b[attrLen:]
+		}
+		if n.ip != nil && n.mac != nil {
+			neighbors = append(neighbors, n)
+		}
+	}
+
+	return
+}
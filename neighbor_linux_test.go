@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package autoreverse
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// neighMessage constructs an RTM_NEWNEIGH message with the state and attributes.
+func neighMessage(state uint16, attrs map[uint16][]byte) syscall.NetlinkMessage {
+	data := make([]byte, sizeofNdMsg)
+	binary.NativeEndian.PutUint16(data[8:10], state)
+	for _, attrType := range []uint16{ndaDst, ndaLLAddr} { // Map order is random
+		value, ok := attrs[attrType]
+		if !ok {
+			continue
+		}
+		attr := make([]byte, syscall.SizeofRtAttr+len(value))
+		binary.NativeEndian.PutUint16(attr[0:2], uint16(len(attr)))
+		binary.NativeEndian.PutUint16(attr[2:4], attrType)
+		copy(attr[syscall.SizeofRtAttr:], value)
+		for len(attr)%syscall.RTA_ALIGNTO != 0 {
+			attr = append(attr, 0)
+		}
+		data = append(data, attr...)
+	}
+
+	return syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWNEIGH}, Data: data}
+}
+
+func TestParseNeighMessages(t *testing.T) {
+	mac := []byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	v4 := []byte{192, 0, 2, 10}
+	v6 := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0x9c, 0x3e, 0x61, 0xd2, 0x7a, 0xb0, 0x11, 0xf4}
+	const nudReachable, nudStale = 0x02, 0x04
+
+	msgs := []syscall.NetlinkMessage{
+		neighMessage(nudReachable, map[uint16][]byte{ndaDst: v4, ndaLLAddr: mac}),
+		neighMessage(nudStale, map[uint16][]byte{ndaDst: v6, ndaLLAddr: mac}),
+		neighMessage(nudIncomplete, map[uint16][]byte{ndaDst: v4}),
+		neighMessage(nudFailed, map[uint16][]byte{ndaDst: v4, ndaLLAddr: mac}),
+		neighMessage(nudNoARP, map[uint16][]byte{ndaDst: v6, ndaLLAddr: mac}),
+		neighMessage(nudReachable, map[uint16][]byte{ndaDst: v4, ndaLLAddr: mac[:4]}), // Not Ethernet
+		{Header: syscall.NlMsghdr{Type: syscall.RTM_NEWNEIGH}, Data: []byte{1, 2}},    // Truncated
+		{Header: syscall.NlMsghdr{Type: syscall.NLMSG_DONE}},
+	}
+	neighbors := parseNeighMessages(msgs)
+	if len(neighbors) != 2 {
+		t.Fatal("Expected two neighbors, not", neighbors)
+	}
+	if neighbors[0].ip.String() != "192.0.2.10" || neighbors[0].mac.String() != "52:54:00:12:34:56" {
+		t.Error("Wrong ipv4 neighbor", neighbors[0])
+	}
+	if neighbors[1].ip.String() != "2001:db8::9c3e:61d2:7ab0:11f4" {
+		t.Error("Wrong ipv6 neighbor", neighbors[1])
+	}
+
+	// A malformed attribute length stops attribute parsing without panicking
+	bad := neighMessage(nudReachable, map[uint16][]byte{ndaDst: v4, ndaLLAddr: mac})
+	binary.NativeEndian.PutUint16(bad.Data[sizeofNdMsg:], 200)
+	if n := parseNeighMessages([]syscall.NetlinkMessage{bad}); len(n) != 0 {
+		t.Error("Malformed message produced", n)
+	}
+}
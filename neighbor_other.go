@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package autoreverse
+
+import "errors"
+
+// netlinkNeighbors is only supported on Linux. Elsewhere, the neighbor:// URL must name a
+// file containing the neighbor table.
+func netlinkNeighbors() ([]neighbor, error) {
+	return nil, errors.New("neighbor tables can only be read via netlink on Linux")
+}
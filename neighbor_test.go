@@ -0,0 +1,238 @@
+package autoreverse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+)
+
+func TestParseMAC(t *testing.T) {
+	testCases := []struct {
+		in  string
+		out string
+	}{
+		{"52:54:00:12:34:56", "52:54:00:12:34:56"},
+		{"52:54:00:AB:CD:EF", "52:54:00:ab:cd:ef"},
+		{"8:0:20:1:2:3", "08:00:20:01:02:03"},
+		{"52-54-00-98-98-98", "52:54:00:98:98:98"},
+		{"52:54:00:12:34", ""},
+		{"52:54:00:12:34:56:78", ""},
+		{"52:54:00:12:34:5g", ""},
+		{"52:54:00:12:34:567", ""},
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		mac, err := parseMAC(tc.in)
+		if len(tc.out) == 0 {
+			if err == nil {
+				t.Error("Expected error for", tc.in, "not", mac)
+			}
+			continue
+		}
+		if err != nil || mac.String() != tc.out {
+			t.Error("parseMAC", tc.in, "returned", mac, err)
+		}
+	}
+}
+
+func TestParseEthers(t *testing.T) {
+	f, err := os.Open("testdata/neighbor/ethers")
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	defer f.Close()
+	ethers, err := parseEthers(f, "ethers")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(ethers) != 4 || ethers["08:00:20:01:02:03"] != "gateway" ||
+		ethers["52:54:00:ab:cd:ef"] != "printer.example.org" {
+		t.Error("Wrong ethers", ethers)
+	}
+
+	for _, bad := range []struct{ line, err string }{
+		{"52:54:00:12:34:56", "ethers:1 MAC address 52:54:00:12:34:56 has no hostname"},
+		{"52:54:00:12:34 host", "ethers:1 invalid MAC address 52:54:00:12:34"},
+	} {
+		_, err := parseEthers(strings.NewReader(bad.line), "ethers")
+		if err == nil || err.Error() != bad.err {
+			t.Error("Expected", bad.err, "not", err)
+		}
+	}
+}
+
+func TestParseNeighbors(t *testing.T) {
+	testCases := []struct {
+		file string
+		ips  string
+	}{
+		{"arp", "192.0.2.10 192.0.2.11 192.0.2.13 198.51.100.1"},
+		{"ip-neigh", "192.0.2.10 2001:db8::5054:ff:fe12:3456 2001:db8::9c3e:61d2:7ab0:11f4 " +
+			"2001:db8::1 2001:db8::99 fe80::1"},
+	}
+	for _, tc := range testCases {
+		neighbors, err := readNeighbors(filepath.Join("testdata/neighbor", tc.file))
+		if err != nil {
+			t.Error(tc.file, "Unexpected error", err)
+			continue
+		}
+		var ips []string
+		for _, n := range neighbors {
+			ips = append(ips, n.ip.String())
+		}
+		if strings.Join(ips, " ") != tc.ips {
+			t.Error(tc.file, "Wrong neighbors", ips)
+		}
+	}
+
+	for _, bad := range []struct{ line, err string }{
+		{"192.0.2 dev eth0 lladdr 52:54:00:12:34:56 REACHABLE", "neigh:1 invalid address 192.0.2"},
+		{"192.0.2.1 dev eth0 lladdr 52:54:00:12:34 REACHABLE", "neigh:1 invalid MAC address 52:54:00:12:34"},
+		{"192.0.2.1  0x1  0xZ  52:54:00:12:34:56  *  eth0", "neigh:1 invalid flags 0xZ"},
+	} {
+		_, err := parseNeighbors(strings.NewReader(bad.line), "neigh")
+		if err == nil || err.Error() != bad.err {
+			t.Error("Expected", bad.err, "not", err)
+		}
+	}
+}
+
+func TestNeighborSourceURL(t *testing.T) {
+	testCases := []struct {
+		url      string
+		err      string
+		describe string
+	}{
+		{"neighbor://?ethers=/etc/ethers", "", "neighbor netlink /etc/ethers"},
+		{"neighbor:///./testdata/neighbor/arp?ethers=/etc/ethers&interval=5s", "",
+			"neighbor ./testdata/neighbor/arp /etc/ethers"},
+		{"neighbor://localhost/proc/net/arp?ethers=/etc/ethers", "cannot contain a host", ""},
+		{"neighbor:///proc/net/arp", "must contain an ethers parameter", ""},
+		{"neighbor://?ethers=/etc/ethers&interval=0s", "interval '0s' is not a positive duration", ""},
+	}
+	for ix, tc := range testCases {
+		pz, err := newPTRZoneFromURL(nil, tc.url)
+		if len(tc.err) == 0 {
+			if err != nil {
+				t.Error(ix, "Unexpected error", err)
+			} else if pz.source.Describe() != tc.describe {
+				t.Error(ix, "Wrong description", pz.source.Describe())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(ix, "Expected", tc.err, "not", err)
+		}
+	}
+}
+
+func TestNeighborSourceLoad(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	ar, pz := loadHTTPZone(t,
+		"neighbor:///./testdata/neighbor/ip-neigh?ethers=testdata/neighbor/ethers&domain=example.net")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	db := ar.dbGetter.Current()
+	if db.Count() != 4 || pz.added != 4 || pz.oob != 1 {
+		t.Error("Wrong counts", db.Count(), pz.added, pz.oob, out.String())
+	}
+	for ip, name := range map[string]string{
+		"192.0.2.10":                    "laptop.example.net.",
+		"2001:db8::5054:ff:fe12:3456":   "laptop.example.net.",
+		"2001:db8::9c3e:61d2:7ab0:11f4": "laptop.example.net.", // Privacy address
+		"2001:db8::1":                   "gateway.example.net.",
+	} {
+		ptrs := dbLookupIP(db, ip)
+		if len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != name || ptrs[0].Header().Ttl != 61 {
+			t.Error("Wrong PTR for", ip, ptrs)
+		}
+	}
+
+	ar, pz = loadHTTPZone(t, "neighbor:///./testdata/neighbor/arp?ethers=testdata/neighbor/ethers")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	ptrs := dbLookupIP(ar.dbGetter.Current(), "192.0.2.11")
+	if pz.added != 1 || pz.oob != 0 || len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != "printer.example.org." {
+		t.Error("Bare names should be dropped without a domain", pz.added, pz.oob, ptrs)
+	}
+}
+
+func TestNeighborSourceWatch(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	dir := t.TempDir()
+	table := filepath.Join(dir, "neigh")
+	ethers := filepath.Join(dir, "ethers")
+	os.WriteFile(table, []byte("192.0.2.10 dev eth0 lladdr 52:54:00:12:34:56 REACHABLE\n"), 0600)
+	os.WriteFile(ethers, []byte("52:54:00:12:34:56 laptop\n52:54:00:ab:cd:ef printer\n"), 0600)
+
+	ar, pz := loadHTTPZone(t, "neighbor://"+table+"?ethers="+ethers+"&domain=example.net&interval=10ms")
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	ns := pz.source.(*neighborSource)
+
+	reasons := make(chan string, 100)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		ns.Watch(done, func(reason string) { reasons <- reason })
+		close(finished)
+	}()
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case reason := <-reasons:
+			if reason != want {
+				t.Error("Wrong reason", reason, "expected", want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("No reload for", want)
+		}
+	}
+
+	// A state change of a known address or an unnamed arrival is not a change
+	os.WriteFile(table, []byte("192.0.2.10 dev eth0 lladdr 52:54:00:12:34:56 STALE\n"+
+		"192.0.2.99 dev eth0 lladdr 52:54:00:99:99:99 REACHABLE\n"), 0600)
+	select {
+	case reason := <-reasons:
+		t.Error("Unexpected reload", reason)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	os.WriteFile(table, []byte("192.0.2.11 dev eth0 lladdr 52:54:00:ab:cd:ef REACHABLE\n"), 0600)
+	expect("Neighbor change")
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	for len(reasons) > 0 { // Drain any polls made prior to the reload
+		<-reasons
+	}
+	ptrs := dbLookupIP(ar.dbGetter.Current(), "192.0.2.11")
+	if len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != "printer.example.net." {
+		t.Error("Arrival not loaded", ptrs)
+	}
+
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(ethers, future, future)
+	expect("DTM of " + ethers)
+
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Watch did not return when done closed")
+	}
+}
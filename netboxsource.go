@@ -0,0 +1,239 @@
+package autoreverse
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// The netbox:// URL query parameters consumed by autoreverse. All other parameters are
+// passed thru to NetBox as ip-addresses filters, such as ?status=active&tag=dns.
+const (
+	netboxIntervalParam = "interval"
+
+	netboxAPIPath         = "/api/ipam/ip-addresses/"
+	netboxDefaultInterval = time.Minute * 10
+	netboxPageLimit       = "1000" // The NetBox default MAX_PAGE_SIZE
+	netboxRequestTimeout  = time.Second * 30
+)
+
+// netboxSource is the netbox:// source which creates PTRs from the dns_name of NetBox
+// IPAM ip-address objects, e.g. netbox://netbox.example.net/?auth=/etc/netbox.token. Any
+// URL path is the prefix of the NetBox API path. The list is limited to the reverse zones
+// of authority with "parent" filters. Each page is retained along with its validators so
+// that refreshes are conditional requests.
+type netboxSource struct {
+	fetchURL string // First page with all filters
+	describe string
+	auth     string // Authorization header value, if any
+	domain   string // Qualifies bare dns_names
+	client   *http.Client
+	interval time.Duration
+	parents  []string // From Scope
+	loadTime time.Time
+	pages    map[string]*netboxPage // Keyed by page URL
+}
+
+// netboxPage is a page of a previous load.
+type netboxPage struct {
+	etag, lastModified string
+	body               []byte
+}
+
+type netboxList struct {
+	Next    string
+	Results []struct {
+		Address string
+		DNSName string `json:"dns_name"`
+	}
+}
+
+// newNetboxSource reads all files immediately which means prior to --chroot processing.
+func newNetboxSource(u *url.URL) (ptrsource.Source, error) {
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf(u.Scheme + " URL must contain a host name")
+	}
+	q := u.Query()
+	t := &netboxSource{domain: q.Get("domain"), interval: netboxDefaultInterval}
+	if len(t.domain) > 0 {
+		t.domain = dns.CanonicalName(t.domain)
+	}
+	if v := q.Get(netboxIntervalParam); len(v) > 0 {
+		var err error
+		t.interval, err = time.ParseDuration(v)
+		if err != nil || t.interval <= 0 {
+			return nil, fmt.Errorf(u.Scheme+" URL %s '%s' is not a positive duration",
+				netboxIntervalParam, v)
+		}
+	}
+
+	if path := q.Get(httpAuthParam); len(path) > 0 {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL %s:%w", httpAuthParam, err)
+		}
+		token := strings.TrimSpace(string(b))
+		if len(token) == 0 {
+			return nil, fmt.Errorf(u.Scheme+" URL %s file %s is empty", httpAuthParam, path)
+		}
+		if strings.HasPrefix(token, "nbt_") { // v2 tokens are bearer tokens
+			t.auth = "Bearer " + token
+		} else {
+			t.auth = "Token " + token
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if path := q.Get(httpCAParam); len(path) > 0 {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf(u.Scheme+" URL %s:%w", httpCAParam, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf(u.Scheme+" URL %s %s contains no PEM certificates", httpCAParam, path)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	t.client = &http.Client{Transport: transport}
+
+	for _, p := range []string{httpAuthParam, httpCAParam, netboxIntervalParam, "domain", maxStaleParam} {
+		q.Del(p)
+	}
+	if !q.Has("limit") {
+		q.Set("limit", netboxPageLimit)
+	}
+	fetch := url.URL{Scheme: "https", Host: u.Host,
+		Path: strings.TrimSuffix(u.Path, "/") + netboxAPIPath, RawQuery: q.Encode()}
+	t.fetchURL = fetch.String()
+	t.describe = "netbox " + fetch.Host + fetch.Path
+
+	return t, nil
+}
+
+// Scope converts the reverse CIDRs to parent filters which NetBox ORs together.
+func (t *netboxSource) Scope(cidrs []*net.IPNet) {
+	t.parents = t.parents[:0]
+	for _, cidr := range cidrs {
+		t.parents = append(t.parents, cidr.String())
+	}
+}
+
+// Load fetches all pages of ip-addresses and adds a PTR for each address with a dns_name.
+// A page which is Not Modified is re-used from the previous load. Pages are only retained
+// once all pages load, as a partial set could otherwise be re-used.
+func (t *netboxSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	t.loadTime = time.Now()
+	first, err := url.Parse(t.fetchURL)
+	if err != nil {
+		return err
+	}
+	q := first.Query()
+	for _, p := range t.parents {
+		q.Add("parent", p)
+	}
+	first.RawQuery = q.Encode()
+
+	pages := make(map[string]*netboxPage)
+	for next := first.String(); len(next) > 0; {
+		page, err := t.fetch(next)
+		if err != nil {
+			return err
+		}
+		pages[next] = page
+
+		var list netboxList
+		err = json.Unmarshal(page.body, &list)
+		if err != nil {
+			return fmt.Errorf("NetBox %s:%w", next, err)
+		}
+		for _, r := range list.Results {
+			ip, _, err := net.ParseCIDR(r.Address)
+			if err != nil {
+				return fmt.Errorf("NetBox %s:%w", next, err)
+			}
+			name := qualifyName(r.DNSName, t.domain)
+			if len(name) > 0 {
+				sink.AddAddress(ip, name, defaultTTL)
+			}
+		}
+		if _, seen := pages[list.Next]; seen {
+			return fmt.Errorf("NetBox %s next page loops back to %s", next, list.Next)
+		}
+		next = list.Next
+	}
+	t.pages = pages
+
+	return nil
+}
+
+// fetch GETs a page, conditionally if it was retained from the previous load.
+func (t *netboxSource) fetch(pageURL string) (*netboxPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), netboxRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(t.auth) > 0 {
+		req.Header.Set("Authorization", t.auth)
+	}
+	prev := t.pages[pageURL]
+	if prev != nil {
+		if len(prev.etag) > 0 {
+			req.Header.Set("If-None-Match", prev.etag)
+		}
+		if len(prev.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", prev.lastModified)
+		}
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && prev != nil:
+		return prev, nil
+	case resp.StatusCode == http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &netboxPage{etag: resp.Header.Get("ETag"),
+			lastModified: resp.Header.Get("Last-Modified"), body: body}, nil
+	}
+
+	return nil, errors.New(resp.Status)
+}
+
+// NeedsReload returns a reason once the interval has passed since the last load.
+func (t *netboxSource) NeedsReload(now time.Time) string {
+	if now.After(t.loadTime.Add(t.interval)) {
+		return "Expired interval"
+	}
+
+	return ""
+}
+
+func (t *netboxSource) Describe() string {
+	return t.describe
+}
@@ -0,0 +1,187 @@
+package autoreverse
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+)
+
+// netboxServer is a fake NetBox which serves ip-addresses in two pages, each with an ETag.
+type netboxServer struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	queries     []string // Of each request
+	notModified int
+	version     int // Changing invalidates the ETags
+}
+
+func (t *netboxServer) ServeHTTP(wtr http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Token 0123456789abcdef" {
+		http.Error(wtr, "Invalid token", http.StatusForbidden)
+		return
+	}
+	if req.URL.Path != "/netbox/api/ipam/ip-addresses/" {
+		http.NotFound(wtr, req)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queries = append(t.queries, req.URL.RawQuery)
+
+	offset := req.URL.Query().Get("offset")
+	etag := fmt.Sprintf(`"%d-%s"`, t.version, offset)
+	if req.Header.Get("If-None-Match") == etag {
+		t.notModified++
+		wtr.WriteHeader(http.StatusNotModified)
+		return
+	}
+	wtr.Header().Set("ETag", etag)
+	if offset == "" {
+		next := t.srv.URL + req.URL.Path + "?" + req.URL.RawQuery + "&offset=3"
+		fmt.Fprintf(wtr, `{"count":5,"next":"%s","results":[
+ {"address":"192.0.2.20/24","dns_name":"router.example.net"},
+ {"address":"2001:db8::20/64","dns_name":"router.example.net"},
+ {"address":"192.0.2.21/24","dns_name":""}]}`, next)
+		return
+	}
+	dnsName := "printer"
+	if t.version > 0 {
+		dnsName = "laser"
+	}
+	fmt.Fprintf(wtr, `{"count":5,"next":null,"results":[
+ {"address":"192.0.2.22/24","dns_name":"%s"},
+ {"address":"198.51.100.22/24","dns_name":"elsewhere.example.net"}]}`, dnsName)
+}
+
+// startNetbox returns the fake NetBox along with a URL which trusts its certificate and
+// presents a valid token.
+func startNetbox(t *testing.T) (*netboxServer, string) {
+	t.Helper()
+	nb := &netboxServer{}
+	nb.srv = httptest.NewTLSServer(nb)
+	t.Cleanup(nb.srv.Close)
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: nb.srv.Certificate().Raw}), 0600)
+	token := filepath.Join(dir, "token")
+	os.WriteFile(token, []byte("0123456789abcdef\n"), 0600)
+
+	return nb, "netbox://" + nb.srv.Listener.Addr().String() + "/netbox/?auth=" + token +
+		"&tls-ca=" + ca + "&domain=example.net&status=active"
+}
+
+func TestNetboxSourceURL(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty")
+	os.WriteFile(empty, nil, 0600)
+	testCases := []struct {
+		url string
+		err string
+	}{
+		{"netbox:///api", "must contain a host name"},
+		{"netbox://netbox.example.net?interval=soon", "interval 'soon' is not a positive duration"},
+		{"netbox://netbox.example.net?interval=-1m", "is not a positive duration"},
+		{"netbox://netbox.example.net?auth=" + empty, "is empty"},
+		{"netbox://netbox.example.net?auth=/nonexistent", "no such file"},
+		{"netbox://netbox.example.net?tls-ca=" + empty, "contains no PEM certificates"},
+	}
+	for ix, tc := range testCases {
+		_, err := newPTRZoneFromURL(nil, tc.url)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(ix, "Expected", tc.err, "not", err)
+		}
+	}
+
+	pz, err := newPTRZoneFromURL(nil, "netbox://netbox.example.net:8443/?interval=1m&tag=dns&max-stale=1h")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	ns := pz.source.(*netboxSource)
+	if ns.fetchURL != "https://netbox.example.net:8443/api/ipam/ip-addresses/?limit=1000&tag=dns" {
+		t.Error("Wrong fetch URL", ns.fetchURL)
+	}
+	if ns.Describe() != "netbox netbox.example.net:8443/api/ipam/ip-addresses/" {
+		t.Error("Wrong description", ns.Describe())
+	}
+	now := time.Now()
+	ns.loadTime = now
+	if reason := ns.NeedsReload(now.Add(time.Second * 59)); len(reason) > 0 {
+		t.Error("Premature reload", reason)
+	}
+	if reason := ns.NeedsReload(now.Add(time.Second * 61)); reason != "Expired interval" {
+		t.Error("Interval did not trigger", reason)
+	}
+}
+
+func TestNetboxSourceLoad(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	nb, nbURL := startNetbox(t)
+	ar, pz := loadHTTPZone(t, nbURL)
+	a := &authority{}
+	a.Domain = "2.0.192.in-addr.arpa."
+	_, a.cidr, _ = net.ParseCIDR("192.0.2.0/24")
+	ar.addAuthority(a)
+	ar.authorities.sort()
+
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	db := ar.dbGetter.Current()
+	if db.Count() != 3 || pz.added != 3 || pz.oob != 1 {
+		t.Error("Wrong counts", db.Count(), pz.added, pz.oob, out.String())
+	}
+	for ip, name := range map[string]string{
+		"192.0.2.20":   "router.example.net.",
+		"2001:db8::20": "router.example.net.",
+		"192.0.2.22":   "printer.example.net.",
+	} {
+		ptrs := dbLookupIP(db, ip)
+		if len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != name {
+			t.Error("Wrong PTR for", ip, ptrs)
+		}
+	}
+	if len(nb.queries) != 2 || nb.queries[0] != "limit=1000&parent=192.0.2.0%2F24&status=active" {
+		t.Error("Wrong filters", nb.queries)
+	}
+
+	// Unchanged pages are re-used
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Reload failed", out.String())
+	}
+	if nb.notModified != 2 || ar.dbGetter.Current().Count() != 3 {
+		t.Error("Pages not conditionally requested", nb.notModified, ar.dbGetter.Current().Count())
+	}
+
+	// Changed pages are not
+	nb.version++
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Reload failed", out.String())
+	}
+	ptrs := dbLookupIP(ar.dbGetter.Current(), "192.0.2.22")
+	if nb.notModified != 2 || len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != "laser.example.net." {
+		t.Error("Changed page not used", nb.notModified, ptrs)
+	}
+
+	// An untrusted certificate fails the initial load
+	ar, _ = loadHTTPZone(t, "netbox://"+nb.srv.Listener.Addr().String()+"/netbox/")
+	if ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Error("Load should fail with an untrusted certificate")
+	}
+}
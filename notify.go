@@ -0,0 +1,110 @@
+package autoreverse
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnsutil"
+	"github.com/markdingo/autoreverse/log"
+)
+
+// notifySource is an axfr:// --PTR-deduce zone and the addresses of its primary. NOTIFY
+// messages for the zone are only honoured if they originate from one of these addresses.
+type notifySource struct {
+	domain string
+	addrs  []net.IP
+}
+
+// resolveNotifySources returns a notifySource for each axfr:// PTRZone. The primary name
+// server is resolved once at startup, so a primary which renumbers requires a restart for
+// its NOTIFY messages to be honoured. Resolution failures are logged and that zone
+// simply relies on its SOA Refresh, as it did prior to NOTIFY support.
+func (t *autoReverse) resolveNotifySources(pzs []*PTRZone) (nss []*notifySource) {
+	for _, pz := range pzs {
+		as, ok := pz.source.(*axfrSource)
+		if !ok {
+			continue
+		}
+		ns := &notifySource{domain: as.domain}
+		if ip := net.ParseIP(as.host); ip != nil {
+			ns.addrs = append(ns.addrs, ip)
+		} else {
+			addrs, err := t.resolver.LookupIPAddr(context.Background(), as.host)
+			if err != nil {
+				warning(dnsutil.ShortenLookupError(err),
+					"NOTIFY disabled for "+as.domain+" as primary did not resolve:"+as.host)
+				continue
+			}
+			ns.addrs = addrs
+		}
+		nss = append(nss, ns)
+	}
+
+	return
+}
+
+// serveNotify handles an RFC1996 NOTIFY for an axfr:// --PTR-deduce zone. A NOTIFY from
+// one of the zone's primary addresses is acknowledged and triggers an immediate reload
+// rather than waiting for the SOA Refresh to expire. Reloads are coalesced, so a burst of
+// NOTIFYs received while a reload is already pending only results in a single reload.
+//
+// RFC1996 Section 3.10 suggests an unknown source be ignored, but like most name servers
+// we respond with Refused so the sender is not left retrying.
+func (t *server) serveNotify(wtr dns.ResponseWriter, req *request) {
+	malformed := ""
+	if req.question.Qtype != dns.TypeSOA || req.question.Qclass != dns.ClassINET {
+		malformed = "NOTIFY not SOA/IN"
+	} else if len(req.query.Answer) == 1 {
+		if _, ok := req.query.Answer[0].(*dns.SOA); !ok {
+			malformed = "NOTIFY Answer not SOA"
+		}
+	}
+	if len(malformed) > 0 { // serveFormErr() is not used as it resets the Opcode
+		req.addNote(malformed)
+		req.stats.gen.badRequest++
+		req.response.SetRcode(req.query, dns.RcodeFormatError)
+		t.writeMsg(wtr, req)
+		return
+	}
+
+	ip := req.srcIP()
+	known := false
+	for _, ns := range req.notifySources {
+		if ns.domain != req.qName {
+			continue
+		}
+		known = true
+		for _, addr := range ns.addrs {
+			if addr.Equal(ip) {
+				req.response.SetReply(req.query)
+				t.writeMsg(wtr, req)
+				req.addNote("NOTIFY")
+				t.triggerReload("NOTIFY " + ns.domain + " from " + ip.String())
+				return
+			}
+		}
+	}
+
+	if known {
+		log.Minorf("NOTIFY for %s from %s ignored as not a primary", req.qName, req.src)
+		req.addNote("NOTIFY not from primary")
+	} else {
+		req.addNote("NOTIFY unknown zone")
+	}
+	t.serveRefused(wtr, req)
+}
+
+// triggerReload asks the zone watcher to reload without waiting for it to accept the
+// request. If a reload is already pending, this trigger is dropped as the pending reload
+// satisfies it.
+func (t *server) triggerReload(trigger string) {
+	if t.reload == nil {
+		return
+	}
+	select {
+	case t.reload <- trigger:
+	default:
+	}
+}
@@ -0,0 +1,127 @@
+package autoreverse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnsutil"
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+)
+
+func TestNotifyResolveSources(t *testing.T) {
+	ar := newAutoReverse(nil, nil)
+	var pzs []*PTRZone
+	for _, u := range []string{"axfr://127.0.0.2/example.net", "file:///./testdata/example.net",
+		"axfr://[2001:db8::1]:5353/2.0.192.in-addr.arpa"} {
+		pz, err := newPTRZoneFromURL(ar.resolver, u)
+		if err != nil {
+			t.Fatal("Setup", u, err)
+		}
+		pzs = append(pzs, pz)
+	}
+
+	nss := ar.resolveNotifySources(pzs)
+	if len(nss) != 2 {
+		t.Fatal("Expected two axfr:// sources, not", len(nss))
+	}
+	if nss[0].domain != "example.net." || len(nss[0].addrs) != 1 || nss[0].addrs[0].String() != "127.0.0.2" {
+		t.Error("Wrong first source", nss[0].domain, nss[0].addrs)
+	}
+	if nss[1].domain != "2.0.192.in-addr.arpa." || nss[1].addrs[0].String() != "2001:db8::1" {
+		t.Error("Wrong second source", nss[1].domain, nss[1].addrs)
+	}
+}
+
+func TestNotifyServe(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	ar, srv := newTransferServer(t, dnsutil.UDPNetwork)
+	srv.reload = ar.reload
+	wtr := &mock.ResponseWriter{} // Source is always 127.0.0.2
+	srv.cfg.logQueriesFlag = true
+	var pzs []*PTRZone
+	for _, u := range []string{"axfr://127.0.0.2/example.net", "axfr://192.0.2.1/example.com",
+		"axfr://127.0.0.3/example.org", "axfr://127.0.0.2/example.org"} {
+		pz, err := newPTRZoneFromURL(ar.resolver, u)
+		if err != nil {
+			t.Fatal("Setup", u, err)
+		}
+		pzs = append(pzs, pz)
+	}
+	srv.setNotifySources(ar.resolveNotifySources(pzs))
+
+	testCases := []struct {
+		qType  uint16
+		qName  string
+		answer string
+		rCode  int
+		reload bool
+		note   string
+	}{
+		{dns.TypeSOA, "example.net.", "", dns.RcodeSuccess, true, "NOTIFY"},
+		{dns.TypeSOA, "example.org.", "example.org. IN SOA ns. mbox. 9 0 0 0 0", dns.RcodeSuccess, true, "NOTIFY"},
+		{dns.TypeSOA, "example.com.", "", dns.RcodeRefused, false, "not from primary"},
+		{dns.TypeSOA, "example.edu.", "", dns.RcodeRefused, false, "unknown zone"},
+		{dns.TypeA, "example.net.", "", dns.RcodeFormatError, false, "not SOA"},
+		{dns.TypeSOA, "example.net.", "example.net. IN A 192.0.2.1", dns.RcodeFormatError, false, "not SOA"},
+	}
+
+	for ix, tc := range testCases {
+		out.Reset()
+		query := setQuestion(dns.ClassINET, tc.qType, tc.qName)
+		query.Opcode = dns.OpcodeNotify
+		query.Authoritative = true
+		if len(tc.answer) > 0 {
+			query.Answer = append(query.Answer, newRR(tc.answer))
+		}
+		srv.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to NOTIFY")
+		}
+		if resp.Rcode != tc.rCode {
+			t.Error(ix, "Wrong rcode", dnsutil.RcodeToString(resp.Rcode))
+		}
+		if resp.Opcode != dns.OpcodeNotify || !resp.Response {
+			t.Error(ix, "Response is not a NOTIFY reply", resp.MsgHdr)
+		}
+		if tc.rCode == dns.RcodeSuccess {
+			if !resp.Authoritative || len(resp.Question) != 1 || len(resp.Answer) != 0 {
+				t.Error(ix, "NOTIFY reply not as per RFC1996", resp)
+			}
+		}
+		select {
+		case trigger := <-ar.reload:
+			if !tc.reload {
+				t.Error(ix, "Unexpected reload", trigger)
+			} else if !strings.Contains(trigger, tc.qName) || !strings.Contains(trigger, "127.0.0.2") {
+				t.Error(ix, "Wrong reload trigger", trigger)
+			}
+		default:
+			if tc.reload {
+				t.Error(ix, "Expected reload trigger")
+			}
+		}
+		if !strings.Contains(out.String(), tc.note) {
+			t.Error(ix, "Log note missing", tc.note)
+		}
+	}
+
+	// A burst of NOTIFYs must not block and must coalesce into one pending reload
+	for ix := 0; ix < 3; ix++ {
+		query := setQuestion(dns.ClassINET, dns.TypeSOA, "example.net.")
+		query.Opcode = dns.OpcodeNotify
+		srv.ServeDNS(wtr, query)
+	}
+	<-ar.reload
+	select {
+	case trigger := <-ar.reload:
+		t.Error("Reloads did not coalesce", trigger)
+	default:
+	}
+}
@@ -0,0 +1,25 @@
+/*
+Package ptrsource defines the interface between autoreverse and the sources of
+--PTR-deduce data, along with a registry of Source factories keyed by URL scheme.
+
+The built-in schemes (file, http, axfr and so on) are implementations of Source within
+autoreverse itself. Additional sources, such as an internal CMDB, are made available to
+--PTR-deduce by registering a Factory for a new scheme prior to calling autoreverse.Main
+from the embedder's own main package:
+
+	func main() {
+	    ptrsource.Register("cmdb", func(u *url.URL) (ptrsource.Source, error) {
+	        return newCMDBSource(u)
+	    })
+	    autoreverse.Main()
+	}
+
+after which "--PTR-deduce cmdb://cmdb.example.net/site-a" creates a Source via the
+factory. Built-in schemes take precedence over registered schemes of the same name.
+
+Each load presents a Sink to Source.Load which adds the deduced PTRs to the candidate
+database, subject to the same in-domain checks and accounting as the built-in sources.
+Load, NeedsReload, Describe and Scope are only ever called from the one go-routine so a
+Source need not protect its own state, unless it also implements Watcher.
+*/
+package ptrsource
@@ -0,0 +1,101 @@
+package ptrsource
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Sink receives the data of a Source as it loads.
+type Sink interface {
+	// AddRR adds a zone RR. Only SOA, A, AAAA, PTR and CNAME RRs are of interest and
+	// an SOA is only recognized if it is the first RR of the load. Address RRs are
+	// converted to PTRs and CNAMEs are resolved to address RRs.
+	AddRR(rr dns.RR)
+
+	// AddAddress adds the PTR of an address and fully qualified name pair.
+	AddAddress(ip net.IP, name string, ttl uint32)
+}
+
+// Source is a provider of --PTR-deduce data.
+type Source interface {
+	// Load adds all current data to the Sink. As each load populates a fresh database,
+	// every load must supply all data, not just changes since the previous load. An
+	// error abandons the load.
+	Load(sink Sink, defaultTTL uint32) error
+
+	// NeedsReload is called periodically and returns a non-empty reason if the
+	// Source has changed since it was last loaded. now is supplied for the benefit
+	// of tests.
+	NeedsReload(now time.Time) string
+
+	// Describe returns a short description of the Source for logging purposes.
+	Describe() string
+}
+
+// Watcher is optionally implemented by a Source which learns of its own changes, such as
+// from an event stream, rather than relying solely on NeedsReload polling. Watch is
+// called once, in its own go-routine, after the initial load and should call reload each
+// time the Source changes until done is closed. reload never blocks and calls made while
+// a reload is pending are coalesced. As Watch runs concurrently with the other methods,
+// any state they share must be protected by the Source.
+type Watcher interface {
+	Watch(done <-chan struct{}, reload func(reason string))
+}
+
+// Scoper is optionally implemented by a Source which can limit the data it fetches to the
+// reverse zones of authority. Scope is called prior to each Load with the CIDRs of those
+// zones. The Sink discards data outside the CIDRs regardless, so Scope is purely an
+// optimization for Sources with large amounts of unrelated data.
+type Scoper interface {
+	Scope(cidrs []*net.IPNet)
+}
+
+// Factory creates a Source from a --PTR-deduce URL. Errors are reported to the user so
+// they should identify the offending part of the URL.
+type Factory func(u *url.URL) (Source, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a Factory available for the URL scheme. Like database/sql.Register it
+// panics if the scheme is already registered or the factory is nil, as either is a
+// programming error.
+func Register(scheme string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("ptrsource: Register factory is nil for " + scheme)
+	}
+	if _, dup := factories[scheme]; dup {
+		panic(fmt.Sprintf("ptrsource: Register called twice for %s", scheme))
+	}
+	factories[scheme] = factory
+}
+
+// Lookup returns the Factory registered for the scheme or nil.
+func Lookup(scheme string) Factory {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return factories[scheme]
+}
+
+// Schemes returns the sorted list of registered schemes.
+func Schemes() (schemes []string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return
+}
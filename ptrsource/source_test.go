@@ -0,0 +1,43 @@
+package ptrsource
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type nullSource struct{}
+
+func (t *nullSource) Load(sink Sink, defaultTTL uint32) error { return nil }
+func (t *nullSource) NeedsReload(now time.Time) string        { return "" }
+func (t *nullSource) Describe() string                        { return "null" }
+
+func newNullSource(u *url.URL) (Source, error) {
+	return &nullSource{}, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("null", newNullSource)
+	Register("anull", newNullSource)
+	if Lookup("null") == nil || Lookup("anull") == nil {
+		t.Fatal("Registered factory not found")
+	}
+	if Lookup("nonexistent") != nil {
+		t.Error("Unregistered factory found")
+	}
+	if s := strings.Join(Schemes(), ","); s != "anull,null" {
+		t.Error("Wrong schemes", s)
+	}
+
+	for _, f := range []Factory{newNullSource, nil} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected panic")
+				}
+			}()
+			Register("null", f)
+		}()
+	}
+}
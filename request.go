@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
@@ -22,6 +22,7 @@ import (
 // query.
 type request struct {
 	db       *database.Database
+	updates  *updateOverlay // UPDATE overlay of db, nil if not configured
 	query    *dns.Msg
 	response *dns.Msg
 	question dns.Question
@@ -37,6 +38,9 @@ type request struct {
 	nsidOut   string // Output nsid if len > 0
 	cookieOut []byte // If len > 0, this is the entire cookie to add to the out-going OPT
 
+	dnssecOK   bool     // DO bit is set and signing is configured
+	existTypes []uint16 // Types known to exist at qName for NSEC bitmaps in NoError responses
+
 	mutables // Copied from server under mutex protection
 
 	auth *authority // Match for current request
@@ -70,6 +74,19 @@ func newRequest(query *dns.Msg, src net.Addr, network string) *request {
 	return &request{query: query, response: new(dns.Msg), src: src, network: network, rrlAction: rrl.ActionLast}
 }
 
+// srcIP returns the source address of the query or nil if it cannot be determined.
+func (t *request) srcIP() net.IP {
+	if t.src == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(t.src.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
 // addNote does nothing more than append the supplied string to the note slice. That
 // ultimately gets appended to line generated by log()
 func (t *request) addNote(n string) {
@@ -136,6 +153,9 @@ func (t *request) log() {
 	if len(t.serverCookie) > 0 {
 		hFlags = append(hFlags, 's')
 	}
+	if t.dnssecOK {
+		hFlags = append(hFlags, 'd')
+	}
 
 	fmt.Fprintf(log.Out(), "ru=%s q=%s/%s s=%s id=%d h=%s sz=%d/%d C=%d/%d/%d%s\n",
 		rcodeStr, dnsutil.TypeToString(t.question.Qtype), t.logQName,
@@ -149,3 +169,42 @@ func (t *request) log() {
 func (t *request) setAuthority() {
 	t.auth = t.authorities.findInDomain(t.qName)
 }
+
+// soa returns the SOA of the current authority with the serial of the current database
+// generation, if set, so that secondaries notice each reload.
+func (t *request) soa() *dns.SOA {
+	if t.db == nil || t.db.Serial() == 0 {
+		return &t.auth.SOA
+	}
+	soa := dns.Copy(&t.auth.SOA).(*dns.SOA)
+	soa.Serial = t.db.Serial()
+	if t.updates != nil {
+		soa.Serial += t.updates.serial()
+	}
+
+	return soa
+}
+
+// lookupRR is database.LookupRR with PTRs from the UPDATE overlay taking precedence over
+// those in the database.
+func (t *request) lookupRR(qClass, qType uint16, qName string) ([]dns.RR, bool) {
+	if t.updates != nil && qClass == dns.ClassINET && qType == dns.TypePTR {
+		if rrs, ok := t.updates.lookup(qName); ok {
+			_, nxDomain := t.db.LookupRR(qClass, qType, qName)
+			return rrs, nxDomain && len(rrs) == 0
+		}
+	}
+
+	return t.db.LookupRR(qClass, qType, qName)
+}
+
+// ptrSuffix returns the forward domain used to synthesize PTRs in the reverse
+// authority. Reverse authorities normally carry the forward they are mapped to, otherwise
+// the server-wide suffix in mutables applies.
+func (t *request) ptrSuffix(auth *authority) string {
+	if len(auth.ptrSuffix) > 0 {
+		return auth.ptrSuffix
+	}
+
+	return t.mutables.ptrSuffix
+}
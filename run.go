@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
@@ -22,10 +22,19 @@ func (t *autoReverse) Run() {
 
 	for _, a := range t.authorities.slice {
 		log.Major("Zone Authority: ", a.Domain)
+		if t.cfg.dnssecSigner != nil {
+			for _, ds := range t.cfg.dnssecSigner.DS(a.Domain) {
+				log.Major("Zone DS: ", ds.String())
+			}
+		}
 	}
 
 	pzs := t.cfg.PTRZones         // Transfer ownership to watcher (even if there are none)
 	t.cfg.PTRZones = []*PTRZone{} // and make sure it sticks!
+	nss := t.resolveNotifySources(pzs)
+	for _, srv := range t.servers {
+		srv.setNotifySources(nss)
+	}
 	go t.watchForZoneReloads(pzs, reloadInterval)
 
 	fmt.Fprintln(log.Out(), programName, Version, "Ready")
@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"sync"
@@ -19,6 +19,8 @@ type mutables struct {
 	ptrSuffix   string           // String to append to synthesized PTR names
 	probe       delegation.Probe // Current probe if any
 	authorities                  // Forward + all reverse zones of authority
+
+	notifySources []*notifySource // axfr:// zones which accept NOTIFY
 }
 
 // Set mutables under protection of a mutex. This is the only way they should be set.
@@ -30,6 +32,14 @@ func (t *server) setMutables(ps string, pr delegation.Probe, auths authorities)
 	t.mutablesMu.Unlock()
 }
 
+// Set notifySources under protection of a mutex. Separate from setMutables() as they are
+// only known once discovery completes and the PTRZones are handed to the watcher.
+func (t *server) setNotifySources(nss []*notifySource) {
+	t.mutablesMu.Lock()
+	t.notifySources = nss
+	t.mutablesMu.Unlock()
+}
+
 // Get a copy of mutables under protection of a mutex.
 func (t *server) getMutables() mutables {
 	t.mutablesMu.RLock()
@@ -37,6 +47,7 @@ func (t *server) getMutables() mutables {
 	ret.ptrSuffix = t.ptrSuffix
 	ret.probe = t.probe
 	ret.authorities = t.authorities
+	ret.notifySources = t.notifySources
 	t.mutablesMu.RUnlock()
 
 	return ret
@@ -61,6 +72,8 @@ type server struct {
 	stats   serverStats
 
 	cookieSecrets [2]uint64
+	reload        chan<- string  // Non-blocking reload trigger to the zone watcher
+	updates       *updateOverlay // Shared by all servers, nil if UPDATEs are not allowed
 }
 
 func newServer(cfg *config, dbGetter *database.Getter, r resolver.Resolver, rrlHandler *rrl.RRL, network, address string) *server {
@@ -78,6 +91,9 @@ func newServer(cfg *config, dbGetter *database.Getter, r resolver.Resolver, rrlH
 	}
 
 	t.miekg = &dns.Server{Net: t.network, Addr: t.address, ReusePort: true, Handler: t}
+	if len(cfg.tsigKeys) > 0 {
+		t.miekg.TsigSecret = cfg.tsigSecrets()
+	}
 
 	// The miekg.defaultMsgAcceptFunc rejects Server Cookie queries (RFC7873#5.4) as
 	// qdcount==0, so that function has been replaced with our own function with is
@@ -0,0 +1,148 @@
+package autoreverse
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/database"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// builtinSources are the factories of the built-in schemes. Like the registered schemes,
+// each creates a ptrsource.Source which holds all of its scheme-specific state. Built-in
+// schemes take precedence over registered schemes of the same name.
+var builtinSources = map[string]ptrsource.Factory{
+	"file":        newFileSource,
+	"http":        newHTTPSource,
+	"https":       newHTTPSource,
+	"axfr":        newAXFRSource,
+	"ixfr":        newAXFRSource,
+	dhcpdFormat:   newLeaseSource,
+	keaFormat:     newLeaseSource,
+	dnsmasqFormat: newLeaseSource,
+	"hosts":       newHostsSource,
+	csvFormat:     newInventorySource,
+	jsonFormat:    newInventorySource,
+	"exec":        newExecSource,
+	"docker":      newDockerSource,
+	"k8s":         newK8sSource,
+	"netbox":      newNetboxSource,
+	"neighbor":    newNeighborSource,
+}
+
+// zoneSink is the ptrsource.Sink presented to each PTRZone source by loadAllZones. It
+// adds to the candidate database via the PTRZone so that the in-domain checks and the
+// lines/added/oob accounting apply equally to built-in and registered sources.
+type zoneSink struct {
+	pz    *PTRZone
+	db    *database.Database
+	auths authorities
+}
+
+func (t *zoneSink) AddRR(rr dns.RR) {
+	t.pz.addRR(t.db, t.auths, rr)
+}
+
+func (t *zoneSink) AddAddress(ip net.IP, name string, ttl uint32) {
+	t.pz.addAddress(t.db, t.auths, ip, name, ttl)
+}
+
+// countLines adds to the lines of a load for built-in sources which read records rather
+// than RRs, such as hosts files. Sinks other than zoneSink do not count lines.
+func countLines(sink ptrsource.Sink, n int) {
+	if zs, ok := sink.(*zoneSink); ok {
+		zs.pz.lines += n
+	}
+}
+
+// soaRefresh is embedded by the sources of zones which are reloaded once the SOA Refresh
+// of the last load expires.
+type soaRefresh struct {
+	soa      dns.SOA
+	loadTime time.Time
+}
+
+// sink starts a load by noting the load time and returns a Sink which captures the SOA of
+// the zone on its way to the underlying Sink. As with PTRZone.addRR, an SOA is only
+// recognized if it is the first RR.
+func (t *soaRefresh) sink(sink ptrsource.Sink) ptrsource.Sink {
+	t.loadTime = time.Now()
+
+	return &soaSink{Sink: sink, refresh: t}
+}
+
+// refreshExpired returns a reason if the SOA Refresh has expired since the last load.
+func (t *soaRefresh) refreshExpired(now time.Time) string {
+	nextLoad := t.loadTime.Add(time.Second * time.Duration(t.soa.Refresh))
+	if now.After(nextLoad) {
+		return "Expired Refresh"
+	}
+
+	return ""
+}
+
+type soaSink struct {
+	ptrsource.Sink
+	refresh *soaRefresh
+	rrs     int
+}
+
+func (t *soaSink) AddRR(rr dns.RR) {
+	t.rrs++
+	if soa, ok := rr.(*dns.SOA); ok && t.rrs == 1 {
+		t.refresh.soa = *soa
+	}
+	t.Sink.AddRR(rr)
+}
+
+// filePath returns the path of a URL of a file-based scheme after checking that the URL
+// only contains a path.
+func filePath(u *url.URL) (string, error) {
+	path := u.Path
+	if len(path) == 0 {
+		return "", fmt.Errorf(u.Scheme + " URL must contain a file system path")
+	}
+	if len(u.Hostname()) > 0 || len(u.Port()) > 0 {
+		return "", fmt.Errorf(u.Scheme + " URL cannot contain a host or port")
+	}
+
+	// Special case mostly for tests. if path starts with "/./" remove the leading "/"
+	// to make it relative. Otherwise there is no way to specify a relative path in a
+	// file: URL as url.Path always starts at the first byte past the hostname, which
+	// by definition has to be a "/".
+	if strings.HasPrefix(path, "/./") {
+		path = path[1:]
+	}
+
+	return path, nil
+}
+
+// domainParam returns the optional domain query parameter used by qualifyName.
+func domainParam(u *url.URL) string {
+	domain := u.Query().Get("domain")
+	if len(domain) > 0 {
+		domain = dns.CanonicalName(domain)
+	}
+
+	return domain
+}
+
+// dtmChanged returns a reason if the DTM of the file is later than when it was loaded.
+func dtmChanged(path string, dtm time.Time) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		warning(err, "Could not stat zone file:"+path)
+		return ""
+	}
+	if fi.ModTime().After(dtm) {
+		return "DTM"
+	}
+
+	return ""
+}
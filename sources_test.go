@@ -0,0 +1,283 @@
+package autoreverse
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/ptrsource"
+)
+
+// cmdbSource is a registered source as an embedder might write it.
+type cmdbSource struct {
+	site    string
+	fail    bool
+	changed bool
+	loads   int
+}
+
+var testCMDB *cmdbSource // The most recently created source
+
+func (t *cmdbSource) Load(sink ptrsource.Sink, defaultTTL uint32) error {
+	t.loads++
+	if t.fail {
+		return errors.New("CMDB unavailable")
+	}
+	sink.AddRR(&dns.SOA{Hdr: dns.RR_Header{Name: "example.net.", Rrtype: dns.TypeSOA,
+		Class: dns.ClassINET}, Serial: 42, Refresh: 60})
+	sink.AddAddress(net.ParseIP("192.0.2.40"), "db1."+t.site+".example.net.", defaultTTL)
+	sink.AddAddress(net.ParseIP("2001:db8::40"), "db1."+t.site+".example.net.", 30)
+	sink.AddAddress(net.ParseIP("198.51.100.40"), "oob.example.net.", defaultTTL)
+
+	return nil
+}
+
+func (t *cmdbSource) NeedsReload(now time.Time) string {
+	if t.changed {
+		return "CMDB changed"
+	}
+	return ""
+}
+
+func (t *cmdbSource) Describe() string { return "cmdb " + t.site }
+
+func init() {
+	ptrsource.Register("test-cmdb", func(u *url.URL) (ptrsource.Source, error) {
+		site := strings.TrimPrefix(u.Path, "/")
+		if len(site) == 0 {
+			return nil, errors.New("test-cmdb URL must contain a site")
+		}
+		testCMDB = &cmdbSource{site: site}
+		return testCMDB, nil
+	})
+}
+
+// Built-in schemes do not claim their names in the embedder registry, otherwise an
+// embedder registering the same name would panic.
+func TestBuiltinSchemesUnregistered(t *testing.T) {
+	for scheme := range builtinSources {
+		if ptrsource.Lookup(scheme) != nil {
+			t.Error("Built-in scheme registered", scheme)
+		}
+	}
+}
+
+func TestRegisteredSource(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	ar, pz := loadHTTPZone(t, "test-cmdb://cmdb.example.net/site-a?tsig=passed-thru")
+	if pz.source != testCMDB {
+		t.Fatal("Registered source not used", pz.source)
+	}
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load failed", out.String())
+	}
+	db := ar.dbGetter.Current()
+	if db.Count() != 2 || pz.added != 2 || pz.oob != 1 || pz.lines != 1 || pz.soa.Serial != 42 {
+		t.Error("Wrong counts", db.Count(), pz.added, pz.oob, pz.lines, pz.soa.Serial)
+	}
+	ptrs := dbLookupIP(db, "2001:db8::40")
+	if len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != "db1.site-a.example.net." || ptrs[0].Header().Ttl != 30 {
+		t.Error("Wrong PTR", ptrs)
+	}
+	if !strings.Contains(out.String(), "Loaded: cmdb site-a Lines=1 Deduced PTRs=2 OOB=1") {
+		t.Error("Loaded log line missing", out.String())
+	}
+
+	// Counts are per-load, not cumulative
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	if pz.added != 2 || pz.oob != 1 || testCMDB.loads != 2 {
+		t.Error("Counts accumulated across loads", pz.added, pz.oob, testCMDB.loads)
+	}
+
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); len(trigger) > 0 {
+		t.Error("Unexpected trigger", trigger)
+	}
+	testCMDB.changed = true
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); trigger != pz.url {
+		t.Error("NeedsReload did not trigger", trigger)
+	}
+
+	testCMDB.fail = true
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") || !strings.Contains(out.String(), "CMDB unavailable") {
+		t.Error("Failed source with last good data failed the load", out.String())
+	}
+
+	_, err := newPTRZoneFromURL(nil, "test-cmdb://cmdb.example.net")
+	if err == nil || !strings.Contains(err.Error(), "must contain a site") {
+		t.Error("Expected factory error, not", err)
+	}
+}
+
+func TestLastGood(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	ar, pzA := loadHTTPZone(t, "test-cmdb://cmdb.example.net/site-a?max-stale=1h")
+	cmdbA := testCMDB
+	pzB, err := newPTRZoneFromURL(nil, "test-cmdb://cmdb.example.net/site-b")
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	cmdbB := testCMDB
+	ar.cfg.PTRZones = append(ar.cfg.PTRZones, pzB)
+
+	// Initial load must succeed for all sources
+	cmdbA.fail = true
+	if ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Error("Initial load succeeded with failed source")
+	}
+	cmdbA.fail = false
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") || ar.dbGetter.Current().Count() != 4 {
+		t.Fatal("Initial load failed", out.String())
+	}
+
+	// Source A fails so its last good data is combined with fresh data from B
+	cmdbA.fail = true
+	cmdbB.site = "site-c"
+	out.Reset()
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load with last good data failed", out.String())
+	}
+	db := ar.dbGetter.Current()
+	ptrs := dbLookupIP(db, "192.0.2.40")
+	if db.Count() != 4 || len(ptrs) != 2 {
+		t.Error("Wrong mix of last good and fresh data", db.Count(), ptrs)
+	}
+	for _, ptr := range ptrs {
+		if strings.Contains(ptr.String(), "site-b") {
+			t.Error("Fresh data not used", ptr)
+		}
+	}
+	if !strings.Contains(out.String(), "using last good data of 1 PTRZones") {
+		t.Error("Last good use not logged", out.String())
+	}
+
+	// Staleness limits come from max-stale, SOA Expire or the default
+	pzB.soa.Expire = 0
+	if pzA.staleLimit() != time.Hour || pzB.staleLimit() != defaultMaxStale {
+		t.Error("Wrong staleness limits", pzA.staleLimit(), pzB.staleLimit())
+	}
+	pzB.soa.Expire = 600
+	if pzB.staleLimit() != time.Minute*10 {
+		t.Error("SOA Expire not used as staleness limit", pzB.staleLimit())
+	}
+
+	// Once stale, the data of A is dropped
+	pzA.lastGoodTime = time.Now().Add(-time.Hour * 2)
+	out.Reset()
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Load with dropped source failed", out.String())
+	}
+	if !strings.Contains(out.String(), "data dropped") || pzA.lastGood != nil {
+		t.Error("Stale data not dropped", out.String())
+	}
+	if ar.dbGetter.Current().Count() != 2 {
+		t.Error("Dropped data still loaded", ar.dbGetter.Current().Count())
+	}
+	out.Reset()
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") || strings.Contains(out.String(), "data dropped") {
+		t.Error("Dropped source failed load or was dropped again", out.String())
+	}
+
+	// And it returns once it loads again
+	cmdbA.fail = false
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	if ar.dbGetter.Current().Count() != 4 || pzA.lastGood == nil {
+		t.Error("Recovered source not loaded", ar.dbGetter.Current().Count())
+	}
+
+	// Staleness is also checked without a reload being triggered by anything else
+	cmdbA.fail = true
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now()); len(trigger) > 0 {
+		t.Error("Unexpected trigger of fresh last good data", trigger)
+	}
+	if trigger := ar.checkForReload(ar.cfg.PTRZones, time.Now().Add(time.Hour*2)); trigger != pzA.url {
+		t.Error("Stale data did not trigger reload", trigger)
+	}
+	cmdbA.fail = false
+	ar.loadAllZones(ar.cfg.PTRZones, "test")
+
+	// After the initial load, a source which has never loaded contributes no data rather
+	// than blocking fresh data from all other sources.
+	pzC, err := newPTRZoneFromURL(nil, "test-cmdb://cmdb.example.net/site-d")
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	testCMDB.fail = true
+	ar.cfg.PTRZones = append(ar.cfg.PTRZones, pzC)
+	cmdbB.site = "site-e"
+	out.Reset()
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Never loaded source blocked the load", out.String())
+	}
+	if !strings.Contains(out.String(), "without data of 1 PTRZones") {
+		t.Error("Never loaded source not logged", out.String())
+	}
+	ptrs = dbLookupIP(ar.dbGetter.Current(), "192.0.2.40")
+	if len(ptrs) != 2 || !strings.Contains(ptrs[0].String()+ptrs[1].String(), "site-e") {
+		t.Error("Fresh data not loaded", ptrs)
+	}
+
+	_, err = newPTRZoneFromURL(nil, "file:///zone?max-stale=never")
+	if err == nil || !strings.Contains(err.Error(), "max-stale") {
+		t.Error("Expected max-stale error, not", err)
+	}
+}
+
+// A watcher-triggered reload only reloads its own PTRZone, the other PTRZones contribute
+// their last good data.
+func TestZoneReload(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MinorLevel)
+
+	ar, _ := loadHTTPZone(t, "test-cmdb://cmdb.example.net/site-a")
+	cmdbA := testCMDB
+	pzB, err := newPTRZoneFromURL(nil, "test-cmdb://cmdb.example.net/site-b")
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	cmdbB := testCMDB
+	ar.cfg.PTRZones = append(ar.cfg.PTRZones, pzB)
+	if !ar.loadAllZones(ar.cfg.PTRZones, "test") {
+		t.Fatal("Initial load failed", out.String())
+	}
+
+	cmdbA.site = "site-c"
+	cmdbB.site = "site-d"
+	cmdbA.loads, cmdbB.loads = 0, 0
+	if !ar.loadZones(ar.cfg.PTRZones, map[*PTRZone]bool{pzB: true}, "test") {
+		t.Fatal("Zone reload failed", out.String())
+	}
+	if cmdbA.loads != 0 || cmdbB.loads != 1 {
+		t.Error("Wrong sources loaded", cmdbA.loads, cmdbB.loads)
+	}
+	ptrs := dbLookupIP(ar.dbGetter.Current(), "192.0.2.40")
+	if len(ptrs) != 2 {
+		t.Fatal("Expected PTRs from both sources", ptrs)
+	}
+	names := ptrs[0].String() + ptrs[1].String()
+	if !strings.Contains(names, "site-a") || !strings.Contains(names, "site-d") {
+		t.Error("Wrong mix of last good and fresh data", ptrs)
+	}
+
+	// Zone triggers queue until full, then fall back to a full reload
+	for ix := 0; ix < zoneReloadQueue+1; ix++ {
+		ar.triggerZoneReload(pzB, "test")
+	}
+	if len(ar.zoneReload) != zoneReloadQueue || len(ar.reload) != 1 {
+		t.Error("Wrong queueing of zone triggers", len(ar.zoneReload), len(ar.reload))
+	}
+}
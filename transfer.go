@@ -0,0 +1,196 @@
+package autoreverse
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnsutil"
+)
+
+const (
+	transferChunk        = 100 // RRs per transfer message - comfortably less than 64K
+	maxSynthTransferBits = 8   // Only synthesize PTRs for ipv4 zones of a /24 or smaller
+)
+
+// serveTransfer handles AXFR and IXFR queries of the authority apex. Transfers are only
+// allowed for sources in the --AXFR-allow ACL and only over TCP, with the exception of
+// an IXFR over UDP which is answered with just the SOA as per RFC1995 Section 2, which
+// either tells the secondary it's up to date or to retry over TCP.
+//
+// There is no change history, so an IXFR from a secondary which is not up to date is
+// answered with the complete zone in AXFR form which RFC1995 Section 4 allows.
+//
+// Signed zones are never transferred. Denial of existence is generated online as compact
+// NSECs, so there is no NSEC chain to transfer and a secondary could only ever serve an
+// unsigned or unprovable copy of the zone.
+func (t *server) serveTransfer(wtr dns.ResponseWriter, req *request) {
+	qType := dnsutil.TypeToString(req.question.Qtype)
+	if req.qName != req.auth.Domain {
+		req.addNote(qType + " not at apex")
+		t.serveRefused(wtr, req)
+		return
+	}
+	if t.cfg.dnssecSigner != nil {
+		req.addNote(qType + " of signed zone")
+		t.serveRefused(wtr, req)
+		return
+	}
+	if !t.aclAllows(wtr, req, t.cfg.axfrACL) {
+		req.addNote(qType + " denied")
+		t.serveRefused(wtr, req)
+		return
+	}
+
+	soa := req.soa()
+	if req.question.Qtype == dns.TypeIXFR {
+		var clientSerial uint32
+		if len(req.query.Ns) == 1 {
+			if rr, ok := req.query.Ns[0].(*dns.SOA); ok {
+				clientSerial = rr.Serial
+			}
+		}
+		if t.network != dnsutil.TCPNetwork || !serialLess(clientSerial, soa.Serial) {
+			req.addNote(fmt.Sprintf("IXFR %d/%d", clientSerial, soa.Serial))
+			t.writeTransfer(wtr, req, []dns.RR{soa})
+			return
+		}
+	}
+
+	if t.network != dnsutil.TCPNetwork {
+		req.addNote(qType + " over UDP")
+		t.serveRefused(wtr, req)
+		return
+	}
+
+	rrs := t.transferRRs(req, soa)
+	req.addNote(fmt.Sprintf("%s %d RRs", qType, len(rrs)))
+	t.writeTransfer(wtr, req, rrs)
+}
+
+// transferRRs returns the complete zone in AXFR order, that is, bookended by the SOA. The
+// zone consists of the apex NS and all database RRs within the zone, which includes
+// in-zone name server addresses courtesy of loadFromAuthorities(). Reverse ipv4 zones
+// small enough to enumerate also include the synthetic PTRs of every address not
+// otherwise present in the database.
+//
+// Database RRs which belong to a more specific authority are excluded as they will be
+// transferred as part of that zone.
+func (t *server) transferRRs(req *request, soa *dns.SOA) []dns.RR {
+	auth := req.auth
+	rrs := []dns.RR{soa}
+	rrs = append(rrs, auth.NS...)
+
+	req.db.Walk(dns.ClassINET, auth.Domain, func(rr dns.RR) {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA, dns.TypeNS:
+			return // Already have the authoritative versions
+		}
+		if req.authorities.findInDomain(rr.Header().Name) != auth {
+			return
+		}
+		if rr.Header().Rrtype == dns.TypePTR && req.updates != nil {
+			if _, ok := req.updates.lookup(rr.Header().Name); ok {
+				return // Superseded by the UPDATE overlay
+			}
+		}
+		if rr.Header().Ttl == 0 {
+			rr.Header().Ttl = t.cfg.TTLAsSecs
+		}
+		rrs = append(rrs, rr)
+	})
+
+	if req.updates != nil {
+		req.updates.walk(auth.Domain, func(name string, ptrs []dns.RR) {
+			if req.authorities.findInDomain(name) == auth {
+				rrs = append(rrs, ptrs...)
+			}
+		})
+	}
+
+	if t.cfg.synthesizeFlag {
+		rrs = append(rrs, t.synthesizeTransferPTRs(req)...)
+	}
+
+	return append(rrs, soa)
+}
+
+// synthesizeTransferPTRs returns synthetic PTRs for every address of a small ipv4
+// reverse zone which does not already have a PTR in the database.
+func (t *server) synthesizeTransferPTRs(req *request) (rrs []dns.RR) {
+	auth := req.auth
+	if auth.forward || auth.cidr == nil || auth.cidr.IP.To4() == nil {
+		return
+	}
+	ones, bits := auth.cidr.Mask.Size()
+	if bits-ones > maxSynthTransferBits {
+		return
+	}
+
+	tmpl := t.cfg.ptrTemplate(true)
+	suffix := req.ptrSuffix(auth)
+	base := auth.cidr.IP.To4()
+	for ix := 0; ix < 1<<(bits-ones); ix++ {
+		ip := net.IPv4(base[0], base[1], base[2], base[3]+byte(ix)).To4()
+		qName := dnsutil.IPToReverseQName(ip)
+		if auth.classless() {
+			qName = fmt.Sprintf("%d.%s", ip[3], auth.Domain)
+		}
+		if ar, _ := req.lookupRR(dns.ClassINET, dns.TypePTR, qName); len(ar) > 0 {
+			continue
+		}
+		ptr := tmpl.SynthesizePTR(qName, suffix, ip)
+		ptr.Hdr.Ttl = t.cfg.TTLAsSecs
+		rrs = append(rrs, ptr)
+	}
+
+	return
+}
+
+// writeTransfer sends the RRs in as many messages as needed. If the query was TSIG signed
+// then so is each message, as required by RFC8945 Section 5.3.1.
+func (t *server) writeTransfer(wtr dns.ResponseWriter, req *request, rrs []dns.RR) {
+	tsig := req.query.IsTsig()
+	req.response.SetReply(req.query)
+	req.response.Authoritative = true
+	for len(rrs) > 0 {
+		n := len(rrs)
+		if n > transferChunk {
+			n = transferChunk
+		}
+		m := new(dns.Msg)
+		m.SetReply(req.query)
+		m.Authoritative = true
+		m.Compress = true
+		m.Answer = rrs[:n]
+		rrs = rrs[n:]
+		if tsig != nil && wtr.TsigStatus() == nil {
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+		}
+		err := wtr.WriteMsg(m)
+		if err != nil {
+			req.logError = fmt.Errorf("WriteMsg failed: %s", dnsutil.ShortenLookupError(err))
+			return
+		}
+		req.msgSize += m.Len()
+		wtr.TsigTimersOnly(true)
+	}
+}
+
+// serialLess compares serial numbers according to RFC1982.
+func serialLess(a, b uint32) bool {
+	return a != b && int32(a-b) < 0
+}
+
+// nextSerial returns the serial for a new database generation. Serials are based on the
+// time so they increase across restarts, but they always increase by at least one.
+func nextSerial(previous uint32, now time.Time) uint32 {
+	serial := uint32(now.Unix())
+	if !serialLess(previous, serial) {
+		serial = previous + 1
+	}
+
+	return serial
+}
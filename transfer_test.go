@@ -0,0 +1,247 @@
+package autoreverse
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnssec"
+	"github.com/markdingo/autoreverse/dnsutil"
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+	"github.com/markdingo/autoreverse/resolver"
+)
+
+func TestTransferSerial(t *testing.T) {
+	if !serialLess(1, 2) || serialLess(2, 1) || serialLess(1, 1) {
+		t.Error("serialLess basic comparisons failed")
+	}
+	if !serialLess(0xFFFFFFF0, 5) { // RFC1982 wrap
+		t.Error("serialLess did not wrap")
+	}
+
+	now := time.Unix(1000000, 0)
+	if s := nextSerial(0, now); s != 1000000 {
+		t.Error("Expected time-based serial, not", s)
+	}
+	if s := nextSerial(1000000, now); s != 1000001 {
+		t.Error("Expected serial to increase by one, not", s)
+	}
+	if s := nextSerial(2000000, now); s != 2000001 {
+		t.Error("Serial must never go backwards, not", s)
+	}
+}
+
+// loadAllZones must bump the serial each time the database is replaced.
+func TestTransferLoadSerial(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	ar := newAutoReverse(nil, nil)
+	ar.loadAllZones(nil, "test")
+	s1 := ar.dbGetter.Current().Serial()
+	ar.loadAllZones(nil, "test")
+	s2 := ar.dbGetter.Current().Serial()
+	if s1 == 0 || !serialLess(s1, s2) {
+		t.Error("Serial not bumped by loadAllZones", s1, s2)
+	}
+}
+
+// newTransferServer creates a forward and a small ipv4 reverse with a few database RRs.
+func newTransferServer(t *testing.T, network string) (*autoReverse, *server) {
+	t.Helper()
+	res := resolver.NewResolver()
+	cfg := &config{synthesizeFlag: true, TTLAsSecs: 3600, chaosFlag: true}
+	ar := newAutoReverse(cfg, res)
+	ar.forward = "example.net."
+	fwd := ar.generateLocalForward(ar.forward)
+	fwd.NS = append(fwd.NS, newRR("example.net. IN NS ns1.example.net."))
+	fwd.A = append(fwd.A, newRR("ns1.example.net. IN A 192.0.2.1"))
+	_, ipNet, _ := net.ParseCIDR("192.0.2.0/28")
+	ar.localReverses = append(ar.localReverses, ipNet)
+	err := ar.generateLocalReverses()
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	ar.authorities.sort()
+
+	pz := &PTRZone{}
+	if !ar.loadAllZones(nil, "test") {
+		t.Fatal("Setup load failed")
+	}
+	db := ar.dbGetter.Current()
+	pz.addPTR(db, ar.authorities, newRR("3.2.0.192.in-addr.arpa. IN PTR db.example.net.").(*dns.PTR))
+	db.AddRR(newRR("www.example.net. IN TXT 'hello'"))
+
+	srv := newServer(cfg, ar.dbGetter, res, nil, network, "")
+	srv.setMutables(ar.forward, nil, ar.authorities)
+
+	return ar, srv
+}
+
+func TestTransferServe(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	_, tcpSrv := newTransferServer(t, dnsutil.TCPNetwork)
+	ar, udpSrv := newTransferServer(t, dnsutil.UDPNetwork)
+	wtr := &mock.ResponseWriter{}
+	serial := ar.dbGetter.Current().Serial()
+	allow := []*keyedACL{{ipNet: &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}}
+	keyed := []*keyedACL{{ipNet: allow[0].ipNet, keyName: "xfr.example.net."}}
+	const reverse = "0/28.2.0.192.in-addr.arpa."
+
+	testCases := []struct {
+		srv          *server
+		acl          []*keyedACL
+		qType        uint16
+		qName        string
+		clientSerial uint32
+		rCode        int
+		answers      int
+	}{
+		{tcpSrv, nil, dns.TypeAXFR, "example.net.", 0, dns.RcodeRefused, 0},   // No ACL
+		{tcpSrv, keyed, dns.TypeAXFR, "example.net.", 0, dns.RcodeRefused, 0}, // No TSIG
+		{tcpSrv, allow, dns.TypeAXFR, "www.example.net.", 0, dns.RcodeRefused, 0},
+		{tcpSrv, allow, dns.TypeAXFR, "example.net.", 0, dns.RcodeSuccess, 5}, // SOA NS A TXT SOA
+		{tcpSrv, allow, dns.TypeAXFR, reverse, 0, dns.RcodeSuccess, 19},       // SOA NS 16xPTR SOA
+		{tcpSrv, allow, dns.TypeIXFR, "example.net.", serial, dns.RcodeSuccess, 1},
+		{tcpSrv, allow, dns.TypeIXFR, "example.net.", serial - 1, dns.RcodeSuccess, 5},
+		{udpSrv, allow, dns.TypeIXFR, "example.net.", serial - 1, dns.RcodeSuccess, 1},
+		{udpSrv, allow, dns.TypeAXFR, "example.net.", 0, dns.RcodeRefused, 0},
+	}
+
+	for ix, tc := range testCases {
+		tc.srv.cfg.axfrACL = tc.acl
+		query := setQuestion(dns.ClassINET, tc.qType, tc.qName)
+		if tc.qType == dns.TypeIXFR {
+			soa := newRR(tc.qName + " IN SOA ns. mbox. 0 0 0 0 0").(*dns.SOA)
+			soa.Serial = tc.clientSerial
+			query.Ns = append(query.Ns, soa)
+		}
+		tc.srv.ServeDNS(wtr, query)
+		resp := wtr.Get()
+		if resp == nil {
+			t.Fatal(ix, "Setup error - No response to query")
+		}
+		if resp.Rcode != tc.rCode {
+			t.Error(ix, "Wrong rcode", dnsutil.RcodeToString(resp.Rcode))
+			continue
+		}
+		if len(resp.Answer) != tc.answers {
+			t.Error(ix, "Wrong answer count", len(resp.Answer), resp.Answer)
+			continue
+		}
+		if tc.answers == 0 {
+			continue
+		}
+		first, ok1 := resp.Answer[0].(*dns.SOA)
+		last, ok2 := resp.Answer[len(resp.Answer)-1].(*dns.SOA)
+		if !ok1 || !ok2 || first.Serial != serial || last.Serial != serial {
+			t.Error(ix, "Transfer not bookended by current SOA", resp.Answer)
+		}
+	}
+
+	// Check synthesis details of the classless reverse transfer
+	tcpSrv.cfg.axfrACL = allow
+	tcpSrv.ServeDNS(wtr, setQuestion(dns.ClassINET, dns.TypeAXFR, reverse))
+	resp := wtr.Get()
+	found := make(map[string]string)
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			found[ptr.Hdr.Name] = ptr.Ptr
+		}
+	}
+	if found["3."+reverse] != "db.example.net." {
+		t.Error("Database PTR should override synthetic PTR", found)
+	}
+	if found["15."+reverse] != "192-0-2-15.example.net." {
+		t.Error("Missing synthetic PTR", found)
+	}
+	if _, ok := found["16."+reverse]; ok {
+		t.Error("Synthetic PTR outside CIDR", found)
+	}
+}
+
+// Signed zones must not be transferred as the secondary would serve an unsigned copy.
+func TestTransferSigned(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	key, err := dnssec.LoadKey(writeDNSSECKey(t, t.TempDir(), dns.ED25519))
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	_, srv := newTransferServer(t, dnsutil.TCPNetwork)
+	srv.cfg.axfrACL = []*keyedACL{{ipNet: &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}}
+	srv.cfg.dnssecSigner = dnssec.NewSigner([]*dnssec.Key{key})
+
+	wtr := &mock.ResponseWriter{}
+	for _, qType := range []uint16{dns.TypeAXFR, dns.TypeIXFR} {
+		srv.ServeDNS(wtr, setQuestion(dns.ClassINET, qType, "example.net."))
+		resp := wtr.Get()
+		if resp == nil || resp.Rcode != dns.RcodeRefused || len(resp.Answer) != 0 {
+			t.Error(dnsutil.TypeToString(qType), "of signed zone not refused", resp)
+		}
+	}
+}
+
+// Full transfer over a real TCP connection with TSIG.
+func TestTransferTSIG(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	const secret = "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE="
+	ar, _ := newTransferServer(t, dnsutil.TCPNetwork)
+	key, _ := dnsutil.NewTSIGKey("xfr.example.net", "hmac-sha256", secret)
+	ar.cfg.tsigKeys = map[string]*dnsutil.TSIGKey{key.Name: key}
+	_, ipNet, _ := net.ParseCIDR("127.0.0.1/32")
+	ar.cfg.axfrACL = []*keyedACL{{ipNet: ipNet, keyName: key.Name}}
+
+	const addr = "127.0.0.1:2061"
+	srv := newServer(ar.cfg, ar.dbGetter, ar.resolver, nil, dnsutil.TCPNetwork, addr)
+	srv.setMutables(ar.forward, nil, ar.authorities)
+	err := ar.startServer(srv)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	ar.servers = append(ar.servers, srv)
+	defer ar.stopServers()
+
+	transfer := func(secrets map[string]string) (rrs []dns.RR, err error) {
+		tr := &dns.Transfer{TsigSecret: secrets}
+		m := new(dns.Msg)
+		m.SetAxfr("example.net.")
+		m.SetTsig(key.Name, key.Algorithm, 300, time.Now().Unix())
+		ch, err := tr.In(m, addr)
+		if err != nil {
+			return nil, err
+		}
+		for env := range ch {
+			if env.Error != nil {
+				return rrs, env.Error
+			}
+			rrs = append(rrs, env.RR...)
+		}
+		return rrs, nil
+	}
+
+	rrs, err := transfer(map[string]string{key.Name: secret})
+	if err != nil {
+		t.Fatal("Transfer failed", err)
+	}
+	if len(rrs) != 5 {
+		t.Error("Expected 5 RRs, got", rrs)
+	}
+
+	_, err = transfer(map[string]string{key.Name: "YmFkc2VjcmV0YmFkc2VjcmV0"})
+	if err == nil {
+		t.Error("Expected transfer with wrong secret to fail")
+	}
+}
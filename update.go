@@ -0,0 +1,467 @@
+package autoreverse
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnsutil"
+)
+
+// updateOverlay holds the PTR RRsets changed by RFC2136 UPDATEs. It is separate from the
+// database so that it survives the database being rebuilt by loadAllZones(). The overlay
+// takes precedence over the database, so an UPDATE which deletes all PTRs at a name
+// leaves an empty RRset in the overlay which hides any --PTR-deduce PTRs at that name and
+// reverts the name to synthesis.
+//
+// Changes are persisted to an append-only journal, if configured, which is compacted
+// each time it is replayed at startup. The journal also records the generation so that
+// SOA serials do not go backwards after a restart.
+type updateOverlay struct {
+	updating sync.Mutex // Serializes UPDATE processing as required by RFC2136 Section 3.7
+
+	mu         sync.RWMutex
+	journal    string              // Path, empty if not persisted
+	loaded     bool                // True once the journal has been replayed
+	ptrs       map[string][]dns.RR // Keyed by lowercase owner name
+	generation uint32              // Count of committed UPDATEs - added to SOA serials
+}
+
+const (
+	journalClear      = "clear" // Journal line verbs
+	journalPTR        = "ptr"
+	journalGeneration = "generation"
+)
+
+func newUpdateOverlay(journal string) *updateOverlay {
+	return &updateOverlay{journal: journal, loaded: len(journal) == 0,
+		ptrs: make(map[string][]dns.RR)}
+}
+
+// lookup returns copies of the overlay PTRs for qName. ok is false if the overlay has no
+// opinion about qName, in which case the database applies.
+func (t *updateOverlay) lookup(qName string) (rrs []dns.RR, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ptrs, ok := t.ptrs[strings.ToLower(qName)]
+	for _, rr := range ptrs {
+		rrs = append(rrs, dns.Copy(rr))
+	}
+
+	return
+}
+
+// serial returns the number of committed UPDATEs.
+func (t *updateOverlay) serial() uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.generation
+}
+
+func (t *updateOverlay) isLoaded() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.loaded
+}
+
+// walk calls fn with copies of all overlay RRsets at or below zone, in name order.
+func (t *updateOverlay) walk(zone string, fn func(name string, rrs []dns.RR)) {
+	t.mu.RLock()
+	var names []string
+	for name := range t.ptrs {
+		if dns.IsSubDomain(zone, name) {
+			names = append(names, name)
+		}
+	}
+	t.mu.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		rrs, ok := t.lookup(name)
+		if ok {
+			fn(name, rrs)
+		}
+	}
+}
+
+// commit appends the changed RRsets to the journal then applies them to the overlay. If
+// the journal cannot be written the overlay is left unchanged.
+func (t *updateOverlay) commit(changes map[string][]dns.RR) error {
+	if len(t.journal) > 0 {
+		f, err := os.OpenFile(t.journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		err = writeJournal(f, changes, t.serial()+1)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, rrs := range changes {
+		t.ptrs[name] = rrs
+	}
+	t.generation++
+
+	return nil
+}
+
+// loadJournal replays the journal into the overlay then compacts it by rewriting it with
+// just the current RRsets and generation. A missing journal is not an error as it's normal on first use.
+// Returns the number of names in the overlay.
+func (t *updateOverlay) loadJournal() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.loaded = true
+	if len(t.journal) == 0 {
+		return len(t.ptrs), nil
+	}
+
+	f, err := os.Open(t.journal)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		verb, rest, _ := strings.Cut(line, " ")
+		switch verb {
+		case journalClear:
+			t.ptrs[strings.ToLower(dns.CanonicalName(rest))] = []dns.RR{}
+		case journalPTR:
+			rr, err := dns.NewRR(rest)
+			if err != nil {
+				return 0, fmt.Errorf("%s:%d %w", t.journal, lineNo, err)
+			}
+			ptr, ok := rr.(*dns.PTR)
+			if !ok {
+				return 0, fmt.Errorf("%s:%d is not a PTR", t.journal, lineNo)
+			}
+			name := strings.ToLower(ptr.Hdr.Name)
+			t.ptrs[name] = append(t.ptrs[name], ptr)
+		case journalGeneration:
+			gen, err := strconv.ParseUint(rest, 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("%s:%d invalid generation '%s'", t.journal, lineNo, rest)
+			}
+			t.generation = uint32(gen)
+		default:
+			return 0, fmt.Errorf("%s:%d unknown journal entry '%s'", t.journal, lineNo, verb)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	tmp := t.journal + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	w.WriteString("# " + programName + " UPDATE journal - do not edit while running\n")
+	err = writeJournal(w, t.ptrs, t.generation)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(t.ptrs), os.Rename(tmp, t.journal)
+}
+
+// writeJournal writes each RRset as a clear line followed by a line per PTR, so that
+// replaying the journal results in the last RRset written for each name. The generation
+// line follows the RRsets so that a partially written commit does not advance it.
+func writeJournal(f *os.File, rrsets map[string][]dns.RR, generation uint32) error {
+	var names []string
+	for name := range rrsets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(f)
+	for _, name := range names {
+		fmt.Fprintln(w, journalClear, name)
+		for _, rr := range rrsets[name] {
+			fmt.Fprintln(w, journalPTR, strings.ReplaceAll(rr.String(), "\t", " "))
+		}
+	}
+	fmt.Fprintln(w, journalGeneration, generation)
+
+	return w.Flush()
+}
+
+// serveUpdate handles RFC2136 UPDATEs of PTRs within a reverse authority. UPDATEs must
+// be TSIG signed by a key permitted by --UPDATE-allow for the source address. The
+// prerequisite section is fully supported, but only PTRs can be added or deleted.
+func (t *server) serveUpdate(wtr dns.ResponseWriter, req *request) {
+	rcode := t.processUpdate(wtr, req)
+	req.response.SetRcode(req.query, rcode)
+	t.writeMsg(wtr, req)
+}
+
+// processUpdate performs all the phases of RFC2136 Section 3 and returns the rcode.
+func (t *server) processUpdate(wtr dns.ResponseWriter, req *request) int {
+	if t.updates == nil {
+		req.addNote("UPDATE not enabled")
+		return dns.RcodeNotImplemented
+	}
+	if req.question.Qtype != dns.TypeSOA || req.question.Qclass != dns.ClassINET {
+		req.addNote("UPDATE zone not SOA/IN")
+		req.stats.gen.badRequest++
+		return dns.RcodeFormatError
+	}
+	auth := req.authorities.findInDomain(req.qName)
+	if auth == nil || auth.Domain != req.qName || auth.forward {
+		req.addNote("UPDATE not reverse authority")
+		return dns.RcodeNotAuth
+	}
+	if !t.aclAllows(wtr, req, t.cfg.updateACL) {
+		req.addNote("UPDATE denied")
+		return dns.RcodeRefused
+	}
+
+	t.updates.updating.Lock()
+	defer t.updates.updating.Unlock()
+	if !t.updates.isLoaded() {
+		req.addNote("UPDATE journal not loaded")
+		return dns.RcodeServerFailure
+	}
+
+	if rcode := t.checkPrerequisites(req, auth); rcode != dns.RcodeSuccess {
+		req.addNote("UPDATE prerequisite")
+		return rcode
+	}
+	changes, rcode := t.prescanUpdates(req, auth)
+	if rcode != dns.RcodeSuccess {
+		req.addNote("UPDATE prescan")
+		return rcode
+	}
+	if len(changes) == 0 {
+		req.addNote("UPDATE no-op")
+		return dns.RcodeSuccess
+	}
+	err := t.updates.commit(changes)
+	if err != nil {
+		req.logError = fmt.Errorf("UPDATE journal write failed: %w", err)
+		return dns.RcodeServerFailure
+	}
+	req.addNote(fmt.Sprintf("UPDATE %d names", len(changes)))
+
+	return dns.RcodeSuccess
+}
+
+// currentPTRs returns the PTRs at name as modified by any preceding changes in this
+// UPDATE, then the overlay and lastly, the database.
+func currentPTRs(req *request, changes map[string][]dns.RR, name string) []dns.RR {
+	if rrs, ok := changes[name]; ok {
+		return rrs
+	}
+	rrs, _ := req.lookupRR(dns.ClassINET, dns.TypePTR, name)
+
+	return rrs
+}
+
+// currentTypes returns the types present at name with PTR reflecting the overlay.
+func currentTypes(req *request, name string) (types []uint16) {
+	for _, qType := range req.db.Types(dns.ClassINET, name) {
+		if qType != dns.TypePTR {
+			types = append(types, qType)
+		}
+	}
+	if len(currentPTRs(req, nil, name)) > 0 {
+		types = append(types, dns.TypePTR)
+	}
+
+	return
+}
+
+// checkPrerequisites implements RFC2136 Section 3.2.
+func (t *server) checkPrerequisites(req *request, auth *authority) int {
+	valueSets := make(map[string][]dns.RR) // Value dependent prerequisites keyed by name/type
+	for _, rr := range req.query.Answer {
+		hdr := rr.Header()
+		name := strings.ToLower(dns.CanonicalName(hdr.Name))
+		if hdr.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(auth.Domain, name) {
+			return dns.RcodeNotZone
+		}
+		switch hdr.Class {
+		case dns.ClassANY:
+			if hdr.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			types := currentTypes(req, name)
+			if hdr.Rrtype == dns.TypeANY {
+				if len(types) == 0 {
+					return dns.RcodeNameError
+				}
+			} else if !containsType(types, hdr.Rrtype) {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if hdr.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			types := currentTypes(req, name)
+			if hdr.Rrtype == dns.TypeANY {
+				if len(types) > 0 {
+					return dns.RcodeYXDomain
+				}
+			} else if containsType(types, hdr.Rrtype) {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			key := name + "/" + dnsutil.TypeToString(hdr.Rrtype)
+			valueSets[key] = append(valueSets[key], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	for _, want := range valueSets {
+		hdr := want[0].Header()
+		var have []dns.RR
+		if hdr.Rrtype == dns.TypePTR {
+			have = currentPTRs(req, nil, hdr.Name)
+		} else {
+			have, _ = req.db.LookupRR(dns.ClassINET, hdr.Rrtype, hdr.Name)
+		}
+		if !sameRRset(want, have) {
+			return dns.RcodeNXRrset
+		}
+	}
+
+	return dns.RcodeSuccess
+}
+
+// prescanUpdates implements RFC2136 Section 3.4.1 and returns the resulting RRset of each
+// name changed by the update section, as per Section 3.4.2. Only PTRs can be changed.
+func (t *server) prescanUpdates(req *request, auth *authority) (map[string][]dns.RR, int) {
+	changes := make(map[string][]dns.RR)
+	for _, rr := range req.query.Ns {
+		hdr := rr.Header()
+		name := strings.ToLower(dns.CanonicalName(hdr.Name))
+		if !dns.IsSubDomain(auth.Domain, name) {
+			return nil, dns.RcodeNotZone
+		}
+		switch hdr.Class {
+		case dns.ClassINET:
+			ptr, ok := rr.(*dns.PTR)
+			if !ok {
+				return nil, dns.RcodeRefused
+			}
+			ptrs := currentPTRs(req, changes, name)
+			if !containsPTR(ptrs, ptr.Ptr) {
+				ptr = dns.Copy(ptr).(*dns.PTR)
+				ptr.Hdr.Name = name
+				ptrs = append(append([]dns.RR{}, ptrs...), ptr)
+			}
+			changes[name] = ptrs
+
+		case dns.ClassANY:
+			if hdr.Ttl != 0 || hdr.Rdlength != 0 {
+				return nil, dns.RcodeFormatError
+			}
+			if hdr.Rrtype != dns.TypePTR && hdr.Rrtype != dns.TypeANY {
+				return nil, dns.RcodeRefused
+			}
+			changes[name] = []dns.RR{}
+
+		case dns.ClassNONE:
+			if hdr.Ttl != 0 {
+				return nil, dns.RcodeFormatError
+			}
+			ptr, ok := rr.(*dns.PTR)
+			if !ok {
+				return nil, dns.RcodeRefused
+			}
+			var ptrs []dns.RR
+			for _, e := range currentPTRs(req, changes, name) {
+				if !strings.EqualFold(dns.CanonicalName(e.(*dns.PTR).Ptr), dns.CanonicalName(ptr.Ptr)) {
+					ptrs = append(ptrs, e)
+				}
+			}
+			changes[name] = append([]dns.RR{}, ptrs...)
+
+		default:
+			return nil, dns.RcodeFormatError
+		}
+	}
+
+	return changes, dns.RcodeSuccess
+}
+
+func containsType(types []uint16, qType uint16) bool {
+	for _, t := range types {
+		if t == qType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsPTR(rrs []dns.RR, target string) bool {
+	for _, rr := range rrs {
+		if ptr, ok := rr.(*dns.PTR); ok &&
+			strings.EqualFold(dns.CanonicalName(ptr.Ptr), dns.CanonicalName(target)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameRRset compares RRsets ignoring TTL, class and order.
+func sameRRset(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	rdata := func(rr dns.RR) string {
+		return strings.ToLower(rr.String()[len(rr.Header().String()):])
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x.Header().Rrtype == y.Header().Rrtype && rdata(x) == rdata(y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,290 @@
+package autoreverse
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnsutil"
+	"github.com/markdingo/autoreverse/log"
+	"github.com/markdingo/autoreverse/mock"
+)
+
+// newUpdateServer is a newTransferServer with UPDATEs allowed from 127.0.0.0/8.
+func newUpdateServer(t *testing.T, journal string) (*autoReverse, *server, *dnsutil.TSIGKey) {
+	t.Helper()
+	ar, srv := newTransferServer(t, dnsutil.UDPNetwork)
+	key, _ := dnsutil.NewTSIGKey("upd.example.net", "hmac-sha256",
+		"6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE=")
+	ar.cfg.tsigKeys = map[string]*dnsutil.TSIGKey{key.Name: key}
+	ar.cfg.updateACL = []*keyedACL{{
+		ipNet:   &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+		keyName: key.Name}}
+	ar.updates = newUpdateOverlay(journal)
+	_, err := ar.updates.loadJournal()
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+	srv.updates = ar.updates
+
+	return ar, srv, key
+}
+
+// sendUpdate round-trips the UPDATE thru wire format so RRs arrive as a real server
+// sees them, then returns the response.
+func sendUpdate(t *testing.T, srv *server, key *dnsutil.TSIGKey, zone string, prereqs, updates []dns.RR) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Answer = prereqs
+	m.Ns = updates
+	if key != nil {
+		m.SetTsig(key.Name, key.Algorithm, 300, time.Now().Unix())
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal("Setup Pack", err)
+	}
+	query := new(dns.Msg)
+	err = query.Unpack(b)
+	if err != nil {
+		t.Fatal("Setup Unpack", err)
+	}
+
+	wtr := &mock.ResponseWriter{}
+	srv.ServeDNS(wtr, query)
+	resp := wtr.Get()
+	if resp == nil {
+		t.Fatal("Setup error - No response to UPDATE")
+	}
+
+	return resp
+}
+
+func queryPTR(t *testing.T, srv *server, qName string) string {
+	t.Helper()
+	wtr := &mock.ResponseWriter{}
+	srv.ServeDNS(wtr, setQuestion(dns.ClassINET, dns.TypePTR, qName))
+	resp := wtr.Get()
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return ""
+	}
+	var targets []string
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			targets = append(targets, ptr.Ptr)
+		}
+	}
+
+	return strings.Join(targets, " ")
+}
+
+func TestUpdateServe(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	const reverse = "0/28.2.0.192.in-addr.arpa."
+	ar, srv, key := newUpdateServer(t, "")
+	serial := ar.dbGetter.Current().Serial()
+	ptr5 := newRR("5." + reverse + " 300 IN PTR host5.example.com.")
+	ptr3 := newRR("3." + reverse + " 300 IN PTR host3.example.com.")
+	del := func(rr dns.RR) dns.RR {
+		m := new(dns.Msg)
+		m.SetUpdate(reverse)
+		m.Remove([]dns.RR{dns.Copy(rr)})
+		return m.Ns[0]
+	}
+	delAll := func(name string) dns.RR {
+		return &dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassANY}}
+	}
+	used := func(name string, class uint16) dns.RR {
+		return &dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: class}}
+	}
+
+	testCases := []struct {
+		key      *dnsutil.TSIGKey
+		zone     string
+		prereqs  []dns.RR
+		updates  []dns.RR
+		rCode    int
+		qName    string
+		expect   string
+		serialUp uint32
+	}{
+		{nil, reverse, nil, []dns.RR{ptr5}, dns.RcodeRefused, "5." + reverse, "192-0-2-5.example.net.", 0},
+		{key, "example.net.", nil, []dns.RR{ptr5}, dns.RcodeNotAuth, "5." + reverse, "192-0-2-5.example.net.", 0},
+		{key, "2.0.192.in-addr.arpa.", nil, []dns.RR{ptr5}, dns.RcodeNotAuth, "", "", 0},
+		{key, reverse, nil, []dns.RR{newRR("5." + reverse + " IN TXT 'x'")}, dns.RcodeRefused, "", "", 0},
+		{key, reverse, nil, []dns.RR{newRR("host.example.net. IN PTR x.")}, dns.RcodeNotZone, "", "", 0},
+		{key, reverse, []dns.RR{used("5."+reverse, dns.ClassANY)}, []dns.RR{ptr5}, dns.RcodeNXRrset, "", "", 0},
+
+		{key, reverse, []dns.RR{used("5."+reverse, dns.ClassNONE)}, []dns.RR{ptr5},
+			dns.RcodeSuccess, "5." + reverse, "host5.example.com.", 1},
+		{key, reverse, nil, []dns.RR{ptr5}, dns.RcodeSuccess, "5." + reverse, "host5.example.com.", 2}, // Dupe
+		{key, reverse, []dns.RR{used("5."+reverse, dns.ClassNONE)}, nil, dns.RcodeYXRrset, "", "", 2},
+
+		{key, reverse, []dns.RR{used("3."+reverse, dns.ClassANY)}, []dns.RR{ptr3},
+			dns.RcodeSuccess, "3." + reverse, "db.example.net. host3.example.com.", 3},
+		{key, reverse, nil, []dns.RR{del(newRR("3." + reverse + " IN PTR db.example.net."))},
+			dns.RcodeSuccess, "3." + reverse, "host3.example.com.", 4},
+		{key, reverse, nil, []dns.RR{delAll("3." + reverse)},
+			dns.RcodeSuccess, "3." + reverse, "192-0-2-3.example.net.", 5}, // Reverts to synthesis
+	}
+
+	for ix, tc := range testCases {
+		resp := sendUpdate(t, srv, tc.key, tc.zone, tc.prereqs, tc.updates)
+		if resp.Rcode != tc.rCode {
+			t.Error(ix, "Wrong rcode", dnsutil.RcodeToString(resp.Rcode), out.String())
+		}
+		if resp.Opcode != dns.OpcodeUpdate || !resp.Response {
+			t.Error(ix, "Response is not an UPDATE reply", resp.MsgHdr)
+		}
+		if (tc.key != nil) != (resp.IsTsig() != nil) {
+			t.Error(ix, "Response TSIG does not match query", resp.Extra)
+		}
+		if len(tc.qName) > 0 {
+			got := queryPTR(t, srv, tc.qName)
+			if got != tc.expect {
+				t.Error(ix, "Wrong PTR after UPDATE. Want", tc.expect, "got", got)
+			}
+		}
+		if s := ar.updates.serial(); s != tc.serialUp {
+			t.Error(ix, "Wrong UPDATE serial", s, tc.serialUp)
+		}
+	}
+
+	// SOA serial must reflect UPDATEs
+	wtr := &mock.ResponseWriter{}
+	srv.ServeDNS(wtr, setQuestion(dns.ClassINET, dns.TypeSOA, reverse))
+	resp := wtr.Get()
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.SOA).Serial != serial+5 {
+		t.Error("SOA serial not bumped by UPDATEs", serial, resp.Answer)
+	}
+
+	// Transfers must reflect the overlay
+	srv.cfg.axfrACL = []*keyedACL{{ipNet: ar.cfg.updateACL[0].ipNet}}
+	tcpSrv := newServer(srv.cfg, ar.dbGetter, ar.resolver, nil, dnsutil.TCPNetwork, "")
+	tcpSrv.setMutables(ar.forward, nil, ar.authorities)
+	tcpSrv.updates = ar.updates
+	tcpSrv.ServeDNS(wtr, setQuestion(dns.ClassINET, dns.TypeAXFR, reverse))
+	resp = wtr.Get()
+	found := make(map[string]string)
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			found[ptr.Hdr.Name] += ptr.Ptr
+		}
+	}
+	if found["5."+reverse] != "host5.example.com." || found["3."+reverse] != "192-0-2-3.example.net." {
+		t.Error("Transfer does not reflect UPDATEs", found)
+	}
+
+	// UPDATEs survive the database being rebuilt
+	ar.loadAllZones(nil, "test")
+	if got := queryPTR(t, srv, "5."+reverse); got != "host5.example.com." {
+		t.Error("UPDATE lost after reload", got)
+	}
+}
+
+// UPDATEs are not accepted unless configured.
+func TestUpdateNotEnabled(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	_, srv := newTransferServer(t, dnsutil.UDPNetwork)
+	if srv.customMsgAcceptFunc(dns.Header{Bits: dns.OpcodeUpdate << 11}) != dns.MsgRejectNotImplemented {
+		t.Error("UPDATE accepted when not enabled")
+	}
+	resp := sendUpdate(t, srv, nil, "0/28.2.0.192.in-addr.arpa.", nil, nil)
+	if resp.Rcode != dns.RcodeNotImplemented {
+		t.Error("Expected NOTIMP, not", dnsutil.RcodeToString(resp.Rcode))
+	}
+
+	srv.updates = newUpdateOverlay("")
+	if srv.customMsgAcceptFunc(dns.Header{Bits: dns.OpcodeUpdate << 11, Nscount: 10}) != dns.MsgAccept {
+		t.Error("UPDATE rejected when enabled")
+	}
+}
+
+func TestUpdateJournal(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	const reverse = "0/28.2.0.192.in-addr.arpa."
+	journal := filepath.Join(t.TempDir(), "update.journal")
+	_, srv, key := newUpdateServer(t, journal)
+	for _, s := range []string{"5", "6", "7"} {
+		resp := sendUpdate(t, srv, key, reverse, nil,
+			[]dns.RR{newRR(s + "." + reverse + " 300 IN PTR host" + s + ".example.com.")})
+		if resp.Rcode != dns.RcodeSuccess {
+			t.Fatal("Setup UPDATE", dnsutil.RcodeToString(resp.Rcode))
+		}
+	}
+	resp := sendUpdate(t, srv, key, reverse, nil,
+		[]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "6." + reverse, Rrtype: dns.TypeANY, Class: dns.ClassANY}}})
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Fatal("Setup UPDATE delete", dnsutil.RcodeToString(resp.Rcode))
+	}
+
+	soaSerial := func(srv *server) uint32 {
+		t.Helper()
+		wtr := &mock.ResponseWriter{}
+		srv.ServeDNS(wtr, setQuestion(dns.ClassINET, dns.TypeSOA, reverse))
+		resp := wtr.Get()
+		if resp == nil || len(resp.Answer) != 1 {
+			t.Fatal("No SOA in response", resp)
+		}
+		return resp.Answer[0].(*dns.SOA).Serial
+	}
+	before := soaSerial(srv)
+
+	// Replay into a new server as would happen after a restart
+	ar2, srv2, _ := newUpdateServer(t, journal)
+	if gen := ar2.updates.serial(); gen != 4 {
+		t.Error("Journal replay did not restore generation", gen)
+	}
+	if after := soaSerial(srv2); serialLess(after, before) {
+		t.Error("SOA serial went backwards after replay", before, after)
+	}
+	for qName, expect := range map[string]string{
+		"5." + reverse: "host5.example.com.",
+		"6." + reverse: "192-0-2-6.example.net.",
+		"7." + reverse: "host7.example.com.",
+	} {
+		if got := queryPTR(t, srv2, qName); got != expect {
+			t.Error("Journal replay wrong for", qName, "want", expect, "got", got)
+		}
+	}
+
+	// Replay compacts to one clear per name
+	b, err := os.ReadFile(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := strings.Count(string(b), journalClear+" "); c != 3 {
+		t.Error("Journal not compacted", c, string(b))
+	}
+	if !strings.Contains(string(b), journalGeneration+" 4\n") {
+		t.Error("Compacted journal lacks generation", string(b))
+	}
+
+	os.WriteFile(journal, []byte("bogus line\n"), 0600)
+	_, err = newUpdateOverlay(journal).loadJournal()
+	if err == nil || !strings.Contains(err.Error(), "unknown journal entry") {
+		t.Error("Expected journal error, not", err)
+	}
+
+	// An unloaded journal must not accept UPDATEs as they'd be lost on replay
+	srv2.updates = newUpdateOverlay(journal)
+	resp = sendUpdate(t, srv2, key, reverse, nil, []dns.RR{newRR("5." + reverse + " IN PTR x.")})
+	if resp.Rcode != dns.RcodeServerFailure {
+		t.Error("Expected SERVFAIL before journal load, not", dnsutil.RcodeToString(resp.Rcode))
+	}
+}
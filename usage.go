@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 import (
 	"fmt"
@@ -111,8 +111,19 @@ the SOA is mostly empty. Cannot be used when --forward is set.
 `)
 	fs.StringVar(&t.cfg.nsid, "NSID", "",
 		"Respond to EDNS NSID sub-opt with the specified string.")
+	fs.StringVar(&t.cfg.PTREthersFile, "PTR-ethers", "",
+		`ethers(5) file of MAC addresses and hostnames relative to the
+forward zone. Synthetic PTRs of EUI-64 ipv6 addresses with a listed
+MAC are named after the host.`)
+	fs.StringVar(&t.cfg.PTRKeyFile, "PTR-key", "",
+		`File containing the hex key used by {v4keyed} and {v6keyed}
+--PTR-template tokens to encrypt synthetic names. Instances
+sharing the same key file generate identical names.`)
 	fs.StringVar(&t.cfg.passthru, "passthru", "",
 		"DNS server to pass thru queries which are not in-domain.")
+	fs.StringVar(&t.cfg.UpdateJournal, "UPDATE-journal", "",
+		`File which persists --UPDATE-allow changes across restarts.
+The path is relative to --chroot, if set.`)
 	fs.StringVar(&t.cfg.user, "user", "", "Reduce privileges with setuid() after --listen.")
 
 	// config RRL StringVars - all RRL configs are set as strings so as to match the
@@ -169,8 +180,35 @@ response details (default 0).`)
 
 	// config String Arrays
 
+	fs.StringArrayVar(&t.cfg.AXFRAllow, "AXFR-allow", []string{},
+		`Allow AXFR and IXFR of all zones from this CIDR or address.
+CIDR=key-name additionally requires the transfer request to
+be signed with the named --TSIG-key.
+`)
+	fs.StringArrayVar(&t.cfg.DNSSECKeyFiles, "DNSSEC-key", []string{},
+		`Sign all zones with the ECDSAP256SHA256 or ED25519 key in this
+BIND-style key file pair, e.g. Kexample.net.+013+12345. Signed
+answers and NSEC denials are only generated for queries with
+the DO bit set.
+`)
 	fs.StringArrayVar(&t.cfg.PTRDeduceURLs, "PTR-deduce", []string{},
 		"Load zone from URL and convert address records into PTRs")
+	fs.StringArrayVar(&t.cfg.PTRTemplates, "PTR-template", []string{},
+		`Template for synthetic PTR names and the inverse parsing of
+forward queries. Tokens are {v4dash}, {v6dash}, {v6hex32},
+{v6iid} with {v6prefixhash} and the mandatory trailing
+{forward}, e.g. 'ip-{v4dash}.{forward}'. Specify at most once
+per address family.
+`)
+	fs.StringArrayVar(&t.cfg.TSIGKeyFiles, "TSIG-key", []string{},
+		`File of BIND-style TSIG key statements as generated by
+tsig-keygen. Keys are referred to by name in other options.
+`)
+	fs.StringArrayVar(&t.cfg.UpdateAllow, "UPDATE-allow", []string{},
+		`Accept RFC2136 UPDATEs of reverse PTRs from this CIDR or
+address when signed with the named --TSIG-key. Must be of the
+form CIDR=key-name.
+`)
 	fs.StringArrayVar(&t.cfg.listen, "listen", []string{},
 		`Address to listen on for DNS queries - accepts 'host:port',
 ':port', ':service', v4address:port or [v6address]:port syntax.
@@ -186,11 +224,21 @@ in the public DNS and is only visible locally where local
 resolvers are configured to direct reverse queries to
 autoreverse. How this is achieved varies greatly. See your
 resolver documentation for details.
+
+CIDR=zone-name serves the synthetic PTRs of this CIDR in
+zone-name rather than the --local-forward zone. The zone-name is
+served as an additional local forward zone.
 `)
 
 	fs.StringArrayVar(&t.cfg.delegatedReverse, "reverse", []string{},
 		`CIDR of reverse zone to discover and serve. Delegation must be
-present in the parent name servers.
+present in the parent name servers. ipv4 CIDRs of /25 to /31
+are delegated via rfc2317 classless CNAMEs. ipv6 CIDRs which
+are not a multiple of 4 are served as multiple nibble zones.
+
+CIDR=zone-name serves the synthetic PTRs of this CIDR in
+zone-name rather than the --forward zone. The zone-name is
+discovered and probed in the same way as --forward.
 `)
 
 	////////////////////////////////////////
@@ -208,6 +256,11 @@ present in the parent name servers.
 
 	dupes["PTR-deduce"] = true // These are legitimately allowed multiple times and
 	dupes["listen"] = true     // autoreverse honors all values.
+	dupes["PTR-template"] = true
+	dupes["DNSSEC-key"] = true
+	dupes["AXFR-allow"] = true
+	dupes["UPDATE-allow"] = true
+	dupes["TSIG-key"] = true
 	dupes["local"] = true
 	dupes["local-reverse"] = true
 
@@ -345,6 +398,8 @@ func printUsage(cfg *config, fs *flag.FlagSet) {
 	fmt.Fprintln(o, "     autoreverse --forward zone-name | --local-forward zone-name")
 	fmt.Fprintln(o, "                 --reverse CIDR\u2026 | --local-reverse CIDR\u2026")
 	fmt.Fprintln(o, "                 [--listen listen-address]\u2026 [--PTR-deduce URL]\u2026")
+	fmt.Fprintln(o, "                 [--PTR-template template]\u2026 [--PTR-key path]")
+	fmt.Fprintln(o, "                 [--PTR-ethers path]")
 	fmt.Fprintln(o, `                 [--passthru auth-server] [--synthesize=true]
                  [--CHAOS=true] [--NSID hostid] [--TTL time.Duration=1h]
                  [--user user-name] [--group group-name] [--chroot path]
@@ -406,7 +461,8 @@ DESCRIPTION
 
 	fmt.Fprint(o, `
 NOTES
-  1. --listen, --local-reverse, --reverse and --PTR-deduce can be repeated multiple times.
+  1. --listen, --local-reverse, --reverse, --PTR-deduce and --PTR-template can be repeated
+     multiple times.
   2. RRL is only activated when at least one of the *-psec values is set above zero.
 
 SIGNALS
@@ -1,13 +1,20 @@
-package main
+package autoreverse
 
 import (
 	"context"
 	"encoding/hex"
 	"fmt"
 	"net"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
+
+	"github.com/markdingo/autoreverse/dnssec"
+	"github.com/markdingo/autoreverse/dnsutil"
+	"github.com/markdingo/autoreverse/log"
 )
 
 // Check everything that could likely be a typo or usage error. Mostly check in order
@@ -21,6 +28,63 @@ func (t *autoReverse) ValidateCommandLineOptions() error {
 		t.cfg.PTRZones = append(t.cfg.PTRZones, pz)
 	}
 
+	t.cfg.ptrTemplate4 = nil
+	t.cfg.ptrTemplate6 = nil
+	for _, text := range t.cfg.PTRTemplates {
+		tmpl, err := dnsutil.NewPTRTemplate(text)
+		if err != nil {
+			return fmt.Errorf("--PTR-template %w", err)
+		}
+		if tmpl.IsIPv4() {
+			if t.cfg.ptrTemplate4 != nil {
+				return fmt.Errorf("--PTR-template %s duplicates ipv4 template %s",
+					text, t.cfg.ptrTemplate4)
+			}
+			t.cfg.ptrTemplate4 = tmpl
+		} else {
+			if t.cfg.ptrTemplate6 != nil {
+				return fmt.Errorf("--PTR-template %s duplicates ipv6 template %s",
+					text, t.cfg.ptrTemplate6)
+			}
+			t.cfg.ptrTemplate6 = tmpl
+		}
+	}
+
+	err := t.setPTRKey()
+	if err != nil {
+		return err
+	}
+
+	err = t.setPTREthers()
+	if err != nil {
+		return err
+	}
+
+	err = t.setDNSSECKeys()
+	if err != nil {
+		return err
+	}
+
+	err = t.setTSIGKeys()
+	if err != nil {
+		return err
+	}
+
+	err = t.setPTRZoneKeys()
+	if err != nil {
+		return err
+	}
+
+	err = t.setAXFRAllow()
+	if err != nil {
+		return err
+	}
+
+	err = t.setUpdateAllow()
+	if err != nil {
+		return err
+	}
+
 	if t.cfg.TTL < time.Second {
 		return fmt.Errorf("--TTL must be at least 1 second")
 	}
@@ -63,8 +127,9 @@ func (t *autoReverse) ValidateCommandLineOptions() error {
 		}
 	}
 
-	var err error
-	t.localReverses, err = convertReverseCIDRs("--local-reverse", t.cfg.localReverse)
+	t.reverseForwards = make(map[string]string)
+	t.localReverses, err = convertReverseCIDRs("--local-reverse", t.cfg.localReverse,
+		t.reverseForwards)
 	if err != nil {
 		return err
 	}
@@ -81,10 +146,18 @@ func (t *autoReverse) ValidateCommandLineOptions() error {
 		}
 	}
 
-	t.delegatedReverses, err = convertReverseCIDRs("--reverse", t.cfg.delegatedReverse)
+	t.delegatedReverses, err = convertReverseCIDRs("--reverse", t.cfg.delegatedReverse,
+		t.reverseForwards)
 	if err != nil {
 		return err
 	}
+
+	// Forwards mapped by --reverse are discovered so they cannot also be local.
+	for _, forward := range t.mappedForwards(t.delegatedReverses) {
+		if forward == t.cfg.localForward {
+			return fmt.Errorf("--reverse forward %s cannot also be --local-forward", forward)
+		}
+	}
 	for _, ipNet := range t.delegatedReverses {
 		if !ipNet.IP.IsGlobalUnicast() {
 			warning(nil, "--reverse", ipNet.String(), "is not a Global Unicast CIDR")
@@ -95,6 +168,19 @@ func (t *autoReverse) ValidateCommandLineOptions() error {
 		return fmt.Errorf("Must supply one of --reverse or --local-reverse")
 	}
 
+	// Templates which hash the prefix can only parse forward names if they know all
+	// possible prefixes in advance.
+	if t.cfg.ptrTemplate6 != nil {
+		for _, ipNets := range [][]*net.IPNet{t.localReverses, t.delegatedReverses} {
+			for _, ipNet := range ipNets {
+				err = t.cfg.ptrTemplate6.AddPrefix(ipNet)
+				if err != nil {
+					return fmt.Errorf("--PTR-template %w", err)
+				}
+			}
+		}
+	}
+
 	if t.cfg.maxAnswers < 0 {
 		return fmt.Errorf("--max-answers %d must not be less than zero", t.cfg.maxAnswers)
 	}
@@ -129,29 +215,221 @@ func (t *autoReverse) ValidateCommandLineOptions() error {
 	return nil
 }
 
+// setPTRKey loads the --PTR-key file and applies it to templates which encrypt
+// addresses. It's an error to have one without the other.
+func (t *autoReverse) setPTRKey() error {
+	needsKey := (t.cfg.ptrTemplate4 != nil && t.cfg.ptrTemplate4.NeedsKey()) ||
+		(t.cfg.ptrTemplate6 != nil && t.cfg.ptrTemplate6.NeedsKey())
+	if len(t.cfg.PTRKeyFile) == 0 {
+		if needsKey {
+			return fmt.Errorf("--PTR-template {v4keyed} and {v6keyed} require --PTR-key")
+		}
+		return nil
+	}
+	if !needsKey {
+		return fmt.Errorf("--PTR-key requires a --PTR-template with {v4keyed} or {v6keyed}")
+	}
+
+	b, err := os.ReadFile(t.cfg.PTRKeyFile)
+	if err != nil {
+		return fmt.Errorf("--PTR-key %w", err)
+	}
+	key, err := dnsutil.ParsePTRKey(string(b))
+	if err != nil {
+		return fmt.Errorf("--PTR-key %s %w", t.cfg.PTRKeyFile, err)
+	}
+	for _, tmpl := range []*dnsutil.PTRTemplate{t.cfg.ptrTemplate4, t.cfg.ptrTemplate6} {
+		if tmpl != nil {
+			err = tmpl.SetKey(key)
+			if err != nil {
+				return fmt.Errorf("--PTR-key %s %w", t.cfg.PTRKeyFile, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// setPTREthers loads the --PTR-ethers file used to name EUI-64 addresses. Hostnames are
+// relative to the forward zone so that every synthetic name can be resolved back to its
+// addresses, thus fully qualified hostnames are rejected.
+func (t *autoReverse) setPTREthers() error {
+	t.cfg.ptrEthers, t.cfg.ptrEthersHosts = nil, nil
+	if len(t.cfg.PTREthersFile) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(t.cfg.PTREthersFile)
+	if err != nil {
+		return fmt.Errorf("--PTR-ethers %w", err)
+	}
+	defer f.Close()
+	ethers, err := parseEthers(f, t.cfg.PTREthersFile)
+	if err != nil {
+		return fmt.Errorf("--PTR-ethers %w", err)
+	}
+
+	macs := make([]string, 0, len(ethers)) // Sort so errors and answers are stable
+	for mac := range ethers {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	hosts := make(map[string][]net.HardwareAddr)
+	for _, mac := range macs {
+		name := strings.ToLower(ethers[mac])
+		if strings.HasSuffix(name, ".") {
+			return fmt.Errorf("--PTR-ethers %s hostname %s must be relative to the forward zone",
+				t.cfg.PTREthersFile, name)
+		}
+		if _, ok := dns.IsDomainName(name + "."); !ok {
+			return fmt.Errorf("--PTR-ethers %s hostname %s is not a valid domain name",
+				t.cfg.PTREthersFile, name)
+		}
+		ethers[mac] = name
+		hw, _ := net.ParseMAC(mac) // Cannot fail as parseEthers created it
+		hosts[name] = append(hosts[name], hw)
+	}
+	t.cfg.ptrEthers, t.cfg.ptrEthersHosts = ethers, hosts
+
+	return nil
+}
+
+// setDNSSECKeys loads all --DNSSEC-key files and creates the signer used for every
+// authority. No keys means no signing.
+func (t *autoReverse) setDNSSECKeys() error {
+	t.cfg.dnssecSigner = nil
+	if len(t.cfg.DNSSECKeyFiles) == 0 {
+		return nil
+	}
+
+	var keys []*dnssec.Key
+	for _, path := range t.cfg.DNSSECKeyFiles {
+		key, err := dnssec.LoadKey(path)
+		if err != nil {
+			return fmt.Errorf("--DNSSEC-key %w", err)
+		}
+		log.Minor("DNSSEC key loaded: ", key.String())
+		keys = append(keys, key)
+	}
+	t.cfg.dnssecSigner = dnssec.NewSigner(keys)
+
+	return nil
+}
+
+// setTSIGKeys loads all keys from the --TSIG-key files. Key names must be unique across
+// all files.
+func (t *autoReverse) setTSIGKeys() error {
+	t.cfg.tsigKeys = make(map[string]*dnsutil.TSIGKey)
+	for _, path := range t.cfg.TSIGKeyFiles {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("--TSIG-key %w", err)
+		}
+		keys, err := dnsutil.ParseTSIGKeys(string(b))
+		if err != nil {
+			return fmt.Errorf("--TSIG-key %s %w", path, err)
+		}
+		for _, key := range keys {
+			if _, ok := t.cfg.tsigKeys[key.Name]; ok {
+				return fmt.Errorf("--TSIG-key %s duplicates key %s", path, key.Name)
+			}
+			t.cfg.tsigKeys[key.Name] = key
+		}
+	}
+
+	return nil
+}
+
+// setPTRZoneKeys resolves --PTR-deduce tsig=key-name parameters to keys loaded by
+// --TSIG-key.
+func (t *autoReverse) setPTRZoneKeys() error {
+	for _, pz := range t.cfg.PTRZones {
+		as, ok := pz.source.(*axfrSource)
+		if !ok || len(as.tsigKeyName) == 0 {
+			continue
+		}
+		as.tsigKey = t.cfg.tsigKeys[as.tsigKeyName]
+		if as.tsigKey == nil {
+			return fmt.Errorf("--PTR-deduce %s key %s not loaded by --TSIG-key",
+				pz.url, as.tsigKeyName)
+		}
+	}
+
+	return nil
+}
+
+// setAXFRAllow converts the --AXFR-allow CIDR[=key-name] strings into the transfer
+// ACL. Any key named must have been loaded by --TSIG-key. Signed zones cannot be
+// transferred, so --AXFR-allow is incompatible with --DNSSEC-key.
+func (t *autoReverse) setAXFRAllow() (err error) {
+	t.cfg.axfrACL, err = parseKeyedACL("--AXFR-allow", t.cfg.AXFRAllow, t.cfg.tsigKeys, false)
+	if err == nil && len(t.cfg.axfrACL) > 0 && t.cfg.dnssecSigner != nil {
+		err = fmt.Errorf("--AXFR-allow cannot be used with --DNSSEC-key as signed zones are not transferable")
+	}
+
+	return
+}
+
+// setUpdateAllow converts the --UPDATE-allow CIDR=key-name strings into the UPDATE ACL
+// and creates the overlay which holds the updated PTRs. Unlike --AXFR-allow, every entry
+// must name a key as unsigned UPDATEs are never accepted.
+func (t *autoReverse) setUpdateAllow() (err error) {
+	t.cfg.updateACL, err = parseKeyedACL("--UPDATE-allow", t.cfg.UpdateAllow, t.cfg.tsigKeys, true)
+	if err != nil {
+		return
+	}
+	if len(t.cfg.updateACL) == 0 {
+		if len(t.cfg.UpdateJournal) > 0 {
+			return fmt.Errorf("--UPDATE-journal is only meaningful with --UPDATE-allow")
+		}
+		return
+	}
+
+	t.updates = newUpdateOverlay(t.cfg.UpdateJournal)
+
+	return
+}
+
 // Given a list of --local-reverse or --reverse CIDR strings, convert them into real CIDRs
 // and confirm they are valid in our context which is largely a prefix modulo limit as
-// imposed on the way they are expressed in the reverse DNS.
-func convertReverseCIDRs(option string, cidrs []string) (ipNets []*net.IPNet, err error) {
+// imposed on the way they are expressed in the reverse DNS. Prefixes longer than a /24
+// are only expressible via RFC2317 classless delegation and ipv6 prefixes which are not
+// nibble-aligned are later split into multiple zones by dnsutil.NibbleCIDRs().
+//
+// A CIDR string may be followed by "=forward" to map the CIDR to a forward zone other
+// than the default. Such mappings are added to the forwards map keyed by CIDR.
+func convertReverseCIDRs(option string, cidrs []string, forwards map[string]string) (ipNets []*net.IPNet, err error) {
 	for _, cidr := range cidrs {
+		var forward string
+		if ix := strings.Index(cidr, "="); ix != -1 {
+			cidr, forward = cidr[:ix], cidr[ix+1:]
+			labs, is := dns.IsDomainName(forward)
+			if !is || labs < 2 {
+				err = fmt.Errorf("Invalid domain name: %s %s=%s", option, cidr, forward)
+				return
+			}
+		}
 		var ipNet *net.IPNet
 		_, ipNet, err = net.ParseCIDR(cidr)
 		if err != nil {
 			err = fmt.Errorf("%s %s:%w", option, cidr, err)
 			return
 		}
+		if len(forward) > 0 {
+			forwards[ipNet.String()] = dns.CanonicalName(forward)
+		}
 		ones, bits := ipNet.Mask.Size()
-		if bits == 32 { // ipv4 - only three possible choices
-			if ones != 24 && ones != 16 && ones != 8 {
-				err = fmt.Errorf("%s %s prefix length %d must 24, 16 or 8",
+		if bits == 32 { // ipv4 - octet boundaries or RFC2317 classless
+			if ones != 24 && ones != 16 && ones != 8 && !dnsutil.IsClassless(ipNet) {
+				err = fmt.Errorf("%s %s prefix length %d must 24, 16, 8 or 25-31",
 					option, cidr, ones)
 				return
 			}
 		}
 
 		if bits == 128 { // ipv6 - be absurdly generous in possible ranges
-			if ones%4 != 0 || ones > 124 || ones < 16 {
-				err = fmt.Errorf("%s %s prefix length %d must be a multiple of 4 and in range 16-124",
+			if ones > 124 || ones < 16 {
+				err = fmt.Errorf("%s %s prefix length %d must be in range 16-124",
 					option, cidr, ones)
 				return
 			}
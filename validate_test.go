@@ -1,6 +1,9 @@
-package main
+package autoreverse
 
 import (
+	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -175,7 +178,7 @@ func TestValidate1(t *testing.T) {
 		}
 	}
 
-	ar.cfg.localReverse = []string{"192.0.2.0/27"}
+	ar.cfg.localReverse = []string{"192.0.2.0/20"}
 	err = ar.ValidateCommandLineOptions()
 	if err == nil {
 		t.Error("Expected ipv4 prefix error")
@@ -184,7 +187,7 @@ func TestValidate1(t *testing.T) {
 			t.Error("Got wrong prefix length error", err.Error())
 		}
 	}
-	ar.cfg.localReverse = []string{"2001:db8::/33"}
+	ar.cfg.localReverse = []string{"2001:db8::/12"}
 	err = ar.ValidateCommandLineOptions()
 	if err == nil {
 		t.Error("Expected ipv6 prefix error")
@@ -214,6 +217,211 @@ Warning: --local-reverse 192.0.2.0/24 may be a Global Unicast CIDR
 	}
 }
 
+func TestValidateMappedForward(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	ar := newAutoReverse(nil, nil)
+	ar.cfg.TTL = time.Second * 2
+	ar.cfg.reportInterval = time.Second * 2
+	ar.cfg.localForward = "example.net"
+	ar.cfg.localReverse = []string{"fd2d:1::/48=Cust1.Example.Net"}
+	ar.cfg.delegatedReverse = []string{"2001:db8::/48=cust2.example.net", "2001:db8:1::/48"}
+	err := ar.ValidateCommandLineOptions()
+	if err != nil {
+		t.Fatal("Unexpected", err)
+	}
+	if len(ar.reverseForwards) != 2 {
+		t.Error("Expected two mappings, not", ar.reverseForwards)
+	}
+	if ar.reverseForward(ar.localReverses[0]) != "cust1.example.net." {
+		t.Error("Mapping not canonicalized", ar.reverseForwards)
+	}
+	if ar.reverseForward(ar.delegatedReverses[1]) != "example.net." {
+		t.Error("Unmapped reverse should use default forward", ar.reverseForwards)
+	}
+	mf := ar.mappedForwards(ar.delegatedReverses)
+	if len(mf) != 1 || mf[0] != "cust2.example.net." {
+		t.Error("Wrong delegated mapped forwards", mf)
+	}
+
+	ar.cfg.delegatedReverse = []string{"2001:db8::/48=example.net"}
+	err = ar.ValidateCommandLineOptions()
+	if err == nil || !strings.Contains(err.Error(), "cannot also be --local-forward") {
+		t.Error("Expected local-forward conflict, not", err)
+	}
+}
+
+func TestValidateTransfer(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	keyFile := filepath.Join(t.TempDir(), "xfr.key")
+	err := os.WriteFile(keyFile, []byte(`key "xfr.example.net" {
+	algorithm hmac-sha256;
+	secret "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE=";
+};
+`), 0600)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+
+	const named = "axfr://127.0.0.1/example.net?tsig=xfr.example.net"
+	const inline = "ixfr://127.0.0.1/example.net?tsig=hmac-sha1:in.example.net:c2VjcmV0c2VjcmV0"
+	testCases := []struct {
+		keyFiles []string
+		allow    []string
+		urls     []string
+		contains string
+	}{
+		{nil, []string{"192.0.2.0/24", "2001:db8::1"}, nil, ""},
+		{[]string{keyFile}, []string{"192.0.2.0/24=xfr.example.net"}, nil, ""},
+		{[]string{keyFile}, []string{"192.0.2.0/24=XFR.Example.Net."}, nil, ""},
+		{nil, []string{"192.0.2.0/24=xfr.example.net"}, nil, "unknown --TSIG-key"},
+		{nil, []string{"192.0.2.0/33"}, nil, "--AXFR-allow"},
+		{[]string{keyFile, keyFile}, nil, nil, "duplicates key"},
+		{[]string{keyFile + ".missing"}, nil, nil, "--TSIG-key"},
+		{[]string{keyFile}, nil, []string{named, inline}, ""},
+		{nil, nil, []string{named}, "not loaded by --TSIG-key"},
+		{nil, nil, []string{"axfr://127.0.0.1/example.net?tsig=a:b:c:d"}, "not of the form"},
+		{nil, nil, []string{"file:///./example.net?tsig=xfr.example.net"}, "cannot contain a tsig"},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(nil, nil)
+		ar.cfg.TTL = time.Second * 2
+		ar.cfg.reportInterval = time.Second * 2
+		ar.cfg.localForward = "example.net"
+		ar.cfg.localReverse = []string{"192.0.2.0/24"}
+		ar.cfg.TSIGKeyFiles = tc.keyFiles
+		ar.cfg.AXFRAllow = tc.allow
+		ar.cfg.PTRDeduceURLs = tc.urls
+		err := ar.ValidateCommandLineOptions()
+		if err != nil {
+			if len(tc.contains) == 0 || !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		if len(ar.cfg.axfrACL) != len(tc.allow) {
+			t.Error(ix, "ACL not populated", ar.cfg.axfrACL)
+		}
+		for _, pz := range ar.cfg.PTRZones {
+			if pz.source.(*axfrSource).tsigKey == nil {
+				t.Error(ix, "PTRZone key not set", pz.url)
+			}
+			if strings.Contains(pz.url, "c2VjcmV0") {
+				t.Error(ix, "PTRZone secret not redacted", pz.url)
+			}
+		}
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+	log.SetLevel(log.MajorLevel)
+
+	keyFile := filepath.Join(t.TempDir(), "upd.key")
+	err := os.WriteFile(keyFile, []byte(`key "upd.example.net" {
+	algorithm hmac-sha256;
+	secret "6ZdnUGpt5Jq0b8DCJZ4twc1xqKVQJtGuUy4VMhvDpoE=";
+};
+`), 0600)
+	if err != nil {
+		t.Fatal("Setup", err)
+	}
+
+	testCases := []struct {
+		allow    []string
+		journal  string
+		contains string
+	}{
+		{nil, "", ""},
+		{[]string{"192.0.2.0/24=upd.example.net"}, "", ""},
+		{[]string{"192.0.2.1=upd.example.net", "2001:db8::/64=upd.example.net"}, "upd.journal", ""},
+		{[]string{"192.0.2.0/24"}, "", "CIDR=key-name"},
+		{[]string{"192.0.2.0/24=other.example.net"}, "", "unknown --TSIG-key"},
+		{nil, "upd.journal", "only meaningful"},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(nil, nil)
+		ar.cfg.TTL = time.Second * 2
+		ar.cfg.reportInterval = time.Second * 2
+		ar.cfg.localForward = "example.net"
+		ar.cfg.localReverse = []string{"192.0.2.0/24"}
+		ar.cfg.TSIGKeyFiles = []string{keyFile}
+		ar.cfg.UpdateAllow = tc.allow
+		ar.cfg.UpdateJournal = tc.journal
+		err := ar.ValidateCommandLineOptions()
+		if err != nil {
+			if len(tc.contains) == 0 || !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		if len(ar.cfg.updateACL) != len(tc.allow) {
+			t.Error(ix, "ACL not populated", ar.cfg.updateACL)
+		}
+		if (ar.updates != nil) != (len(tc.allow) > 0) {
+			t.Error(ix, "Overlay presence wrong", ar.updates)
+		}
+		if ar.updates != nil && ar.updates.journal != tc.journal {
+			t.Error(ix, "Journal not set", ar.updates.journal)
+		}
+	}
+}
+
+func TestConvertReverseCIDRs(t *testing.T) {
+	testCases := []struct {
+		cidr     string
+		contains string
+	}{
+		{"10.0.0.0/8", ""},
+		{"192.0.2.0/24", ""},
+		{"192.0.2.128/25", ""}, // RFC2317 classless
+		{"192.0.2.64/27", ""},
+		{"192.0.2.6/31", ""},
+		{"192.0.2.0/20", "prefix length 20"},
+		{"192.0.2.1/32", "prefix length 32"},
+		{"2001:db8::/64", ""},
+		{"2001:db8::/46", ""}, // Split into nibble zones
+		{"2001:db8::/62", ""},
+		{"2001:db8::/12", "prefix length 12"},
+		{"2001:db8::/125", "prefix length 125"},
+		{"2001:db8:1::/48=cust1.example.net", ""},
+		{"2001:db8:1::/48=cust1", "Invalid domain name"},
+		{"2001:db8:1::/48=", "Invalid domain name"},
+		{"2001:db8:1::/48x=cust1.example.net", "invalid CIDR"},
+	}
+
+	for ix, tc := range testCases {
+		_, err := convertReverseCIDRs("--reverse", []string{tc.cidr}, make(map[string]string))
+		if err != nil {
+			if len(tc.contains) == 0 {
+				t.Error(ix, tc.cidr, "Unexpected error", err)
+			} else if !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, tc.cidr, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, tc.cidr, "Expected error containing", tc.contains)
+		}
+	}
+}
+
 func TestNormalizeHostPort(t *testing.T) {
 	testCases := []struct{ input, expect string }{
 		{"1.2.3.4", "1.2.3.4:domain"},
@@ -236,3 +444,139 @@ func TestNormalizeHostPort(t *testing.T) {
 		}
 	}
 }
+
+func TestValidatePTRTemplate(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+
+	testCases := []struct {
+		templates []string
+		reverse   string
+		contains  string
+	}{
+		{[]string{"ip-{v4dash}.{forward}"}, "192.0.2.0/24", ""},
+		{[]string{"ip-{v4dash}.{forward}", "{v6hex32}.hosts.{forward}"}, "2001:db8::/64", ""},
+		{[]string{"ip-{v4dash}.{forward}", "{v4dash}.{forward}"}, "192.0.2.0/24", "duplicates ipv4"},
+		{[]string{"{v6dash}.{forward}", "{v6hex32}.{forward}"}, "2001:db8::/64", "duplicates ipv6"},
+		{[]string{"ip-{v4dash}"}, "192.0.2.0/24", "must end with"},
+		{[]string{"{v6iid}-{v6prefixhash}.{forward}"}, "2001:db8::/64", ""},
+		{[]string{"{v6iid}-{v6prefixhash}.{forward}"}, "2001:db8::/48", "/64 or longer"},
+		{[]string{"{v6iid}-{v6prefixhash}.{forward}"}, "2001:db8::/48", "Use {v6dash}"},
+		{[]string{"{v6hex32}.hosts.{forward}"}, "2001:db8::/48", ""},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(nil, nil)
+		ar.cfg.TTL = time.Second
+		ar.cfg.reportInterval = time.Second
+		ar.cfg.localForward = "example.net"
+		ar.cfg.localReverse = []string{tc.reverse}
+		ar.cfg.PTRTemplates = tc.templates
+		err := ar.ValidateCommandLineOptions()
+		if err != nil {
+			if len(tc.contains) == 0 {
+				t.Error(ix, "Unexpected error", err)
+			} else if !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+		}
+	}
+}
+
+func TestValidatePTRKey(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+
+	testCases := []struct {
+		templates []string
+		keyFile   string
+		contains  string
+	}{
+		{[]string{"{v6keyed}.{forward}"}, "testdata/validate/ptr.key", ""},
+		{[]string{"ip-{v4keyed}.{forward}", "{v6keyed}.{forward}"}, "testdata/validate/ptr.key", ""},
+		{[]string{"{v6keyed}.{forward}"}, "", "require --PTR-key"},
+		{[]string{"{v6hex32}.{forward}"}, "testdata/validate/ptr.key", "requires a --PTR-template"},
+		{nil, "testdata/validate/ptr.key", "requires a --PTR-template"},
+		{[]string{"{v6keyed}.{forward}"}, "testdata/validate/noexist.key", "no such file"},
+		{[]string{"{v6keyed}.{forward}"}, "testdata/validate/bad.key", "not valid hex"},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(nil, nil)
+		ar.cfg.TTL = time.Second
+		ar.cfg.reportInterval = time.Second
+		ar.cfg.localForward = "example.net"
+		ar.cfg.localReverse = []string{"2001:db8::/64"}
+		ar.cfg.PTRTemplates = tc.templates
+		ar.cfg.PTRKeyFile = tc.keyFile
+		err := ar.ValidateCommandLineOptions()
+		if err != nil {
+			if len(tc.contains) == 0 {
+				t.Error(ix, "Unexpected error", err)
+			} else if !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		tmpl := ar.cfg.ptrTemplate(false)
+		ip := net.ParseIP("2001:db8::1")
+		name := tmpl.Synthesize(ip, ar.forward)
+		if !ip.Equal(tmpl.Parse(name, ar.forward)) {
+			t.Error(ix, "Keyed template did not round-trip", name)
+		}
+	}
+}
+
+func TestValidatePTREthers(t *testing.T) {
+	out := &mock.IOWriter{}
+	log.SetOut(out)
+
+	testCases := []struct {
+		ethersFile string
+		contains   string
+	}{
+		{"", ""},
+		{"testdata/neighbor/ethers", ""},
+		{"testdata/validate/noexist.ethers", "no such file"},
+		{"testdata/validate/bad.ethers", "bad.ethers:2 invalid MAC address"},
+		{"testdata/validate/fqdn.ethers", "printer.example.org. must be relative to the forward"},
+		{"testdata/validate/invalid.ethers", "bad..name is not a valid domain name"},
+	}
+
+	for ix, tc := range testCases {
+		ar := newAutoReverse(nil, nil)
+		ar.cfg.TTL = time.Second
+		ar.cfg.reportInterval = time.Second
+		ar.cfg.localForward = "example.net"
+		ar.cfg.localReverse = []string{"2001:db8::/64"}
+		ar.cfg.PTREthersFile = tc.ethersFile
+		err := ar.ValidateCommandLineOptions()
+		if err != nil {
+			if len(tc.contains) == 0 {
+				t.Error(ix, "Unexpected error", err)
+			} else if !strings.Contains(err.Error(), tc.contains) {
+				t.Error(ix, "Wrong error. Want", tc.contains, "got", err)
+			}
+			continue
+		}
+		if len(tc.contains) > 0 {
+			t.Error(ix, "Expected error containing", tc.contains)
+			continue
+		}
+		name := ar.cfg.ethersName(net.ParseIP("2001:db8::5054:ff:fe12:3456"), "example.net.")
+		if len(tc.ethersFile) == 0 && name != "" || len(tc.ethersFile) > 0 && name != "laptop.example.net." {
+			t.Error(ix, "Wrong ethers name", name)
+		}
+		if len(tc.ethersFile) > 0 && len(ar.cfg.ptrEthersHosts["printer.example.org"]) != 1 {
+			t.Error(ix, "Dotted hostname not relative to forward", ar.cfg.ptrEthersHosts)
+		}
+	}
+}
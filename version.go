@@ -1,4 +1,4 @@
-package main
+package autoreverse
 
 const (
 	// Version is auto-generated from ChangeLog.md